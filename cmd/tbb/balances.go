@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/paulcockrell/blockchain/consensus"
+	"github.com/paulcockrell/blockchain/database"
+	"github.com/spf13/cobra"
+)
+
+func balancesCmd() *cobra.Command {
+	var balancesCmd = &cobra.Command{
+		Use:   "balances",
+		Short: "Interact with balances (list...).",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return incorrectUsageErr()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+		},
+	}
+
+	balancesCmd.AddCommand(balancesListCmd())
+
+	return balancesCmd
+}
+
+func balancesListCmd() *cobra.Command {
+	var balancesListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "Lists all balances.",
+		Run: func(cmd *cobra.Command, args []string) {
+			state, _, err := consensus.NewState(getDataDirFromCmd(cmd), database.BlockStoreFile, consensus.SealConfig{})
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer state.Close()
+
+			fmt.Printf("Accounts balances at %x:\n", state.LatestBlockHash())
+			fmt.Println("__________________")
+			fmt.Println("")
+			for account, balance := range state.Balances {
+				fmt.Println(fmt.Sprintf("%s: %d", account.String(), balance))
+			}
+		},
+	}
+
+	addDefaultRequiredFlags(balancesListCmd)
+
+	return balancesListCmd
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/paulcockrell/blockchain/consensus"
+	"github.com/paulcockrell/blockchain/database"
+	"github.com/spf13/cobra"
+)
+
+const flagFile = "file"
+
+func chainCmd() *cobra.Command {
+	var chainCmd = &cobra.Command{
+		Use:   "chain",
+		Short: "Back up and restore blocks (export, import...).",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return incorrectUsageErr()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+		},
+	}
+
+	chainCmd.AddCommand(chainExportCmd())
+	chainCmd.AddCommand(chainImportCmd())
+
+	return chainCmd
+}
+
+func chainExportCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "export",
+		Short: "Writes the canonical blocks to a compressed and checksummed archive.",
+		// Errors are returned rather than exiting, so the state and the archive get closed
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			from, _ := cmd.Flags().GetUint64(flagFrom)
+			to, _ := cmd.Flags().GetUint64(flagTo)
+			path, _ := cmd.Flags().GetString(flagFile)
+			blockStore, _ := cmd.Flags().GetString(flagBlockStore)
+
+			state, _, err := consensus.NewState(getDataDirFromCmd(cmd), blockStore, consensus.SealConfig{})
+			if err != nil {
+				return err
+			}
+			defer state.Close()
+
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+			if err != nil {
+				return err
+			}
+
+			blocks, err := state.ExportBlocks(f, from, to)
+			if err == nil {
+				err = f.Sync()
+			}
+			closeErr := f.Close()
+			if err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				// A partial archive would block the retry, the file being created exclusively
+				os.Remove(path)
+				return err
+			}
+
+			fmt.Printf("Exported %d blocks to %s\n", blocks, path)
+
+			return nil
+		},
+	}
+
+	addDefaultRequiredFlags(cmd)
+	cmd.Flags().Uint64(flagFrom, 0, "Height of the first block to export")
+	cmd.Flags().Uint64(flagTo, math.MaxUint64, "Height of the last block to export, the latest one by default")
+	cmd.Flags().String(flagFile, "", "Path of the archive to create")
+	cmd.MarkFlagRequired(flagFile)
+	cmd.Flags().String(flagBlockStore, database.BlockStoreFile, "where blocks are stored: 'file' or 'leveldb'")
+
+	return cmd
+}
+
+func chainImportCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:           "import",
+		Short:         "Validates and adds the blocks of an archive written by chain export.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, _ := cmd.Flags().GetString(flagFile)
+			blockStore, _ := cmd.Flags().GetString(flagBlockStore)
+
+			state, _, err := consensus.NewState(getDataDirFromCmd(cmd), blockStore, consensus.SealConfig{})
+			if err != nil {
+				return err
+			}
+			defer state.Close()
+
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			blocks, err := state.ImportBlocks(f)
+			if err != nil {
+				return fmt.Errorf("imported %d blocks before failing. %s", blocks, err.Error())
+			}
+
+			fmt.Printf("Imported %d blocks, the head is now block %d '%x'\n", blocks, state.LatestBlock().Header.Number, state.LatestBlockHash())
+
+			return nil
+		},
+	}
+
+	addDefaultRequiredFlags(cmd)
+	cmd.Flags().String(flagFile, "", "Path of the archive to import")
+	cmd.MarkFlagRequired(flagFile)
+	cmd.Flags().String(flagBlockStore, database.BlockStoreFile, "where blocks are stored: 'file' or 'leveldb'")
+
+	return cmd
+}
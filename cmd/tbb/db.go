@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/paulcockrell/blockchain/consensus"
+	"github.com/paulcockrell/blockchain/database"
+	"github.com/spf13/cobra"
+)
+
+func dbCmd() *cobra.Command {
+	var dbCmd = &cobra.Command{
+		Use:   "db",
+		Short: "Maintain the node database (snapshot, verify, repair...).",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return incorrectUsageErr()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+		},
+	}
+
+	dbCmd.AddCommand(dbSnapshotCmd())
+	dbCmd.AddCommand(dbVerifyCmd())
+	dbCmd.AddCommand(dbRepairCmd())
+
+	return dbCmd
+}
+
+func dbSnapshotCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "snapshot",
+		Short: "Saves a snapshot of the latest state, loaded on the next start instead of replaying every block.",
+		Run: func(cmd *cobra.Command, args []string) {
+			state, _, err := consensus.NewState(getDataDirFromCmd(cmd), database.BlockStoreFile, consensus.SealConfig{})
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer state.Close()
+
+			path, err := state.Snapshot()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Snapshot of block %d saved to %s\n", state.LatestBlock().Header.Number, path)
+		},
+	}
+
+	addDefaultRequiredFlags(cmd)
+
+	return cmd
+}
+
+func dbVerifyCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "verify",
+		Short: "Re-validates every block of block.db and reports the first bad one.",
+		Run: func(cmd *cobra.Command, args []string) {
+			engine, err := loadEngine(getDataDirFromCmd(cmd))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			blockStore, _ := cmd.Flags().GetString(flagBlockStore)
+
+			report, err := database.VerifyBlocksDB(getDataDirFromCmd(cmd), blockStore, engine)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			printDBVerifyReport(report)
+			if report.Err != nil {
+				os.Exit(1)
+			}
+		},
+	}
+
+	addDefaultRequiredFlags(cmd)
+	cmd.Flags().String(flagBlockStore, database.BlockStoreFile, "where the node stores its blocks, only 'file' ones can be verified")
+
+	return cmd
+}
+
+func dbRepairCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "repair",
+		Short: "Truncates block.db right before its first bad block, the node syncs the rest again from its peers.",
+		Run: func(cmd *cobra.Command, args []string) {
+			engine, err := loadEngine(getDataDirFromCmd(cmd))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			blockStore, _ := cmd.Flags().GetString(flagBlockStore)
+
+			report, err := database.RepairBlocksDB(getDataDirFromCmd(cmd), blockStore, engine)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			printDBVerifyReport(report)
+			if report.Err != nil {
+				fmt.Printf("Truncated block.db from %d to %d bytes, keeping %d blocks\n", report.Size, report.ValidSize, report.Blocks)
+			}
+		},
+	}
+
+	addDefaultRequiredFlags(cmd)
+	cmd.Flags().String(flagBlockStore, database.BlockStoreFile, "where the node stores its blocks, only 'file' ones can be repaired")
+
+	return cmd
+}
+
+// loadEngine returns the consensus engine of the data dir chain, to verify its blocks.
+func loadEngine(dataDir string) (consensus.Engine, error) {
+	genesis, err := database.LoadGenesis(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return consensus.New(genesis, consensus.SealConfig{})
+}
+
+func printDBVerifyReport(report database.DBVerifyReport) {
+	if report.Err == nil {
+		fmt.Printf("All %d blocks are valid (%d bytes)\n", report.Blocks, report.Size)
+		return
+	}
+
+	fmt.Printf("%d blocks are valid, the first bad one is at offset %d", report.Blocks, report.BadOffset)
+	if !report.BadHash.IsEmpty() {
+		fmt.Printf(", block %d '%x'", report.BadNumber, report.BadHash)
+	}
+	fmt.Printf(": %s\n", report.Err)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/paulcockrell/blockchain/fs"
+	"github.com/spf13/cobra"
+)
+
+const flagDataDir = "datadir"
+const flagMiner = "miner"
+const flagIP = "ip"
+const flagPort = "port"
+const flagBootstrapAcc = "bootstrap-account"
+const flagBootstrapIP = "bootstrap-ip"
+const flagBootstrapPort = "bootstrap-port"
+const flagBlockStore = "block-store"
+const flagMinerThreads = "miner-threads"
+const flagDev = "dev"
+const flagSigner = "signer"
+
+func main() {
+	var tbbCmd = &cobra.Command{
+		Use:   "tbb",
+		Short: "The Blockchain Bar CLI",
+		Run: func(cmd *cobra.Command, args []string) {
+		},
+	}
+
+	tbbCmd.AddCommand(versionCmd)
+	tbbCmd.AddCommand(runCmd())
+	tbbCmd.AddCommand(balancesCmd())
+	tbbCmd.AddCommand(txCmd())
+	tbbCmd.AddCommand(walletCmd())
+	tbbCmd.AddCommand(dbCmd())
+	tbbCmd.AddCommand(chainCmd())
+	tbbCmd.AddCommand(minerCmd())
+	tbbCmd.AddCommand(peersCmd())
+
+	err := tbbCmd.Execute()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func addDefaultRequiredFlags(cmd *cobra.Command) {
+	cmd.Flags().String(flagDataDir, "", "Absolute path to the node data dir where the DB will be/is stored")
+	cmd.MarkFlagRequired(flagDataDir)
+}
+
+func getDataDirFromCmd(cmd *cobra.Command) string {
+	dataDir, _ := cmd.Flags().GetString(flagDataDir)
+
+	return fs.ExpandPath(dataDir)
+}
+
+func incorrectUsageErr() error {
+	return fmt.Errorf("incorrect usage")
+}
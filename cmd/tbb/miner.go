@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"time"
+
+	"github.com/paulcockrell/blockchain/consensus"
+	"github.com/paulcockrell/blockchain/database"
+	"github.com/paulcockrell/blockchain/node"
+	"github.com/spf13/cobra"
+)
+
+// The miner asks the node for new work every minerPollSeconds, dropping the
+// current one as soon as it changed.
+const minerPollSeconds = 2
+
+func minerCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "miner",
+		Short: "Mines the blocks of a running node, which pays itself the rewards.",
+		Run: func(cmd *cobra.Command, args []string) {
+			nodeAddr, _ := cmd.Flags().GetString(flagNode)
+			threads, _ := cmd.Flags().GetInt(flagMinerThreads)
+
+			ctx, stop := context.WithCancel(context.Background())
+			defer stop()
+
+			interrupt := make(chan os.Signal, 1)
+			signal.Notify(interrupt, os.Interrupt)
+			go func() {
+				<-interrupt
+				stop()
+			}()
+
+			fmt.Printf("Mining for node %s with %d workers...\n", nodeAddr, threads)
+
+			runRemoteMiner(ctx, nodeAddr, threads)
+		},
+	}
+
+	cmd.Flags().String(flagNode, fmt.Sprintf("%s:%d", node.DefaultIP, node.DefaultHTTPort), "IP:port of the node to mine for")
+	cmd.Flags().Int(flagMinerThreads, runtime.NumCPU(), "number of goroutines mining in parallel")
+
+	return cmd
+}
+
+// runRemoteMiner mines the node's work until ctx is done, submitting every
+// header found back to the node.
+func runRemoteMiner(ctx context.Context, nodeAddr string, threads int) {
+	meter := &consensus.HashMeter{}
+
+	for ctx.Err() == nil {
+		work := node.WorkRes{}
+		err := getFromNode(nodeAddr, "/miner/work", &work)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "No work from the node: %s\n", err)
+			sleepOrDone(ctx, time.Second*minerPollSeconds)
+			continue
+		}
+
+		header, err := mineWork(ctx, nodeAddr, work, threads, meter)
+		if err != nil {
+			// The node has new work, or the miner is stopping
+			continue
+		}
+
+		res := node.SubmitWorkRes{}
+		err = postToNode(nodeAddr, "/miner/submit", node.SubmitWorkReq{ID: work.ID, Nonce: header.Nonce, ExtraNonce: header.ExtraNonce}, &res)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "The node refused the mined block: %s\n", err)
+			continue
+		}
+
+		fmt.Printf("Submitted block '%s' at %.0f H/s\n", res.Hash.Hex(), meter.Rate())
+	}
+}
+
+// mineWork mines the work header, stopping once the node hands out new work.
+func mineWork(ctx context.Context, nodeAddr string, work node.WorkRes, threads int, meter *consensus.HashMeter) (database.BlockHeader, error) {
+	miningCtx, stopMining := context.WithCancel(ctx)
+	defer stopMining()
+
+	go func() {
+		ticker := time.NewTicker(time.Second * minerPollSeconds)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				latestWork := node.WorkRes{}
+				err := getFromNode(nodeAddr, "/miner/work", &latestWork)
+				if err != nil || latestWork.ID != work.ID {
+					stopMining()
+					return
+				}
+			case <-miningCtx.Done():
+				return
+			}
+		}
+	}()
+
+	return consensus.MineHeader(miningCtx, work.Header, threads, meter)
+}
+
+func sleepOrDone(ctx context.Context, d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-ctx.Done():
+	}
+}
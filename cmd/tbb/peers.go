@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/paulcockrell/blockchain/database"
+	"github.com/paulcockrell/blockchain/node"
+	"github.com/spf13/cobra"
+)
+
+const flagPeerIP = "peer-ip"
+const flagPeerPort = "peer-port"
+const flagPeerAccount = "peer-account"
+
+func peersCmd() *cobra.Command {
+	var peersCmd = &cobra.Command{
+		Use:   "peers",
+		Short: "Manages the peer book of a running node (list, add, remove, unban).",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return incorrectUsageErr()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+		},
+	}
+
+	peersCmd.AddCommand(peersListCmd())
+	peersCmd.AddCommand(peerAdminCmd("add", "Adds a peer, the node joins it on its next sync.", "/admin/peers/add"))
+	peersCmd.AddCommand(peerAdminCmd("remove", "Forgets a peer, its score and ban included.", "/admin/peers/remove"))
+	peersCmd.AddCommand(peerAdminCmd("unban", "Lets a banned peer sync and gossip again.", "/admin/peers/unban"))
+
+	return peersCmd
+}
+
+func peersListCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "list",
+		Short: "Lists the peers of the node, the best scored first.",
+		Run: func(cmd *cobra.Command, args []string) {
+			nodeAddr, _ := cmd.Flags().GetString(flagNode)
+
+			res := node.PeersRes{}
+			err := getFromNode(nodeAddr, "/admin/peers", &res)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "PEER\tSCORE\tUPTIME\tLATENCY\tBLOCKS\tINVALID\tBANNED UNTIL")
+			for _, peer := range res.Peers {
+				bannedUntil := "-"
+				if peer.IsBanned {
+					bannedUntil = peer.BannedUntil.Local().Format("2006-01-02 15:04:05")
+				}
+
+				fmt.Fprintf(w, "%s\t%d\t%.0f%%\t%.0fms\t%d\t%d\t%s\n", peer.Peer.TcpAddress(), peer.Score, peer.Uptime*100, peer.Latency, peer.ValidBlocks, peer.InvalidBlocks, bannedUntil)
+			}
+			w.Flush()
+		},
+	}
+
+	cmd.Flags().String(flagNode, fmt.Sprintf("%s:%d", node.DefaultIP, node.DefaultHTTPort), "IP:port of the node, admin endpoints only answer local requests")
+
+	return cmd
+}
+
+func peerAdminCmd(use string, short string, endpoint string) *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   use,
+		Short: short,
+		Run: func(cmd *cobra.Command, args []string) {
+			nodeAddr, _ := cmd.Flags().GetString(flagNode)
+			ip, _ := cmd.Flags().GetString(flagPeerIP)
+			port, _ := cmd.Flags().GetUint64(flagPeerPort)
+			account, _ := cmd.Flags().GetString(flagPeerAccount)
+
+			req := node.PeerAdminReq{IP: ip, Port: port, Account: database.NewAccount(account)}
+			res := node.PeerAdminRes{}
+			err := postToNode(nodeAddr, endpoint, req, &res)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Peer %s:%d: %s done.\n", ip, port, use)
+		},
+	}
+
+	cmd.Flags().String(flagNode, fmt.Sprintf("%s:%d", node.DefaultIP, node.DefaultHTTPort), "IP:port of the node, admin endpoints only answer local requests")
+	cmd.Flags().String(flagPeerIP, "", "IP of the peer")
+	cmd.MarkFlagRequired(flagPeerIP)
+	cmd.Flags().Uint64(flagPeerPort, 0, "HTTP port of the peer")
+	cmd.MarkFlagRequired(flagPeerPort)
+	if use == "add" {
+		cmd.Flags().String(flagPeerAccount, node.DefaultMiner, "account of the peer")
+	}
+
+	return cmd
+}
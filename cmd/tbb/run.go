@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/paulcockrell/blockchain/database"
+	"github.com/paulcockrell/blockchain/node"
+	"github.com/paulcockrell/blockchain/wallet"
+	"github.com/spf13/cobra"
+)
+
+func runCmd() *cobra.Command {
+	var runCmd = &cobra.Command{
+		Use:   "run",
+		Short: "Launches the TBB node and its HTTP API.",
+		Run: func(cmd *cobra.Command, args []string) {
+			miner, _ := cmd.Flags().GetString(flagMiner)
+			ip, _ := cmd.Flags().GetString(flagIP)
+			port, _ := cmd.Flags().GetUint64(flagPort)
+			bootstrapIP, _ := cmd.Flags().GetString(flagBootstrapIP)
+			bootstrapPort, _ := cmd.Flags().GetUint64(flagBootstrapPort)
+			bootstrapAcc, _ := cmd.Flags().GetString(flagBootstrapAcc)
+			blockStore, _ := cmd.Flags().GetString(flagBlockStore)
+			minerThreads, _ := cmd.Flags().GetInt(flagMinerThreads)
+			isDev, _ := cmd.Flags().GetBool(flagDev)
+			signer, _ := cmd.Flags().GetString(flagSigner)
+
+			// The dev chain rewards its prefunded account unless told otherwise
+			if isDev && !cmd.Flags().Changed(flagMiner) {
+				miner = wallet.DevAccount
+			}
+
+			fmt.Println("Launching TBB node and its HTTP API...")
+
+			bootstrap := node.NewPeerNode(
+				bootstrapIP,
+				bootstrapPort,
+				true,
+				database.NewAccount(bootstrapAcc),
+				false,
+			)
+
+			n := node.New(getDataDirFromCmd(cmd), ip, port, database.NewAccount(miner), bootstrap)
+			n.UseBlockStore(blockStore)
+			n.SetMinerWorkers(minerThreads)
+			if signer != "" {
+				signerKey, err := unlockSigner(getDataDirFromCmd(cmd), signer)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+				n.SetSignerKey(signerKey)
+			}
+			if isDev {
+				fmt.Printf("Developer mode: TXs are mined right away, the account %s holds the tokens.\n", wallet.DevAccount)
+				fmt.Printf("Its password is '%s' and its private key %s.\n", wallet.DevAccountPwd, wallet.DevPrivateKey)
+				n.EnableDevMode()
+			}
+			err := n.Run(context.Background())
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	addDefaultRequiredFlags(runCmd)
+	runCmd.Flags().String(flagMiner, node.DefaultMiner, "miner account of this node to receive block rewards")
+	runCmd.Flags().String(flagIP, node.DefaultIP, "exposed IP for communication with peers")
+	runCmd.Flags().Uint64(flagPort, node.DefaultHTTPort, "exposed HTTP port for communication with peers")
+	runCmd.Flags().String(flagBootstrapIP, node.DefaultBootstrapIP, "default bootstrap server to interconnect peers")
+	runCmd.Flags().Uint64(flagBootstrapPort, node.DefaultBootstrapPort, "default bootstrap server port to interconnect peers")
+	runCmd.Flags().String(flagBootstrapAcc, node.DefaultBootstrapAcc, "default bootstrap Genesis account with 1M TBB tokens")
+	runCmd.Flags().String(flagBlockStore, database.BlockStoreFile, "where blocks are stored: 'file', 'leveldb' or 'memory'")
+	runCmd.Flags().Int(flagMinerThreads, runtime.NumCPU(), "number of goroutines mining blocks in parallel")
+	runCmd.Flags().String(flagSigner, "", "keystore account signing the blocks of a proof of authority chain, one of its genesis signers")
+	runCmd.Flags().Bool(flagDev, false, "runs a local development chain mining every TX right away, the datadir must be new or a dev one")
+
+	return runCmd
+}
+
+// unlockSigner decrypts the key of the signer account from the data dir keystore.
+func unlockSigner(dataDir, signer string) (*ecdsa.PrivateKey, error) {
+	password, err := getPassPhrase(fmt.Sprintf("Please enter the password to decrypt the %s signer account:", signer), false)
+	if err != nil {
+		return nil, err
+	}
+
+	return wallet.GetKeystoreKey(database.NewAccount(signer), password, wallet.GetKeystoreDirPath(dataDir))
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/paulcockrell/blockchain/database"
+	"github.com/paulcockrell/blockchain/node"
+	"github.com/paulcockrell/blockchain/wallet"
+	"github.com/spf13/cobra"
+)
+
+const flagFrom = "from"
+const flagTo = "to"
+const flagValue = "value"
+const flagData = "data"
+const flagNode = "node"
+const flagNonce = "nonce"
+const flagFee = "fee"
+
+func txCmd() *cobra.Command {
+	var txsCmd = &cobra.Command{
+		Use:   "tx",
+		Short: "Interact with txs (add, submit...).",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return incorrectUsageErr()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+		},
+	}
+
+	txsCmd.AddCommand(txAddCmd())
+	txsCmd.AddCommand(txSubmitCmd())
+
+	return txsCmd
+}
+
+func txAddCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "add",
+		Short: "Adds new TX to the pending TXs of a running node.",
+		Run: func(cmd *cobra.Command, args []string) {
+			from, _ := cmd.Flags().GetString(flagFrom)
+			to, _ := cmd.Flags().GetString(flagTo)
+			value, _ := cmd.Flags().GetUint(flagValue)
+			fee, _ := cmd.Flags().GetUint(flagFee)
+			data, _ := cmd.Flags().GetString(flagData)
+			nodeAddr, _ := cmd.Flags().GetString(flagNode)
+
+			password, err := getPassPhrase(fmt.Sprintf("Please enter the password to decrypt the %s account:", from), false)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			req := node.TxAddReq{
+				From:    from,
+				FromPwd: password,
+				To:      to,
+				Value:   value,
+				Fee:     fee,
+				Data:    data,
+			}
+
+			err = sendTxAddReq(nodeAddr, req)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Println("TX successfully added to the pending TXs.")
+		},
+	}
+
+	cmd.Flags().String(flagFrom, "", "From what account to send tokens")
+	cmd.MarkFlagRequired(flagFrom)
+
+	cmd.Flags().String(flagTo, "", "To what account to send tokens")
+	cmd.MarkFlagRequired(flagTo)
+
+	cmd.Flags().Uint(flagValue, 0, "How many tokens to send")
+	cmd.MarkFlagRequired(flagValue)
+
+	cmd.Flags().Uint(flagFee, 0, "How many tokens to pay the miner, see the node's /tx/fee-estimate")
+
+	cmd.Flags().String(flagData, "", fmt.Sprintf("Possible values: 'reward', or '%s' and '%s' for a proof of authority signer voting on the signer in --to", database.TxDataVoteAddSigner, database.TxDataVoteRemoveSigner))
+	cmd.Flags().String(flagNode, fmt.Sprintf("%s:%d", node.DefaultIP, node.DefaultHTTPort), "IP:port of the node to submit the TX to")
+
+	return cmd
+}
+
+func txSubmitCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "submit",
+		Short: "Signs a new TX with a local keystore account and submits it to a running node.",
+		Run: func(cmd *cobra.Command, args []string) {
+			from, _ := cmd.Flags().GetString(flagFrom)
+			to, _ := cmd.Flags().GetString(flagTo)
+			value, _ := cmd.Flags().GetUint(flagValue)
+			fee, _ := cmd.Flags().GetUint(flagFee)
+			nonce, _ := cmd.Flags().GetUint(flagNonce)
+			data, _ := cmd.Flags().GetString(flagData)
+			nodeAddr, _ := cmd.Flags().GetString(flagNode)
+
+			password, err := getPassPhrase(fmt.Sprintf("Please enter the password to decrypt the %s account:", from), false)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fromAcc := database.NewAccount(from)
+			tx := database.NewTxWithFee(fromAcc, database.NewAccount(to), value, nonce, data, fee)
+
+			signedTx, err := wallet.SignTxWithKeystoreAccount(tx, fromAcc, password, wallet.GetKeystoreDirPath(getDataDirFromCmd(cmd)))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			txHash, err := sendTxSubmitReq(nodeAddr, signedTx)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("TX '%s' successfully submitted to the pending TXs.\n", txHash.Hex())
+		},
+	}
+
+	addDefaultRequiredFlags(cmd)
+
+	cmd.Flags().String(flagFrom, "", "From what account to send tokens")
+	cmd.MarkFlagRequired(flagFrom)
+
+	cmd.Flags().String(flagTo, "", "To what account to send tokens")
+	cmd.MarkFlagRequired(flagTo)
+
+	cmd.Flags().Uint(flagValue, 0, "How many tokens to send")
+	cmd.MarkFlagRequired(flagValue)
+
+	cmd.Flags().Uint(flagFee, 0, "How many tokens to pay the miner, see the node's /tx/fee-estimate")
+
+	cmd.Flags().Uint(flagNonce, 0, "Next nonce of the 'from' account, counting its pending TXs")
+	cmd.MarkFlagRequired(flagNonce)
+
+	cmd.Flags().String(flagData, "", fmt.Sprintf("Possible values: 'reward', or '%s' and '%s' for a proof of authority signer voting on the signer in --to", database.TxDataVoteAddSigner, database.TxDataVoteRemoveSigner))
+	cmd.Flags().String(flagNode, fmt.Sprintf("%s:%d", node.DefaultIP, node.DefaultHTTPort), "IP:port of the node to submit the TX to")
+
+	return cmd
+}
+
+func sendTxAddReq(nodeAddr string, req node.TxAddReq) error {
+	txAddRes := node.TxAddRes{}
+	err := postToNode(nodeAddr, "/tx/add", req, &txAddRes)
+	if err != nil {
+		return err
+	}
+
+	if !txAddRes.Success {
+		return fmt.Errorf("node refused to add the TX")
+	}
+
+	return nil
+}
+
+func sendTxSubmitReq(nodeAddr string, tx database.SignedTx) (database.Hash, error) {
+	txSubmitRes := node.TxSubmitRes{}
+	err := postToNode(nodeAddr, "/tx/submit", tx, &txSubmitRes)
+	if err != nil {
+		return database.Hash{}, err
+	}
+
+	if !txSubmitRes.Success {
+		return database.Hash{}, fmt.Errorf("node refused to add the TX")
+	}
+
+	return txSubmitRes.Hash, nil
+}
+
+// postToNode sends req as JSON to the node's endpoint and decodes the response into res.
+func postToNode(nodeAddr string, endpoint string, req interface{}, res interface{}) error {
+	reqJSON, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	httpRes, err := http.Post(fmt.Sprintf("http://%s%s", nodeAddr, endpoint), "application/json", bytes.NewReader(reqJSON))
+	if err != nil {
+		return err
+	}
+
+	return readNodeRes(httpRes, res)
+}
+
+// getFromNode queries the node's endpoint and decodes the response into res.
+func getFromNode(nodeAddr string, endpoint string, res interface{}) error {
+	httpRes, err := http.Get(fmt.Sprintf("http://%s%s", nodeAddr, endpoint))
+	if err != nil {
+		return err
+	}
+
+	return readNodeRes(httpRes, res)
+}
+
+// readNodeRes decodes a node response into res, or the node's error.
+func readNodeRes(httpRes *http.Response, res interface{}) error {
+	defer httpRes.Body.Close()
+
+	resJSON, err := ioutil.ReadAll(httpRes.Body)
+	if err != nil {
+		return fmt.Errorf("unable to read response body. %s", err.Error())
+	}
+
+	if httpRes.StatusCode != http.StatusOK {
+		errRes := node.ErrRes{}
+		err = json.Unmarshal(resJSON, &errRes)
+		if err != nil {
+			return fmt.Errorf("unable to unmarshal response body. %s", err.Error())
+		}
+
+		return errors.New(errRes.Error)
+	}
+
+	err = json.Unmarshal(resJSON, res)
+	if err != nil {
+		return fmt.Errorf("unable to unmarshal response body. %s", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/paulcockrell/blockchain/wallet"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+func walletCmd() *cobra.Command {
+	var walletCmd = &cobra.Command{
+		Use:   "wallet",
+		Short: "Manages blockchain accounts and keys.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return incorrectUsageErr()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+		},
+	}
+
+	walletCmd.AddCommand(walletNewAccountCmd())
+	walletCmd.AddCommand(walletListCmd())
+
+	return walletCmd
+}
+
+func walletNewAccountCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "new-account",
+		Short: "Creates a new account with a new set of elliptic-curve Private + Public keys.",
+		Run: func(cmd *cobra.Command, args []string) {
+			password, err := getPassPhrase("Please enter a password to encrypt the new wallet:", true)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			dataDir := getDataDirFromCmd(cmd)
+
+			acc, err := wallet.NewKeystoreAccount(dataDir, password)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("New account created: %s\n", acc.Hex())
+			fmt.Printf("Saved in: %s\n", wallet.GetKeystoreDirPath(dataDir))
+		},
+	}
+
+	addDefaultRequiredFlags(cmd)
+
+	return cmd
+}
+
+func walletListCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "list",
+		Short: "Lists all accounts stored in the node keystore.",
+		Run: func(cmd *cobra.Command, args []string) {
+			ks := keystore.NewKeyStore(
+				wallet.GetKeystoreDirPath(getDataDirFromCmd(cmd)),
+				keystore.StandardScryptN,
+				keystore.StandardScryptP,
+			)
+
+			for _, acc := range ks.Accounts() {
+				fmt.Printf("%s\t%s\n", acc.Address.Hex(), acc.URL.Path)
+			}
+		},
+	}
+
+	addDefaultRequiredFlags(cmd)
+
+	return cmd
+}
+
+// getPassPhrase prompts for a password without echoing it back to the terminal,
+// optionally asking a second time to confirm it.
+func getPassPhrase(prompt string, confirmation bool) (string, error) {
+	fmt.Println(prompt)
+
+	password, err := readPassword("Password: ")
+	if err != nil {
+		return "", err
+	}
+
+	if confirmation {
+		confirm, err := readPassword("Repeat password: ")
+		if err != nil {
+			return "", err
+		}
+
+		if password != confirm {
+			return "", fmt.Errorf("passwords do not match")
+		}
+	}
+
+	return password, nil
+}
+
+func readPassword(prompt string) (string, error) {
+	fmt.Print(prompt)
+	defer fmt.Println()
+
+	password, err := terminal.ReadPassword(int(syscall.Stdin))
+	if err != nil {
+		return "", fmt.Errorf("unable to read password. %s", err.Error())
+	}
+
+	return string(password), nil
+}
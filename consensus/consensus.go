@@ -0,0 +1,67 @@
+package consensus
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+
+	"github.com/paulcockrell/blockchain/database"
+)
+
+// Engine builds, seals and verifies blocks. The State only needs the
+// database.Engine part to validate and apply them.
+type Engine interface {
+	database.Engine
+
+	// Prepare fills the consensus fields of a header to build on top of its parent
+	Prepare(chain database.ChainReader, header *database.BlockHeader) error
+	// Seal returns the header once sealed, or an error once ctx is done
+	Seal(ctx context.Context, header database.BlockHeader) (database.BlockHeader, error)
+}
+
+// SealConfig is how this node seals blocks, the engine of the chain only
+// uses its own settings.
+type SealConfig struct {
+	// Workers mining a block in parallel and the meter measuring their hashrate, see NewPoW
+	Workers int
+	Meter   *HashMeter
+
+	// SignerKey signs blocks under proof of authority, nodes without one only verify them
+	SignerKey *ecdsa.PrivateKey
+}
+
+// New returns the engine the genesis chose.
+func New(genesis database.Genesis, cfg SealConfig) (Engine, error) {
+	switch genesis.Consensus {
+	case "", database.ConsensusPoW:
+		return NewPoW(cfg.Workers, cfg.Meter), nil
+	case database.ConsensusPoA:
+		if genesis.PoA == nil {
+			return nil, fmt.Errorf("the %s consensus needs its 'poa' config", database.ConsensusPoA)
+		}
+
+		return NewPoA(*genesis.PoA, cfg.SignerKey), nil
+	}
+
+	return nil, fmt.Errorf("unknown consensus '%s'", genesis.Consensus)
+}
+
+// NewState loads the State of the data dir with the engine its genesis chose.
+func NewState(dataDir string, blockStore string, cfg SealConfig) (*database.State, Engine, error) {
+	genesis, err := database.LoadGenesis(dataDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	engine, err := New(genesis, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	state, err := database.NewStateWithEngine(dataDir, blockStore, engine)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return state, engine, nil
+}
@@ -0,0 +1,202 @@
+package consensus
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/paulcockrell/blockchain/database"
+)
+
+// The in-turn signer's blocks weigh more, so the chain follows the rotation
+// whenever the in-turn signer is online.
+const diffInTurn = 2
+const diffNoTurn = 1
+
+// Out-of-turn signers wait up to outOfTurnWiggle per half of the signers on
+// top of the period, giving the in-turn signer the time to seal its block and
+// each other a chance not to seal at the same time.
+const outOfTurnWiggle = time.Millisecond * 500
+
+// Blocks can't be dated further than maxClockDrift past the local clock, or a
+// signer could skip the period by dating its blocks ahead.
+const maxClockDrift = time.Second * 15
+
+// PoA seals blocks by signing them instead of mining them. The signers listed
+// in genesis take turns sealing a block every period, and vote with TXs to add
+// or remove signers. They earn the block rewards.
+type PoA struct {
+	period    uint64
+	snapshots *snapshotCache
+	signerKey *ecdsa.PrivateKey
+}
+
+// NewPoA returns the proof of authority engine, signing blocks with signerKey
+// when it isn't nil.
+func NewPoA(config database.PoAConfig, signerKey *ecdsa.PrivateKey) *PoA {
+	return &PoA{
+		period:    config.Period,
+		snapshots: newSnapshotCache(config),
+		signerKey: signerKey,
+	}
+}
+
+// Signers returns the accounts allowed to seal the block following parent.
+func (poa *PoA) Signers(chain database.ChainReader, parent database.Hash) ([]common.Address, error) {
+	snap, err := poa.snapshots.get(chain, parent)
+	if err != nil {
+		return nil, err
+	}
+
+	signers := make([]common.Address, len(snap.signers))
+	copy(signers, snap.signers)
+
+	return signers, nil
+}
+
+// Prepare makes this node's signer the miner of the header, and delays the
+// header until the period since its parent passed.
+func (poa *PoA) Prepare(chain database.ChainReader, header *database.BlockHeader) error {
+	if poa.signerKey == nil {
+		return fmt.Errorf("the node has no signer key to seal blocks")
+	}
+	signer := crypto.PubkeyToAddress(poa.signerKey.PublicKey)
+
+	snap, err := poa.snapshots.get(chain, header.Parent)
+	if err != nil {
+		return err
+	}
+
+	if !snap.isSigner(signer) {
+		return fmt.Errorf("'%s' isn't an authorized signer", signer.Hex())
+	}
+
+	if snap.signedRecently(header.Number, signer) {
+		return fmt.Errorf("'%s' signed recently, it's the turn of the other signers", signer.Hex())
+	}
+
+	header.Miner = signer
+	header.Difficulty = diffNoTurn
+	if snap.isInTurn(header.Number, signer) {
+		header.Difficulty = diffInTurn
+	}
+
+	if !header.Parent.IsEmpty() {
+		parent, isKnown := chain.GetBlockInfo(header.Parent)
+		if !isKnown {
+			return fmt.Errorf("block parent '%x' is unknown", header.Parent)
+		}
+
+		if header.Time < parent.Time+poa.period {
+			header.Time = parent.Time + poa.period
+		}
+	}
+
+	return nil
+}
+
+// Seal waits until the header time, longer when out of turn, then signs the
+// header. It gives up once ctx is done.
+func (poa *PoA) Seal(ctx context.Context, header database.BlockHeader) (database.BlockHeader, error) {
+	if poa.signerKey == nil {
+		return database.BlockHeader{}, fmt.Errorf("the node has no signer key to seal blocks")
+	}
+
+	delay := time.Until(time.Unix(int64(header.Time), 0))
+	if header.Difficulty == diffNoTurn {
+		signers := 1
+		if snap, isCached := poa.snapshots.peek(header.Parent); isCached {
+			signers = len(snap.signers)
+		}
+
+		delay += time.Duration(rand.Int63n(int64(signers/2+1) * int64(outOfTurnWiggle)))
+	}
+
+	if delay > 0 {
+		fmt.Printf("Sealing block %d in %s\n", header.Number, delay.Round(time.Millisecond))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return database.BlockHeader{}, ctx.Err()
+		}
+	}
+
+	sealHash, err := header.SealHash()
+	if err != nil {
+		return database.BlockHeader{}, err
+	}
+
+	sig, err := crypto.Sign(sealHash[:], poa.signerKey)
+	if err != nil {
+		return database.BlockHeader{}, err
+	}
+	header.Signature = sig
+
+	return header, nil
+}
+
+func (poa *PoA) VerifyHeader(chain database.ChainReader, header database.BlockHeader) error {
+	if header.Nonce != 0 || header.ExtraNonce != 0 {
+		return fmt.Errorf("signed blocks aren't mined, their nonces must be 0")
+	}
+
+	signer, err := header.Signer()
+	if err != nil {
+		return fmt.Errorf("invalid block signature. %s", err.Error())
+	}
+
+	if header.Miner != signer {
+		return fmt.Errorf("block miner '%s' must be its signer '%s'", header.Miner.Hex(), signer.Hex())
+	}
+
+	snap, err := poa.snapshots.get(chain, header.Parent)
+	if err != nil {
+		return err
+	}
+
+	if !snap.isSigner(signer) {
+		return fmt.Errorf("block signer '%s' isn't authorized", signer.Hex())
+	}
+
+	if snap.signedRecently(header.Number, signer) {
+		return fmt.Errorf("block signer '%s' signed recently", signer.Hex())
+	}
+
+	expectedDifficulty := uint64(diffNoTurn)
+	if snap.isInTurn(header.Number, signer) {
+		expectedDifficulty = diffInTurn
+	}
+
+	if header.Difficulty != expectedDifficulty {
+		return fmt.Errorf("block difficulty must be '%d' not '%d'", expectedDifficulty, header.Difficulty)
+	}
+
+	maxTime := uint64(time.Now().Add(maxClockDrift).Unix())
+	if header.Time > maxTime {
+		return fmt.Errorf("block time %d is more than %s ahead of the local clock", header.Time, maxClockDrift)
+	}
+
+	if !header.Parent.IsEmpty() {
+		parent, isKnown := chain.GetBlockInfo(header.Parent)
+		if !isKnown {
+			return fmt.Errorf("block parent '%x' is unknown", header.Parent)
+		}
+
+		if header.Time < parent.Time+poa.period {
+			return fmt.Errorf("block time %d must be at least %d seconds after its parent's %d", header.Time, poa.period, parent.Time)
+		}
+	}
+
+	return nil
+}
+
+func (poa *PoA) Finalize(genesis database.Genesis, b database.Block, balances map[common.Address]uint) {
+	balances[b.Header.Miner] += genesis.BlockReward + b.Fees()
+}
@@ -0,0 +1,360 @@
+package consensus
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/paulcockrell/blockchain/database"
+	"github.com/paulcockrell/blockchain/wallet"
+)
+
+const testPeriod = 5
+
+// A block time far enough in the past for the signers not to wait
+var testStartTime = uint64(time.Now().Unix()) - 3600
+
+func TestPoA_SealAndImport(t *testing.T) {
+	signerKey, signer := generateTestKey(t)
+	outsiderKey, _ := generateTestKey(t)
+
+	dataDir, s, engine := setupTestPoAState(t, signerKey, signer)
+	defer os.RemoveAll(dataDir)
+	defer s.Close()
+
+	header := database.BlockHeader{Time: uint64(time.Now().Unix())}
+	err := engine.Prepare(s, &header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header.Miner != signer {
+		t.Fatalf("the signer '%s' should be the miner, not '%s'", signer.Hex(), header.Miner.Hex())
+	}
+
+	block := newTestBlock(t, s, header)
+	block.Header, err = engine.Seal(context.Background(), block.Header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = s.ImportBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Balances[signer] != database.DefaultBlockReward {
+		t.Fatalf("the signer should earn the block reward %d, not %d", database.DefaultBlockReward, s.Balances[signer])
+	}
+
+	// Anyone else can't seal, nor sign, blocks
+	outsider := NewPoA(*s.Genesis().PoA, outsiderKey)
+	forgedHeader := database.BlockHeader{Parent: s.LatestBlockHash(), Number: 1, Time: header.Time + testPeriod}
+	err = outsider.Prepare(s, &forgedHeader)
+	if err == nil {
+		t.Fatal("a key not listed in genesis should not seal blocks")
+	}
+
+	forgedHeader.Miner = crypto.PubkeyToAddress(outsiderKey.PublicKey)
+	forgedHeader.Difficulty = diffInTurn
+	forged := newTestBlock(t, s, forgedHeader)
+	forged.Header.Signature = signTestHeader(t, forged.Header, outsiderKey)
+
+	_, _, err = s.ImportBlock(forged)
+	if err == nil || !strings.Contains(err.Error(), "isn't authorized") {
+		t.Fatalf("a block signed by an unauthorized signer should be rejected, got %v", err)
+	}
+
+	// Tampering with a signed header invalidates its signature
+	tampered := block.Header
+	tampered.Time++
+	err = engine.VerifyHeader(s, tampered)
+	if err == nil {
+		t.Fatal("a tampered header should not verify")
+	}
+}
+
+func TestPoA_SignersTakeTurns(t *testing.T) {
+	keys := make(map[common.Address]*ecdsa.PrivateKey)
+	signers := make([]common.Address, 3)
+	for i := range signers {
+		var key *ecdsa.PrivateKey
+		key, signers[i] = generateTestKey(t)
+		keys[signers[i]] = key
+	}
+	sortSigners(signers)
+
+	dataDir, s, _ := setupTestPoAState(t, nil, signers...)
+	defer os.RemoveAll(dataDir)
+	defer s.Close()
+
+	// Each signer seals in turn, the in-turn blocks weighing the most
+	for number := uint64(0); number < 3; number++ {
+		signer := signers[number]
+		block := sealTestBlock(t, s, keys[signer], testStartTime+number*testPeriod)
+
+		if block.Header.Difficulty != diffInTurn {
+			t.Fatalf("block %d is in turn for '%s', its difficulty should be %d", number, signer.Hex(), diffInTurn)
+		}
+		addTestBlock(t, s, block)
+	}
+
+	// Out of turn signers can seal too, at a lower difficulty, unless they signed recently
+	latest := s.LatestBlock().Header
+	_, err := prepareTestBlock(t, s, keys[signers[2]], latest.Time+testPeriod)
+	if err == nil || !strings.Contains(err.Error(), "signed recently") {
+		t.Fatalf("the latest signer should wait for the others, got %v", err)
+	}
+
+	block := sealTestBlock(t, s, keys[signers[1]], latest.Time+testPeriod)
+	if block.Header.Difficulty != diffNoTurn {
+		t.Fatalf("an out of turn block difficulty should be %d not %d", diffNoTurn, block.Header.Difficulty)
+	}
+
+	// Blocks closer than the period to their parent are invalid
+	early := newTestBlock(t, s, database.BlockHeader{
+		Parent:     s.LatestBlockHash(),
+		Number:     3,
+		Time:       latest.Time + testPeriod - 1,
+		Miner:      signers[0],
+		Difficulty: diffInTurn,
+	})
+	early.Header.Signature = signTestHeader(t, early.Header, keys[signers[0]])
+
+	_, _, err = s.ImportBlock(early)
+	if err == nil || !strings.Contains(err.Error(), "seconds after its parent") {
+		t.Fatalf("a block sealed before the period should be rejected, got %v", err)
+	}
+
+	// Nor can they be dated ahead of the local clock
+	future := newTestBlock(t, s, database.BlockHeader{
+		Parent:     s.LatestBlockHash(),
+		Number:     3,
+		Time:       uint64(time.Now().Add(maxClockDrift).Unix()) + testPeriod,
+		Miner:      signers[0],
+		Difficulty: diffInTurn,
+	})
+	future.Header.Signature = signTestHeader(t, future.Header, keys[signers[0]])
+
+	_, _, err = s.ImportBlock(future)
+	if err == nil || !strings.Contains(err.Error(), "ahead of the local clock") {
+		t.Fatalf("a block dated ahead of the local clock should be rejected, got %v", err)
+	}
+
+	// Prepare delays the block until the period passed
+	header, err := prepareTestBlock(t, s, keys[signers[0]], latest.Time)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Time != latest.Time+testPeriod {
+		t.Fatalf("the block time should be delayed to %d, not %d", latest.Time+testPeriod, header.Time)
+	}
+}
+
+func TestPoA_VoteSigners(t *testing.T) {
+	keyA, signerA := generateTestKey(t)
+	keyB, signerB := generateTestKey(t)
+	keyC, signerC := generateTestKey(t)
+
+	dataDir, s, engine := setupTestPoAState(t, nil, signerA, signerB)
+	defer os.RemoveAll(dataDir)
+	defer s.Close()
+
+	signers := []common.Address{signerA, signerB}
+	keys := map[common.Address]*ecdsa.PrivateKey{signerA: keyA, signerB: keyB, signerC: keyC}
+	blockTime := testStartTime
+
+	// sealNext seals the next block with the in-turn signer, or the next one
+	// allowed to when the signer set just changed
+	sealNext := func(txs ...database.SignedTx) {
+		number := s.NextBlockNumber()
+		signers, err := engine.Signers(s, s.LatestBlockHash())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		blockTime += testPeriod
+		for i := range signers {
+			key := keys[signers[(number+uint64(i))%uint64(len(signers))]]
+			if _, err := prepareTestBlock(t, s, key, blockTime); err == nil {
+				addTestBlock(t, s, sealTestBlock(t, s, key, blockTime, txs...))
+				return
+			}
+		}
+
+		t.Fatalf("no signer is allowed to seal block %d", number)
+	}
+
+	// A single vote out of two signers isn't a majority, and outsiders can't vote
+	sealNext(signTestVote(t, s, keyA, signerC, database.TxDataVoteAddSigner), signTestVote(t, s, keyC, signerC, database.TxDataVoteAddSigner))
+	assertTestSigners(t, engine, s, signers...)
+
+	sealNext(signTestVote(t, s, keyB, signerC, database.TxDataVoteAddSigner))
+	assertTestSigners(t, engine, s, signerA, signerB, signerC)
+
+	// The new signer takes its turn
+	for i := 0; i < 3; i++ {
+		sealNext()
+	}
+
+	// Two out of three signers remove one
+	sealNext(signTestVote(t, s, keyA, signerB, database.TxDataVoteRemoveSigner))
+	assertTestSigners(t, engine, s, signerA, signerB, signerC)
+
+	sealNext(signTestVote(t, s, keyC, signerB, database.TxDataVoteRemoveSigner))
+	assertTestSigners(t, engine, s, signerA, signerC)
+
+	// The signers are computed again from the chain once the node restarts
+	s.Close()
+	s, restarted, err := NewState(dataDir, database.BlockStoreFile, SealConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTestSigners(t, restarted.(*PoA), s, signerA, signerC)
+}
+
+func assertTestSigners(t *testing.T, engine *PoA, s *database.State, expected ...common.Address) {
+	signers, err := engine.Signers(s, s.LatestBlockHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sortSigners(expected)
+	if len(signers) != len(expected) {
+		t.Fatalf("expected the signers %v, got %v", expected, signers)
+	}
+	for i := range signers {
+		if signers[i] != expected[i] {
+			t.Fatalf("expected the signers %v, got %v", expected, signers)
+		}
+	}
+}
+
+func setupTestPoAState(t *testing.T, signerKey *ecdsa.PrivateKey, signers ...common.Address) (string, *database.State, *PoA) {
+	genesis := database.Genesis{
+		GenesisTime: time.Date(2020, 8, 17, 15, 53, 0, 0, time.UTC),
+		ChainID:     "the-blockchain-bar-poa-test",
+		Balances:    map[common.Address]uint{},
+		Consensus:   database.ConsensusPoA,
+		PoA:         &database.PoAConfig{Signers: signers, Period: testPeriod},
+	}
+
+	dataDir := setupTestDataDir(t, genesis)
+
+	s, engine, err := NewState(dataDir, database.BlockStoreFile, SealConfig{SignerKey: signerKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dataDir, s, engine.(*PoA)
+}
+
+func prepareTestBlock(t *testing.T, s *database.State, key *ecdsa.PrivateKey, blockTime uint64) (database.BlockHeader, error) {
+	header := database.BlockHeader{
+		Parent: s.LatestBlockHash(),
+		Number: s.NextBlockNumber(),
+		Time:   blockTime,
+	}
+
+	err := NewPoA(*s.Genesis().PoA, key).Prepare(s, &header)
+
+	return header, err
+}
+
+// sealTestBlock returns the next block of s, sealed with key.
+func sealTestBlock(t *testing.T, s *database.State, key *ecdsa.PrivateKey, blockTime uint64, txs ...database.SignedTx) database.Block {
+	header, err := prepareTestBlock(t, s, key, blockTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := newTestBlock(t, s, header, txs...)
+	block.Header.Signature = signTestHeader(t, block.Header, key)
+
+	return block
+}
+
+func addTestBlock(t *testing.T, s *database.State, block database.Block) {
+	_, _, err := s.ImportBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestBlock returns the block of header, with the state root it leads to
+// on top of the head of s.
+func newTestBlock(t *testing.T, s *database.State, header database.BlockHeader, txs ...database.SignedTx) database.Block {
+	if txs == nil {
+		txs = []database.SignedTx{}
+	}
+	block := database.NewBlock(header.Parent, header.Number, 0, header.Time, header.Miner, header.Difficulty, txs)
+
+	stateRoot, err := s.NextStateRoot(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.Header.StateRoot = stateRoot
+
+	return block
+}
+
+func signTestHeader(t *testing.T, header database.BlockHeader, key *ecdsa.PrivateKey) []byte {
+	sealHash, err := header.SealHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := crypto.Sign(sealHash[:], key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sig
+}
+
+func signTestVote(t *testing.T, s *database.State, key *ecdsa.PrivateKey, candidate common.Address, vote string) database.SignedTx {
+	voter := crypto.PubkeyToAddress(key.PublicKey)
+	tx := database.NewTx(voter, candidate, 0, s.GetNextAccountNonce(voter), vote)
+
+	signedTx, err := wallet.SignTx(tx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signedTx
+}
+
+func generateTestKey(t *testing.T) (*ecdsa.PrivateKey, common.Address) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, crypto.PubkeyToAddress(key.PublicKey)
+}
+
+func setupTestDataDir(t *testing.T, genesis database.Genesis) string {
+	dataDir, err := ioutil.TempDir(os.TempDir(), "consensus_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesisJSON, err := json.Marshal(genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = database.InitDataDirIfNotExists(dataDir, genesisJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dataDir
+}
@@ -0,0 +1,239 @@
+package consensus
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/paulcockrell/blockchain/database"
+	"github.com/paulcockrell/blockchain/fs"
+)
+
+// nonceSpace is the number of nonces a block header can hold.
+// It is a variable so tests can exhaust it quickly.
+var nonceSpace uint64 = math.MaxUint32 + 1
+
+// Workers check whether to stop, and report their hashes, every mineCheckInterval hashes
+const mineCheckInterval = 1 << 10
+
+// PoW seals blocks by mining them, the rules checking them are database.ProofOfWork's.
+type PoW struct {
+	database.ProofOfWork
+
+	workers int
+	meter   *HashMeter
+}
+
+// NewPoW returns the proof of work engine, mining with workers goroutines.
+// When meter isn't nil, it measures the hashrate.
+func NewPoW(workers int, meter *HashMeter) *PoW {
+	return &PoW{workers: workers, meter: meter}
+}
+
+// Prepare sets the difficulty of the header, mined on top of its parent.
+func (pow *PoW) Prepare(chain database.ChainReader, header *database.BlockHeader) error {
+	difficulty, err := pow.CalcDifficulty(chain, header.Parent)
+	if err != nil {
+		return err
+	}
+	header.Difficulty = difficulty
+
+	return nil
+}
+
+// Seal mines the header, see MineHeader.
+func (pow *PoW) Seal(ctx context.Context, header database.BlockHeader) (database.BlockHeader, error) {
+	return MineHeader(ctx, header, pow.workers, pow.meter)
+}
+
+// MineHeader splits the nonce space in as many disjoint ranges as workers,
+// each scanned by its own goroutine, until the header hash is valid at its
+// difficulty. The first valid hash stops every worker. When meter isn't nil,
+// it counts the hashes as they are computed.
+func MineHeader(ctx context.Context, header database.BlockHeader, workers int, meter *HashMeter) (database.BlockHeader, error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	if meter == nil {
+		meter = &HashMeter{}
+	}
+
+	start := time.Now()
+	meter.begin()
+	defer meter.finish()
+
+	fmt.Printf("Mining block %d with %d workers\n", header.Number, workers)
+
+	// Once every nonce failed, the extra nonce changes the header and so every
+	// hash, guaranteeing the next round doesn't retry the same ones.
+	var minedHeader database.BlockHeader
+	for {
+		var isFound bool
+		minedHeader, isFound = mineNonceSpace(ctx, header, workers, meter)
+		if isFound {
+			break
+		}
+
+		if ctx.Err() != nil {
+			fmt.Println("Mining cancelled")
+			return database.BlockHeader{}, fmt.Errorf("mining cancelled. %s", ctx.Err())
+		}
+
+		header.ExtraNonce++
+		fmt.Printf("Nonce space exhausted, rolling the extra nonce to %d\n", header.ExtraNonce)
+	}
+
+	hash, err := minedHeader.Hash()
+	if err != nil {
+		return database.BlockHeader{}, fmt.Errorf("couldn't mine block. %s", err.Error())
+	}
+
+	fmt.Printf("\nMined new Block '%x' using PoW 🎉🎉🎉 %s:\n", hash, fs.Unicode("\\U1F389"))
+	fmt.Printf("\tHeight: '%v'\n", minedHeader.Number)
+	fmt.Printf("\tNonce: '%v'\n", minedHeader.Nonce)
+	fmt.Printf("\tExtra nonce: '%v'\n", minedHeader.ExtraNonce)
+	fmt.Printf("\tDifficulty: '%v'\n", minedHeader.Difficulty)
+	fmt.Printf("\tCreated: '%v'\n", minedHeader.Time)
+	fmt.Printf("\tMiner: '%v'\n", minedHeader.Miner.String())
+	fmt.Printf("\tParent: '%v'\n", minedHeader.Parent.Hex())
+
+	fmt.Printf("\tAttempt: '%v'\n", meter.Hashes())
+	fmt.Printf("\tTime: '%v'\n", time.Since(start))
+
+	return minedHeader, nil
+}
+
+// mineNonceSpace tries every nonce of the header, split between the workers.
+// It returns false once they all failed, or ctx is done.
+func mineNonceSpace(ctx context.Context, header database.BlockHeader, workers int, meter *HashMeter) (database.BlockHeader, bool) {
+	workersCtx, stopWorkers := context.WithCancel(ctx)
+	defer stopWorkers()
+
+	found := make(chan database.BlockHeader, workers)
+	var wg sync.WaitGroup
+
+	span := nonceSpace / uint64(workers)
+	for i := 0; i < workers; i++ {
+		from := uint64(i) * span
+		to := from + span
+		if i == workers-1 {
+			to = nonceSpace
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mineNonceRange(workersCtx, header, from, to, meter, found)
+		}()
+	}
+
+	// Every worker is done once one found a hash, or all ran out of nonces
+	go func() {
+		wg.Wait()
+		close(found)
+	}()
+
+	minedHeader, isFound := <-found
+
+	return minedHeader, isFound
+}
+
+// mineNonceRange tries the nonces from from up to to, excluded, and sends the
+// header to found once its hash is valid.
+func mineNonceRange(ctx context.Context, header database.BlockHeader, from, to uint64, meter *HashMeter, found chan<- database.BlockHeader) {
+	hashes := uint64(0)
+	defer func() {
+		meter.add(hashes)
+	}()
+
+	for nonce := from; nonce < to; nonce++ {
+		if hashes == mineCheckInterval {
+			meter.add(hashes)
+			hashes = 0
+
+			if ctx.Err() != nil {
+				return
+			}
+		}
+
+		header.Nonce = uint32(nonce)
+		hashes++
+
+		hash, err := header.Hash()
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			return
+		}
+
+		if database.IsBlockHashValid(hash, header.Difficulty) {
+			// Counted before the block is sent so the run's total includes them
+			meter.add(hashes)
+			hashes = 0
+
+			found <- header
+			return
+		}
+	}
+}
+
+// HashMeter measures the hashes per second of the current mining run, or of
+// the last one while the miner is idle. It is safe for concurrent use.
+type HashMeter struct {
+	// hashes is first to be 64-bit aligned for the atomic operations
+	hashes uint64
+
+	lock  sync.Mutex
+	start time.Time
+	end   time.Time
+}
+
+func (m *HashMeter) begin() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	atomic.StoreUint64(&m.hashes, 0)
+	m.start = time.Now()
+	m.end = time.Time{}
+}
+
+func (m *HashMeter) finish() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.end = time.Now()
+}
+
+func (m *HashMeter) add(hashes uint64) {
+	atomic.AddUint64(&m.hashes, hashes)
+}
+
+// Hashes returns the number of hashes computed in the current or last run.
+func (m *HashMeter) Hashes() uint64 {
+	return atomic.LoadUint64(&m.hashes)
+}
+
+// Rate returns the hashes per second of the current or last run.
+func (m *HashMeter) Rate() float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.start.IsZero() {
+		return 0
+	}
+
+	end := m.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+
+	elapsed := end.Sub(m.start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+
+	return float64(m.Hashes()) / elapsed
+}
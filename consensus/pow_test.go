@@ -0,0 +1,62 @@
+package consensus
+
+import (
+	"context"
+	"testing"
+
+	"github.com/paulcockrell/blockchain/database"
+)
+
+func TestMineHeaderRollsExtraNonce(t *testing.T) {
+	defer func(space uint64) {
+		nonceSpace = space
+	}(nonceSpace)
+	// A single nonce per round, the miner has to roll the extra nonce to find a valid hash
+	nonceSpace = 1
+
+	header := database.BlockHeader{
+		Number:     1,
+		Time:       1,
+		Miner:      database.NewAccount("0x000000000000000000000000000000000000000a"),
+		Difficulty: 1 << 8,
+	}
+
+	minedHeader, err := MineHeader(context.Background(), header, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	minedHash, err := minedHeader.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !database.IsBlockHashValid(minedHash, minedHeader.Difficulty) {
+		t.Fatalf("mined header hash %x isn't valid", minedHash)
+	}
+
+	if minedHeader.Nonce != 0 {
+		t.Fatalf("expected nonce 0 in a space of 1 nonce, got %d", minedHeader.Nonce)
+	}
+}
+
+func TestNew_DefaultsToPoW(t *testing.T) {
+	engine, err := New(database.Genesis{}, SealConfig{Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, isPoW := engine.(*PoW); !isPoW {
+		t.Fatalf("expected the proof of work engine, got %T", engine)
+	}
+
+	_, err = New(database.Genesis{Consensus: database.ConsensusPoA}, SealConfig{})
+	if err == nil {
+		t.Fatal("a proof of authority genesis without signers should be rejected")
+	}
+
+	_, err = New(database.Genesis{Consensus: "pos"}, SealConfig{})
+	if err == nil {
+		t.Fatal("an unknown consensus should be rejected")
+	}
+}
@@ -0,0 +1,270 @@
+package consensus
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/paulcockrell/blockchain/database"
+)
+
+// The snapshot cache keeps the snapshots of the latest snapshotCacheSize
+// blocks, older ones are computed again from the chain when needed.
+const snapshotCacheSize = 1024
+
+// snapshot is the proof of authority state once a block is applied: who may
+// sign the next blocks, who signed the latest ones and the pending votes.
+// Snapshots are never modified once built, apply returns a new one.
+type snapshot struct {
+	// number of the block the snapshot follows
+	number uint64
+	// signers sorted by address, the in-turn signer of block N is signers[N % len(signers)]
+	signers []common.Address
+	// recents are the signers of the latest blocks by block number
+	recents map[uint64]common.Address
+	// votes maps a candidate to its voters, true to add it and false to remove it
+	votes map[common.Address]map[common.Address]bool
+}
+
+func newGenesisSnapshot(config database.PoAConfig) *snapshot {
+	signers := make([]common.Address, 0, len(config.Signers))
+	isKnown := make(map[common.Address]struct{})
+	for _, signer := range config.Signers {
+		if _, ok := isKnown[signer]; ok {
+			continue
+		}
+		isKnown[signer] = struct{}{}
+		signers = append(signers, signer)
+	}
+	sortSigners(signers)
+
+	return &snapshot{
+		signers: signers,
+		recents: make(map[uint64]common.Address),
+		votes:   make(map[common.Address]map[common.Address]bool),
+	}
+}
+
+func sortSigners(signers []common.Address) {
+	sort.Slice(signers, func(i, j int) bool {
+		return bytes.Compare(signers[i][:], signers[j][:]) < 0
+	})
+}
+
+func (snap *snapshot) isSigner(acc common.Address) bool {
+	for _, signer := range snap.signers {
+		if signer == acc {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isInTurn reports whether it's the turn of signer to seal block number.
+func (snap *snapshot) isInTurn(number uint64, signer common.Address) bool {
+	return snap.signers[number%uint64(len(snap.signers))] == signer
+}
+
+// signedRecently reports whether signer sealed one of the blocks before number
+// it must leave to the other signers. Any majority of the signers can keep the
+// chain going, but no single one can seal most of it.
+func (snap *snapshot) signedRecently(number uint64, signer common.Address) bool {
+	limit := uint64(len(snap.signers)/2 + 1)
+
+	for recentNumber, recent := range snap.recents {
+		if recent == signer && recentNumber+limit > number {
+			return true
+		}
+	}
+
+	return false
+}
+
+// apply returns the snapshot following b, a block built on top of snap.
+// Votes from accounts which aren't signers, or which wouldn't change the signer
+// set, are ignored. A candidate is added or removed once more than half the
+// signers voted so, leaving at least one signer.
+func (snap *snapshot) apply(b database.Block) *snapshot {
+	next := &snapshot{
+		number:  b.Header.Number,
+		signers: snap.signers,
+		recents: make(map[uint64]common.Address, len(snap.recents)+1),
+		votes:   snap.votes,
+	}
+
+	// No signer ever needs to know about more blocks than there are signers
+	for number, recent := range snap.recents {
+		if number+uint64(len(snap.signers)) > b.Header.Number {
+			next.recents[number] = recent
+		}
+	}
+	next.recents[b.Header.Number] = b.Header.Miner
+
+	for _, tx := range b.TXs {
+		if !tx.IsSignerVote() || !next.isSigner(tx.From) {
+			continue
+		}
+
+		// Votes are checked with the block TXs, but blocks of side branches aren't applied yet
+		isAuthentic, err := tx.IsAuthentic()
+		if err != nil || !isAuthentic {
+			continue
+		}
+
+		authorize := tx.Data == database.TxDataVoteAddSigner
+		if next.isSigner(tx.To) == authorize {
+			continue
+		}
+
+		next.vote(tx.From, tx.To, authorize)
+	}
+
+	return next
+}
+
+// vote records the vote of voter on candidate and applies it once it has a majority.
+func (snap *snapshot) vote(voter, candidate common.Address, authorize bool) {
+	votes := snap.copyVotes()
+	if votes[candidate] == nil {
+		votes[candidate] = make(map[common.Address]bool)
+	}
+	votes[candidate][voter] = authorize
+	snap.votes = votes
+
+	tally := 0
+	for _, vote := range votes[candidate] {
+		if vote == authorize {
+			tally++
+		}
+	}
+
+	if tally <= len(snap.signers)/2 {
+		return
+	}
+
+	if !authorize && len(snap.signers) == 1 {
+		return
+	}
+
+	delete(votes, candidate)
+
+	signers := make([]common.Address, 0, len(snap.signers)+1)
+	for _, signer := range snap.signers {
+		if signer != candidate {
+			signers = append(signers, signer)
+		}
+	}
+
+	if authorize {
+		signers = append(signers, candidate)
+		sortSigners(signers)
+	} else {
+		// A removed signer doesn't vote anymore
+		for _, voters := range votes {
+			delete(voters, candidate)
+		}
+	}
+
+	snap.signers = signers
+}
+
+func (snap *snapshot) copyVotes() map[common.Address]map[common.Address]bool {
+	votes := make(map[common.Address]map[common.Address]bool, len(snap.votes))
+	for candidate, voters := range snap.votes {
+		votes[candidate] = make(map[common.Address]bool, len(voters))
+		for voter, authorize := range voters {
+			votes[candidate][voter] = authorize
+		}
+	}
+
+	return votes
+}
+
+// snapshotCache remembers the snapshot following each block by its hash,
+// side branches included.
+type snapshotCache struct {
+	genesis   *snapshot
+	snapshots map[database.Hash]*snapshot
+	latest    uint64
+	lock      sync.Mutex
+}
+
+func newSnapshotCache(config database.PoAConfig) *snapshotCache {
+	return &snapshotCache{
+		genesis:   newGenesisSnapshot(config),
+		snapshots: make(map[database.Hash]*snapshot),
+	}
+}
+
+// get returns the snapshot following the block hash, the empty hash meaning
+// before the first block. Blocks missing from the cache are read from chain
+// back to the latest cached one, then applied in order.
+func (c *snapshotCache) get(chain database.ChainReader, hash database.Hash) (*snapshot, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var blocks []database.Block
+	snap := c.genesis
+
+	for !hash.IsEmpty() {
+		if cached, isCached := c.snapshots[hash]; isCached {
+			snap = cached
+			break
+		}
+
+		b, err := chain.GetBlockByHash(hash)
+		if err != nil {
+			return nil, fmt.Errorf("can't compute the signers after block '%x'. %s", hash, err.Error())
+		}
+
+		blocks = append(blocks, b)
+		hash = b.Header.Parent
+	}
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		snap = snap.apply(blocks[i])
+
+		hash, err := blocks[i].Hash()
+		if err != nil {
+			return nil, err
+		}
+		c.add(hash, snap)
+	}
+
+	return snap, nil
+}
+
+// peek returns the snapshot following the block hash if it's cached.
+func (c *snapshotCache) peek(hash database.Hash) (*snapshot, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if hash.IsEmpty() {
+		return c.genesis, true
+	}
+
+	snap, isCached := c.snapshots[hash]
+
+	return snap, isCached
+}
+
+func (c *snapshotCache) add(hash database.Hash, snap *snapshot) {
+	c.snapshots[hash] = snap
+	if snap.number > c.latest {
+		c.latest = snap.number
+	}
+
+	// Evicting in batches keeps adding a snapshot cheap
+	if len(c.snapshots) <= 2*snapshotCacheSize || c.latest < snapshotCacheSize {
+		return
+	}
+
+	for cachedHash, cached := range c.snapshots {
+		if cached.number < c.latest-snapshotCacheSize {
+			delete(c.snapshots, cachedHash)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package database
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+)
+
+// A chain archive is a gzip compressed stream of JSON lines: a header, the
+// blocks as stored in block.db and a footer with the SHA-256 checksum of every
+// line before it.
+const chainArchiveVersion = 1
+
+type chainArchiveHeader struct {
+	Version     int    `json:"version"`
+	GenesisHash Hash   `json:"genesis_hash"`
+	From        uint64 `json:"from"`
+	To          uint64 `json:"to"`
+}
+
+type chainArchiveFooter struct {
+	Blocks   int  `json:"blocks"`
+	Checksum Hash `json:"checksum"`
+}
+
+// ExportBlocks writes the canonical blocks from height from to height to,
+// both included, as a chain archive. It returns the number of blocks written.
+func (s *State) ExportBlocks(w io.Writer, from, to uint64) (int, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if !s.hasGenesisBlock {
+		return 0, fmt.Errorf("no blocks to export yet")
+	}
+
+	if to > s.latestBlock.Header.Number {
+		to = s.latestBlock.Header.Number
+	}
+
+	if from > to {
+		return 0, fmt.Errorf("can't export from block %d to block %d", from, to)
+	}
+
+	zw := gzip.NewWriter(w)
+	checksum := sha256.New()
+	out := io.MultiWriter(zw, checksum)
+
+	err := writeArchiveLine(out, chainArchiveHeader{chainArchiveVersion, s.genesisHash, from, to})
+	if err != nil {
+		return 0, err
+	}
+
+	blocks := 0
+	for number := from; number <= to; number++ {
+		b, err := s.store.GetByNumber(number)
+		if err != nil {
+			return blocks, err
+		}
+
+		err = writeArchiveLine(out, BlockFS{s.canonical[number], b})
+		if err != nil {
+			return blocks, err
+		}
+
+		blocks++
+	}
+
+	footer := chainArchiveFooter{Blocks: blocks}
+	copy(footer.Checksum[:], checksum.Sum(nil))
+
+	err = writeArchiveLine(zw, footer)
+	if err != nil {
+		return blocks, err
+	}
+
+	return blocks, zw.Close()
+}
+
+// ImportBlocks adds the blocks of a chain archive one by one with AddBlock,
+// so each is validated before the next is read. The whole archive is read
+// first, nothing is imported from an archive cut short or failing its
+// checksum. It returns the number of blocks imported.
+func (s *State) ImportBlocks(r io.ReadSeeker) (int, error) {
+	archive, err := openChainArchive(r, s.GenesisHash())
+	if err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < archive.blocks(); i++ {
+		_, err = archive.nextBlock()
+		if err != nil {
+			archive.close()
+			return 0, err
+		}
+	}
+
+	err = archive.checkFooter()
+	archive.close()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	archive, err = openChainArchive(r, s.GenesisHash())
+	if err != nil {
+		return 0, err
+	}
+	defer archive.close()
+
+	blocks := 0
+	for blocks < archive.blocks() {
+		blockFs, err := archive.nextBlock()
+		if err != nil {
+			return blocks, err
+		}
+
+		hash, err := s.AddBlock(blockFs.Value)
+		if err != nil {
+			return blocks, err
+		}
+
+		if hash != blockFs.Key {
+			return blocks, fmt.Errorf("archived block '%x' hash is '%x'", blockFs.Key, hash)
+		}
+
+		blocks++
+	}
+
+	return blocks, nil
+}
+
+// chainArchiveReader reads the lines of a chain archive, checksumming them.
+type chainArchiveReader struct {
+	zr       *gzip.Reader
+	reader   *bufio.Reader
+	checksum hash.Hash
+	header   chainArchiveHeader
+	read     int
+}
+
+// openChainArchive reads the header of the archive, which must hold blocks of
+// the chain of genesisHash.
+func openChainArchive(r io.Reader, genesisHash Hash) (*chainArchiveReader, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &chainArchiveReader{zr: zr, reader: bufio.NewReader(zr), checksum: sha256.New()}
+
+	err = archive.checkHeader(genesisHash)
+	if err != nil {
+		zr.Close()
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+func (a *chainArchiveReader) checkHeader(genesisHash Hash) error {
+	err := readArchiveLine(a.reader, a.checksum, &a.header)
+	if err != nil {
+		return fmt.Errorf("unable to read the archive header. %s", err.Error())
+	}
+
+	if a.header.Version != chainArchiveVersion {
+		return fmt.Errorf("unsupported archive version %d", a.header.Version)
+	}
+
+	if a.header.GenesisHash != genesisHash {
+		return fmt.Errorf("the archive holds blocks of another chain, genesis '%x'", a.header.GenesisHash)
+	}
+
+	if a.header.To < a.header.From || a.header.To-a.header.From >= math.MaxInt32 {
+		return fmt.Errorf("invalid archive block range from %d to %d", a.header.From, a.header.To)
+	}
+
+	return nil
+}
+
+// blocks is the number of blocks the archive header announces.
+func (a *chainArchiveReader) blocks() int {
+	return int(a.header.To-a.header.From) + 1
+}
+
+func (a *chainArchiveReader) nextBlock() (BlockFS, error) {
+	var blockFs BlockFS
+	err := readArchiveLine(a.reader, a.checksum, &blockFs)
+	if err != nil {
+		return BlockFS{}, fmt.Errorf("unable to read block %d of the archive. %s", a.header.From+uint64(a.read), err.Error())
+	}
+	a.read++
+
+	return blockFs, nil
+}
+
+// checkFooter compares the footer with the blocks read and their checksum.
+func (a *chainArchiveReader) checkFooter() error {
+	// Only the lines before the footer are checksummed
+	expectedChecksum := Hash{}
+	copy(expectedChecksum[:], a.checksum.Sum(nil))
+
+	var footer chainArchiveFooter
+	err := readArchiveLine(a.reader, a.checksum, &footer)
+	if err != nil {
+		return fmt.Errorf("unable to read the archive footer. %s", err.Error())
+	}
+
+	if footer.Blocks != a.read || footer.Checksum != expectedChecksum {
+		return fmt.Errorf("archive checksum must be '%x' not '%x'", expectedChecksum, footer.Checksum)
+	}
+
+	return nil
+}
+
+func (a *chainArchiveReader) close() {
+	a.zr.Close()
+}
+
+func writeArchiveLine(w io.Writer, v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(line, '\n'))
+
+	return err
+}
+
+func readArchiveLine(r *bufio.Reader, checksum hash.Hash, v interface{}) error {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+
+	checksum.Write(line)
+
+	return json.Unmarshal(line, v)
+}
@@ -0,0 +1,140 @@
+package database
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestChainArchive(t *testing.T) {
+	dataDir, s, sender, senderKey := setupTestState(t, Genesis{Difficulty: testDifficulty})
+	defer os.RemoveAll(dataDir)
+	defer s.Close()
+
+	hash := addTestBlock(t, s, mineTestBlock(t, s, Hash{}, 0, 1, testMinerA, signTestTx(t, senderKey, sender, 10, 1)))
+	hash = addTestBlock(t, s, mineTestBlock(t, s, hash, 1, 2, testMinerA, signTestTx(t, senderKey, sender, 20, 2)))
+	hash = addTestBlock(t, s, mineTestBlock(t, s, hash, 2, 3, testMinerB))
+
+	var archive bytes.Buffer
+	blocks, err := s.ExportBlocks(&archive, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocks != 3 {
+		t.Fatalf("expected 3 exported blocks, got %d", blocks)
+	}
+
+	importing := setupTestStateWithGenesisOf(t, dataDir)
+	defer os.RemoveAll(importing.dataDir)
+	defer importing.Close()
+
+	blocks, err = importing.ImportBlocks(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocks != 3 || importing.LatestBlockHash() != hash || importing.GetBalance(testReceiver) != 30 {
+		t.Fatal("the imported chain should match the exported one")
+	}
+
+	// Change the last hex digit of the footer checksum
+	content := readTestArchive(t, archive.Bytes())
+	if content[len(content)-4] == '0' {
+		content[len(content)-4] = '1'
+	} else {
+		content[len(content)-4] = '0'
+	}
+
+	_, err = importing.ImportBlocks(writeTestArchive(t, content))
+	if err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("an archive failing its checksum should be reported, got %v", err)
+	}
+
+	// No block of an archive failing its checksum is imported
+	unimported := setupTestStateWithGenesisOf(t, dataDir)
+	defer os.RemoveAll(unimported.dataDir)
+	defer unimported.Close()
+
+	blocks, err = unimported.ImportBlocks(writeTestArchive(t, content))
+	if err == nil || blocks != 0 || !unimported.LatestBlockHash().IsEmpty() {
+		t.Fatalf("an archive failing its checksum should import nothing, %d blocks were imported", blocks)
+	}
+
+	// A header whose range ends before it starts is refused up front
+	inverted := bytes.Replace(content, []byte(`"from":0,"to":2`), []byte(`"from":2,"to":0`), 1)
+	_, err = unimported.ImportBlocks(writeTestArchive(t, inverted))
+	if err == nil || !strings.Contains(err.Error(), "invalid archive block range") {
+		t.Fatalf("an archive of an invalid block range should be refused, got %v", err)
+	}
+
+	var partial bytes.Buffer
+	_, err = s.ExportBlocks(&partial, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	empty := setupTestStateWithGenesisOf(t, dataDir)
+	defer os.RemoveAll(empty.dataDir)
+	defer empty.Close()
+
+	blocks, err = empty.ImportBlocks(bytes.NewReader(partial.Bytes()))
+	if err == nil || blocks != 0 {
+		t.Fatal("blocks whose parent is unknown should be refused")
+	}
+}
+
+// setupTestStateWithGenesisOf opens a State in a new data dir, on the chain of dataDir.
+func setupTestStateWithGenesisOf(t *testing.T, dataDir string) *State {
+	genesisJSON, err := ioutil.ReadFile(getGenesisJSONFilePath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherDataDir, err := ioutil.TempDir(os.TempDir(), "tbb_database_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = InitDataDirIfNotExists(otherDataDir, genesisJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewStateFromDisk(otherDataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func readTestArchive(t *testing.T, archive []byte) []byte {
+	zr, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return content
+}
+
+func writeTestArchive(t *testing.T, content []byte) *bytes.Reader {
+	var archive bytes.Buffer
+	zw := gzip.NewWriter(&archive)
+
+	_, err := zw.Write(content)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bytes.NewReader(archive.Bytes())
+}
@@ -6,11 +6,17 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
 )
 
-const BlockReward = 100
+// DefaultDifficulty is the expected number of hashes to mine a block, when
+// genesis.json doesn't configure one. 2^24 matches three leading zero bytes.
+const DefaultDifficulty = 1 << 24
+
+// maxTarget is the highest possible block hash, the target at difficulty 1.
+var maxTarget = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
 
 type Hash [32]byte
 
@@ -19,6 +25,11 @@ func (h Hash) MarshalText() ([]byte, error) {
 }
 
 func (h *Hash) UnmarshalText(data []byte) error {
+	// Empty text decodes to the empty hash, anything else must be a full hash
+	if len(data) != 0 && len(data) != hex.EncodedLen(len(h)) {
+		return fmt.Errorf("invalid hash '%s'", data)
+	}
+
 	_, err := hex.Decode(h[:], data)
 	return err
 }
@@ -43,6 +54,18 @@ type BlockHeader struct {
 	Nonce  uint32         `json:"nonce"`
 	Time   uint64         `json:"time"`
 	Miner  common.Address `json:"miner"`
+
+	Difficulty uint64 `json:"difficulty"`
+
+	// TxRoot is the Merkle root of the block TXs, see TxRoot
+	TxRoot Hash `json:"tx_root"`
+	// StateRoot is the root of the state tree once the block is applied, see State.NextStateRoot
+	StateRoot Hash `json:"state_root"`
+	// ExtraNonce is increased by the miner every time all the Nonce values failed.
+	// It is left out of the JSON when zero.
+	ExtraNonce uint64 `json:"extra_nonce,omitempty"`
+	// Signature of the SealHash by the block signer under proof of authority, see package consensus
+	Signature []byte `json:"signature,omitempty"`
 }
 
 type BlockFS struct {
@@ -50,22 +73,83 @@ type BlockFS struct {
 	Value Block `json:"block"`
 }
 
-func NewBlock(parent Hash, number uint64, nonce uint32, time uint64, miner common.Address, txs []SignedTx) Block {
-	return Block{BlockHeader{parent, number, nonce, time, miner}, txs}
+func NewBlock(parent Hash, number uint64, nonce uint32, time uint64, miner common.Address, difficulty uint64, txs []SignedTx) Block {
+	// Hashing a TX only fails when it can't be encoded, the block would be invalid anyway
+	txRoot, _ := TxRoot(txs)
+
+	return Block{BlockHeader{parent, number, nonce, time, miner, difficulty, txRoot, Hash{}, 0, nil}, txs}
 }
 
+// Fees sums the fees of the block TXs, which its miner earns on top of the block reward.
+func (b Block) Fees() uint {
+	fees := uint(0)
+	for _, tx := range b.TXs {
+		fees += tx.Fee
+	}
+
+	return fees
+}
+
+// Hash only covers the header, the TXs are committed to through its TxRoot.
 func (b Block) Hash() (Hash, error) {
-	blockJSON, err := json.Marshal(b)
+	return b.Header.Hash()
+}
+
+func (h BlockHeader) Hash() (Hash, error) {
+	headerJSON, err := json.Marshal(h)
 	if err != nil {
 		return Hash{}, err
 	}
 
-	return sha256.Sum256(blockJSON), nil
+	return sha256.Sum256(headerJSON), nil
+}
+
+// SealHash is the hash of the header without its signature, the one signed
+// by the block signer.
+func (h BlockHeader) SealHash() (Hash, error) {
+	h.Signature = nil
+
+	return h.Hash()
 }
 
-func IsBlockHashValid(hash Hash) bool {
-	return fmt.Sprintf("%x", hash[0]) == "0" &&
-		fmt.Sprintf("%x", hash[1]) == "0" &&
-		fmt.Sprintf("%x", hash[2]) == "0" &&
-		fmt.Sprintf("%x", hash[3]) != "0"
+// Signer recovers the account which signed the header, see SealHash.
+func (h BlockHeader) Signer() (common.Address, error) {
+	if len(h.Signature) == 0 {
+		return common.Address{}, fmt.Errorf("block header isn't signed")
+	}
+
+	sealHash, err := h.SealHash()
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	return recoverSigner(sealHash, h.Signature)
+}
+
+// verifyTxRoot checks the block TXs are the ones its header commits to.
+func verifyTxRoot(b Block) error {
+	txRoot, err := TxRoot(b.TXs)
+	if err != nil {
+		return err
+	}
+
+	if txRoot != b.Header.TxRoot {
+		return fmt.Errorf("block TX root must be '%x' not '%x'", txRoot, b.Header.TxRoot)
+	}
+
+	return nil
+}
+
+// BlockTarget returns the highest hash a block of the given difficulty may have.
+func BlockTarget(difficulty uint64) *big.Int {
+	return new(big.Int).Div(maxTarget, new(big.Int).SetUint64(difficulty))
+}
+
+func IsBlockHashValid(hash Hash, difficulty uint64) bool {
+	// The empty hash is what a miner starts from, it's never a real block hash
+	if difficulty == 0 || hash.IsEmpty() {
+		return false
+	}
+
+	return new(big.Int).SetBytes(hash[:]).Cmp(BlockTarget(difficulty)) <= 0
 }
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Kinds of block stores, see OpenBlockStore
+const BlockStoreFile = "file"
+const BlockStoreLevelDB = "leveldb"
+const BlockStoreMemory = "memory"
+
+// BlockStore persists every block the State accepted, canonical or not.
+//
+// The State decides which chain is canonical and tells the store its head
+// with SetHead, GetByNumber then follows the chain ending at that head.
+// A store doesn't have to support concurrent writes, the State serialises them.
+type BlockStore interface {
+	// Put stores a block whose parent was stored first. Storing a known block does nothing.
+	Put(hash Hash, b Block) error
+	Get(hash Hash) (Block, error)
+	GetByNumber(number uint64) (Block, error)
+
+	// Iterate calls fn with every stored block, in the order they were stored.
+	Iterate(fn func(info BlockInfo) error) error
+
+	// Head is empty until the first SetHead.
+	Head() Hash
+	SetHead(hash Hash) error
+
+	Close() error
+}
+
+// BlockInfo is what the State needs to know about a block to pick the
+// canonical chain, without decoding the block itself.
+type BlockInfo struct {
+	Hash       Hash
+	Parent     Hash
+	Number     uint64
+	Time       uint64
+	Difficulty uint64
+}
+
+// blockInfoSize is the size of an encoded BlockInfo:
+//
+//	hash (32) | parent (32) | number (8) | time (8) | difficulty (8)
+const blockInfoSize = 32 + 32 + 8 + 8 + 8
+
+func NewBlockInfo(hash Hash, b Block) BlockInfo {
+	return BlockInfo{
+		Hash:       hash,
+		Parent:     b.Header.Parent,
+		Number:     b.Header.Number,
+		Time:       b.Header.Time,
+		Difficulty: b.Header.Difficulty,
+	}
+}
+
+func (i BlockInfo) encode() []byte {
+	buf := make([]byte, blockInfoSize)
+	copy(buf[0:32], i.Hash[:])
+	copy(buf[32:64], i.Parent[:])
+	binary.BigEndian.PutUint64(buf[64:72], i.Number)
+	binary.BigEndian.PutUint64(buf[72:80], i.Time)
+	binary.BigEndian.PutUint64(buf[80:88], i.Difficulty)
+
+	return buf
+}
+
+func decodeBlockInfo(buf []byte) BlockInfo {
+	var i BlockInfo
+	copy(i.Hash[:], buf[0:32])
+	copy(i.Parent[:], buf[32:64])
+	i.Number = binary.BigEndian.Uint64(buf[64:72])
+	i.Time = binary.BigEndian.Uint64(buf[72:80])
+	i.Difficulty = binary.BigEndian.Uint64(buf[80:88])
+
+	return i
+}
+
+// OpenBlockStore opens the block store of the given kind in the data dir.
+func OpenBlockStore(kind string, dataDir string) (BlockStore, error) {
+	switch kind {
+	case BlockStoreFile, "":
+		return openFileBlockStore(dataDir)
+	case BlockStoreLevelDB:
+		return openLevelDBBlockStore(dataDir)
+	case BlockStoreMemory:
+		return newMemoryBlockStore(), nil
+	}
+
+	return nil, fmt.Errorf("unknown block store '%s', use '%s', '%s' or '%s'", kind, BlockStoreFile, BlockStoreLevelDB, BlockStoreMemory)
+}
+
+// chainIndex keeps the stored blocks info in memory and maps heights to the
+// chain ending at the head, for the stores to share.
+type chainIndex struct {
+	infos     map[Hash]BlockInfo
+	order     []Hash
+	canonical []Hash
+	head      Hash
+}
+
+func newChainIndex() *chainIndex {
+	return &chainIndex{
+		infos:     make(map[Hash]BlockInfo),
+		order:     make([]Hash, 0),
+		canonical: make([]Hash, 0),
+	}
+}
+
+func (c *chainIndex) has(hash Hash) bool {
+	_, isKnown := c.infos[hash]
+
+	return isKnown
+}
+
+func (c *chainIndex) add(info BlockInfo) {
+	c.infos[info.Hash] = info
+	c.order = append(c.order, info.Hash)
+}
+
+func (c *chainIndex) iterate(fn func(info BlockInfo) error) error {
+	for _, hash := range c.order {
+		err := fn(c.infos[hash])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setHead re-maps the heights from the new head down to where its chain
+// meets the previous one.
+func (c *chainIndex) setHead(hash Hash) error {
+	branch := make([]Hash, 0)
+
+	for h := hash; ; {
+		info, isKnown := c.infos[h]
+		if !isKnown {
+			return fmt.Errorf("block '%x' %w", h, ErrNotFound)
+		}
+
+		if info.Number < uint64(len(c.canonical)) && c.canonical[info.Number] == h {
+			c.canonical = c.canonical[:info.Number+1]
+			break
+		}
+
+		branch = append(branch, h)
+		if info.Number == 0 {
+			c.canonical = c.canonical[:0]
+			break
+		}
+
+		h = info.Parent
+	}
+
+	for i := len(branch) - 1; i >= 0; i-- {
+		c.canonical = append(c.canonical, branch[i])
+	}
+	c.head = hash
+
+	return nil
+}
+
+func (c *chainIndex) hashByNumber(number uint64) (Hash, error) {
+	if number >= uint64(len(c.canonical)) {
+		return Hash{}, fmt.Errorf("block number '%d' %w", number, ErrNotFound)
+	}
+
+	return c.canonical[number], nil
+}
@@ -0,0 +1,206 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// fileBlockStore appends the blocks as JSON lines to block.db, with block.idx
+// pointing at each of them. The head isn't persisted, the State picks it
+// again from the stored blocks on every start.
+type fileBlockStore struct {
+	dbFile    *os.File
+	dbSize    int64
+	indexFile *os.File
+
+	entries map[Hash]blockIndexEntry
+	chain   *chainIndex
+}
+
+func openFileBlockStore(dataDir string) (*fileBlockStore, error) {
+	f, err := os.OpenFile(
+		getBlocksDBFilePath(dataDir),
+		os.O_APPEND|os.O_RDWR,
+		0600,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = truncateTornRecord(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	indexFile, entries, err := openBlockIndex(getBlockIndexFilePath(dataDir), f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	store := &fileBlockStore{
+		dbFile:    f,
+		indexFile: indexFile,
+		entries:   make(map[Hash]blockIndexEntry),
+		chain:     newChainIndex(),
+	}
+
+	for _, e := range entries {
+		store.dbSize = e.end()
+
+		// A block stored twice by an older version is indexed once
+		if store.chain.has(e.Hash) {
+			continue
+		}
+
+		store.entries[e.Hash] = e
+		store.chain.add(e.BlockInfo)
+	}
+
+	return store, nil
+}
+
+func (f *fileBlockStore) Put(hash Hash, b Block) error {
+	if f.chain.has(hash) {
+		return nil
+	}
+
+	blockFsJSON, err := json.Marshal(BlockFS{hash, b})
+	if err != nil {
+		return err
+	}
+
+	// The block is synced before its index record, so the index never points
+	// past the end of the DB. Whatever a crash cuts short is dropped on the next open,
+	// whatever a failed write leaves is cut off right away.
+	line := append(blockFsJSON, '\n')
+	_, err = f.dbFile.Write(line)
+	if err == nil {
+		err = f.dbFile.Sync()
+	}
+	if err != nil {
+		return truncateFailedAppend(f.dbFile, f.dbSize, err)
+	}
+
+	indexInfo, err := f.indexFile.Stat()
+	if err != nil {
+		return truncateFailedAppend(f.dbFile, f.dbSize, err)
+	}
+
+	entry := newBlockIndexEntry(hash, b, f.dbSize, uint32(len(line)))
+	_, err = f.indexFile.Write(entry.encode())
+	if err == nil {
+		err = f.indexFile.Sync()
+	}
+	if err != nil {
+		err = truncateFailedAppend(f.indexFile, indexInfo.Size(), err)
+		return truncateFailedAppend(f.dbFile, f.dbSize, err)
+	}
+
+	f.dbSize += int64(len(line))
+	f.entries[hash] = entry
+	f.chain.add(entry.BlockInfo)
+
+	return nil
+}
+
+func (f *fileBlockStore) Get(hash Hash) (Block, error) {
+	e, isKnown := f.entries[hash]
+	if !isKnown {
+		return Block{}, fmt.Errorf("block '%x' %w", hash, ErrNotFound)
+	}
+
+	return readBlockAt(f.dbFile, e)
+}
+
+func (f *fileBlockStore) GetByNumber(number uint64) (Block, error) {
+	hash, err := f.chain.hashByNumber(number)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return f.Get(hash)
+}
+
+func (f *fileBlockStore) Iterate(fn func(info BlockInfo) error) error {
+	return f.chain.iterate(fn)
+}
+
+func (f *fileBlockStore) Head() Hash {
+	return f.chain.head
+}
+
+func (f *fileBlockStore) SetHead(hash Hash) error {
+	return f.chain.setHead(hash)
+}
+
+func (f *fileBlockStore) Close() error {
+	f.indexFile.Close()
+
+	return f.dbFile.Close()
+}
+
+// truncateFailedAppend cuts file back to size after err interrupted an append
+// to it, so the next record doesn't follow a partial one.
+func truncateFailedAppend(file *os.File, size int64, err error) error {
+	truncateErr := file.Truncate(size)
+	if truncateErr == nil {
+		_, truncateErr = file.Seek(size, io.SeekStart)
+	}
+	if truncateErr != nil {
+		return fmt.Errorf("%s. Truncating '%s' back to %d bytes failed: %s", err.Error(), file.Name(), size, truncateErr.Error())
+	}
+
+	return err
+}
+
+// truncateTornRecord drops the last record of the blocks DB when a crash
+// interrupted its write, leaving it without its closing new line.
+func truncateTornRecord(dbFile *os.File) error {
+	info, err := dbFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	size := info.Size()
+	end := size
+
+	// Walk back to the last new line, a chunk at a time
+	buf := make([]byte, 4096)
+	for end > 0 {
+		chunk := int64(len(buf))
+		if end < chunk {
+			chunk = end
+		}
+
+		_, err = dbFile.ReadAt(buf[:chunk], end-chunk)
+		if err != nil {
+			return err
+		}
+
+		i := bytes.LastIndexByte(buf[:chunk], '\n')
+		if i >= 0 {
+			end = end - chunk + int64(i) + 1
+			break
+		}
+
+		end -= chunk
+	}
+
+	if end == size {
+		return nil
+	}
+
+	fmt.Printf("Truncating the torn last record of the blocks DB, %d bytes at offset %d\n", size-end, end)
+
+	err = dbFile.Truncate(end)
+	if err != nil {
+		return err
+	}
+
+	return dbFile.Sync()
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Keys of the LevelDB block store:
+//
+//	b + hash     -> block JSON
+//	i + sequence -> block info, in the order the blocks were stored
+//	head         -> head hash
+const levelDBBlockPrefix = "b"
+const levelDBInfoPrefix = "i"
+const levelDBHeadKey = "head"
+
+// Writes only return once on disk, like the file store ones
+var levelDBSyncWrites = &opt.WriteOptions{Sync: true}
+
+type levelDBBlockStore struct {
+	db    *leveldb.DB
+	chain *chainIndex
+}
+
+func openLevelDBBlockStore(dataDir string) (*levelDBBlockStore, error) {
+	db, err := leveldb.OpenFile(getLevelDBDirPath(dataDir), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &levelDBBlockStore{db: db, chain: newChainIndex()}
+
+	it := db.NewIterator(util.BytesPrefix([]byte(levelDBInfoPrefix)), nil)
+	for it.Next() {
+		if len(it.Value()) != blockInfoSize {
+			it.Release()
+			db.Close()
+			return nil, fmt.Errorf("invalid block info under key '%x'", it.Key())
+		}
+
+		store.chain.add(decodeBlockInfo(it.Value()))
+	}
+	it.Release()
+
+	err = it.Error()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	head, err := db.Get([]byte(levelDBHeadKey), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return store, nil
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	var headHash Hash
+	copy(headHash[:], head)
+
+	err = store.chain.setHead(headHash)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (l *levelDBBlockStore) Put(hash Hash, b Block) error {
+	if l.chain.has(hash) {
+		return nil
+	}
+
+	blockJSON, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nPersisting new block '%x' to LevelDB:\n", hash)
+	fmt.Printf("\t%s\n", blockJSON)
+
+	info := NewBlockInfo(hash, b)
+
+	seq := make([]byte, 8)
+	binary.BigEndian.PutUint64(seq, uint64(len(l.chain.order)))
+
+	batch := new(leveldb.Batch)
+	batch.Put(append([]byte(levelDBBlockPrefix), hash[:]...), blockJSON)
+	batch.Put(append([]byte(levelDBInfoPrefix), seq...), info.encode())
+
+	err = l.db.Write(batch, levelDBSyncWrites)
+	if err != nil {
+		return err
+	}
+
+	l.chain.add(info)
+
+	return nil
+}
+
+func (l *levelDBBlockStore) Get(hash Hash) (Block, error) {
+	blockJSON, err := l.db.Get(append([]byte(levelDBBlockPrefix), hash[:]...), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return Block{}, fmt.Errorf("block '%x' %w", hash, ErrNotFound)
+	}
+	if err != nil {
+		return Block{}, err
+	}
+
+	var b Block
+	err = json.Unmarshal(blockJSON, &b)
+	if err != nil {
+		return Block{}, fmt.Errorf("unable to decode block '%x'. %s", hash, err.Error())
+	}
+
+	return b, nil
+}
+
+func (l *levelDBBlockStore) GetByNumber(number uint64) (Block, error) {
+	hash, err := l.chain.hashByNumber(number)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return l.Get(hash)
+}
+
+func (l *levelDBBlockStore) Iterate(fn func(info BlockInfo) error) error {
+	return l.chain.iterate(fn)
+}
+
+func (l *levelDBBlockStore) Head() Hash {
+	return l.chain.head
+}
+
+func (l *levelDBBlockStore) SetHead(hash Hash) error {
+	err := l.chain.setHead(hash)
+	if err != nil {
+		return err
+	}
+
+	return l.db.Put([]byte(levelDBHeadKey), hash[:], levelDBSyncWrites)
+}
+
+func (l *levelDBBlockStore) Close() error {
+	return l.db.Close()
+}
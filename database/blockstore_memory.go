@@ -0,0 +1,63 @@
+package database
+
+import (
+	"fmt"
+)
+
+// memoryBlockStore keeps the blocks in memory only, for tests and throwaway nodes.
+type memoryBlockStore struct {
+	blocks map[Hash]Block
+	chain  *chainIndex
+}
+
+func newMemoryBlockStore() *memoryBlockStore {
+	return &memoryBlockStore{
+		blocks: make(map[Hash]Block),
+		chain:  newChainIndex(),
+	}
+}
+
+func (m *memoryBlockStore) Put(hash Hash, b Block) error {
+	if m.chain.has(hash) {
+		return nil
+	}
+
+	m.blocks[hash] = b
+	m.chain.add(NewBlockInfo(hash, b))
+
+	return nil
+}
+
+func (m *memoryBlockStore) Get(hash Hash) (Block, error) {
+	b, isKnown := m.blocks[hash]
+	if !isKnown {
+		return Block{}, fmt.Errorf("block '%x' %w", hash, ErrNotFound)
+	}
+
+	return b, nil
+}
+
+func (m *memoryBlockStore) GetByNumber(number uint64) (Block, error) {
+	hash, err := m.chain.hashByNumber(number)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return m.Get(hash)
+}
+
+func (m *memoryBlockStore) Iterate(fn func(info BlockInfo) error) error {
+	return m.chain.iterate(fn)
+}
+
+func (m *memoryBlockStore) Head() Hash {
+	return m.chain.head
+}
+
+func (m *memoryBlockStore) SetHead(hash Hash) error {
+	return m.chain.setHead(hash)
+}
+
+func (m *memoryBlockStore) Close() error {
+	return nil
+}
@@ -0,0 +1,218 @@
+package database
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestBlockStores(t *testing.T) {
+	for _, kind := range []string{BlockStoreFile, BlockStoreLevelDB, BlockStoreMemory} {
+		t.Run(kind, func(t *testing.T) {
+			dataDir, err := ioutil.TempDir(os.TempDir(), "tbb_block_store_test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dataDir)
+
+			err = InitDataDirIfNotExists(dataDir, []byte(genesisJSON))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			store, err := OpenBlockStore(kind, dataDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// genesis <- a1 <- a2 and genesis <- b1
+			genesis := NewBlock(Hash{}, 0, 0, 1, testMinerA, testDifficulty, nil)
+			genesisHash := putTestBlock(t, store, genesis)
+			a1Hash := putTestBlock(t, store, NewBlock(genesisHash, 1, 0, 2, testMinerA, testDifficulty, nil))
+			a2Hash := putTestBlock(t, store, NewBlock(a1Hash, 2, 0, 3, testMinerA, testDifficulty, nil))
+			b1Hash := putTestBlock(t, store, NewBlock(genesisHash, 1, 0, 2, testMinerB, testDifficulty, nil))
+			putTestBlock(t, store, genesis)
+
+			err = store.SetHead(a2Hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := store.GetByNumber(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.Header.Miner != testMinerA {
+				t.Fatal("block number 1 should follow the head chain")
+			}
+
+			err = store.SetHead(b1Hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, err = store.GetByNumber(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.Header.Miner != testMinerB {
+				t.Fatal("block number 1 should follow the new head chain")
+			}
+
+			_, err = store.GetByNumber(2)
+			if !errors.Is(err, ErrNotFound) {
+				t.Fatal("the new head chain has no block number 2")
+			}
+
+			_, err = store.Get(a2Hash)
+			if err != nil {
+				t.Fatal("blocks off the head chain should stay stored")
+			}
+
+			if kind == BlockStoreMemory {
+				store.Close()
+				return
+			}
+
+			err = store.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			store, err = OpenBlockStore(kind, dataDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			stored := make([]Hash, 0)
+			err = store.Iterate(func(info BlockInfo) error {
+				stored = append(stored, info.Hash)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := []Hash{genesisHash, a1Hash, a2Hash, b1Hash}
+			if len(stored) != len(expected) {
+				t.Fatalf("expected %d stored blocks, got %d", len(expected), len(stored))
+			}
+			for i := range expected {
+				if stored[i] != expected[i] {
+					t.Fatal("blocks should be iterated in the order they were stored")
+				}
+			}
+		})
+	}
+}
+
+func TestState_LevelDBBlockStore(t *testing.T) {
+	dataDir, s, sender, senderKey := setupTestState(t, Genesis{Difficulty: testDifficulty})
+	defer os.RemoveAll(dataDir)
+	s.Close()
+
+	s, err := NewStateWithBlockStore(dataDir, BlockStoreLevelDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := addTestBlock(t, s, mineTestBlock(t, s, Hash{}, 0, 1, testMinerA, signTestTx(t, senderKey, sender, 10, 1)))
+	hash = addTestBlock(t, s, mineTestBlock(t, s, hash, 1, 2, testMinerA))
+	s.Close()
+
+	s, err = NewStateWithBlockStore(dataDir, BlockStoreLevelDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if s.LatestBlockHash() != hash || s.GetBalance(testReceiver) != 10 {
+		t.Fatal("the state should be loaded back from LevelDB")
+	}
+}
+
+func TestFileBlockStore_TruncatesFailedPut(t *testing.T) {
+	dataDir, err := ioutil.TempDir(os.TempDir(), "tbb_block_store_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	err = InitDataDirIfNotExists(dataDir, []byte(genesisJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := openFileBlockStore(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	genesis := NewBlock(Hash{}, 0, 0, 1, testMinerA, testDifficulty, nil)
+	genesisHash := putTestBlock(t, store, genesis)
+	dbSize := store.dbSize
+
+	// The index record can't be written, the block written before it must go
+	indexFile := store.indexFile
+	store.indexFile, err = os.Open(indexFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a1 := NewBlock(genesisHash, 1, 0, 2, testMinerA, testDifficulty, nil)
+	a1Hash, err := a1.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Put(a1Hash, a1)
+	if err == nil {
+		t.Fatal("a block whose index record can't be written should fail to store")
+	}
+
+	store.indexFile.Close()
+	store.indexFile = indexFile
+
+	info, err := os.Stat(getBlocksDBFilePath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != dbSize || store.dbSize != dbSize {
+		t.Fatalf("the blocks DB should be truncated back to %d bytes, it has %d", dbSize, info.Size())
+	}
+
+	// The next block lands where the failed one started
+	putTestBlock(t, store, a1)
+	err = store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = openFileBlockStore(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	b, err := store.Get(a1Hash)
+	if err != nil || b.Header.Number != 1 {
+		t.Fatalf("the block stored after the failure should be read back: %v", err)
+	}
+}
+
+func putTestBlock(t *testing.T, store BlockStore, b Block) Hash {
+	hash, err := b.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Put(hash, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hash
+}
@@ -0,0 +1,562 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ErrInvalidBlock wraps the errors of blocks breaking the consensus rules, as
+// opposed to the node failing to store them.
+var ErrInvalidBlock = errors.New("invalid block")
+
+// ErrBadBranch is returned when switching to a heavier branch failed because
+// one of its earlier blocks doesn't apply on top of its parent state. The new
+// head failing to apply is reported as an ErrInvalidBlock instead, being the
+// only block of the branch the sender is accountable for.
+var ErrBadBranch = errors.New("invalid block in competing branch")
+
+// ErrUnknownParent is returned when a block doesn't build on a known block,
+// the blocks before it must be imported first.
+var ErrUnknownParent = errors.New("block parent is unknown")
+
+// blockNode is a block known to the State, canonical or not.
+type blockNode struct {
+	info      BlockInfo
+	totalWork *big.Int
+
+	// undo restores the state as it was before this block was applied.
+	// Only set while the block is part of the canonical chain.
+	undo *blockUndo
+
+	// accounts is the state tree once this block was applied, kept while the
+	// block is part of the canonical chain to serve account proofs.
+	accounts *stateNode
+}
+
+// blockUndo records the balances and nonces a block touched, before it touched them.
+type blockUndo struct {
+	balances map[common.Address]accountValue
+	nonces   map[common.Address]accountValue
+}
+
+type accountValue struct {
+	value  uint
+	exists bool
+}
+
+// Reorg describes a switch of the canonical chain to a competing branch.
+type Reorg struct {
+	// Depth is the number of canonical blocks that were rolled back.
+	Depth   uint64
+	OldHead Hash
+	NewHead Hash
+
+	// Disconnected blocks left the canonical chain, newest first.
+	Disconnected []Block
+	// Connected blocks joined the canonical chain, oldest first.
+	Connected []Block
+}
+
+// invalidBlockErr marks err, the reason a block was refused, as an ErrInvalidBlock.
+func invalidBlockErr(err error) error {
+	return fmt.Errorf("%w. %s", ErrInvalidBlock, err.Error())
+}
+
+func (n *blockNode) hash() Hash {
+	return n.info.Hash
+}
+
+// work is the expected number of hashes needed to find a valid hash for the
+// block, which is what its difficulty stands for.
+func (i BlockInfo) work() *big.Int {
+	return new(big.Int).SetUint64(i.Difficulty)
+}
+
+func newBlockUndo(b Block, s *State) *blockUndo {
+	undo := &blockUndo{
+		balances: make(map[common.Address]accountValue),
+		nonces:   make(map[common.Address]accountValue),
+	}
+
+	for _, acc := range blockAccounts(b) {
+		if _, ok := undo.balances[acc]; ok {
+			continue
+		}
+
+		balance, ok := s.Balances[acc]
+		undo.balances[acc] = accountValue{balance, ok}
+
+		nonce, ok := s.Account2Nonce[acc]
+		undo.nonces[acc] = accountValue{nonce, ok}
+	}
+
+	return undo
+}
+
+// blockAccounts lists the accounts a block may touch, its miner first.
+func blockAccounts(b Block) []common.Address {
+	accounts := []common.Address{b.Header.Miner}
+	for _, tx := range b.TXs {
+		accounts = append(accounts, tx.From, tx.To)
+	}
+
+	return accounts
+}
+
+func (u *blockUndo) revert(s *State) {
+	for acc, v := range u.balances {
+		if v.exists {
+			s.Balances[acc] = v.value
+		} else {
+			delete(s.Balances, acc)
+		}
+	}
+
+	for acc, v := range u.nonces {
+		if v.exists {
+			s.Account2Nonce[acc] = v.value
+		} else {
+			delete(s.Account2Nonce, acc)
+		}
+	}
+
+	accounts := make([]common.Address, 0, len(u.balances))
+	for acc := range u.balances {
+		accounts = append(accounts, acc)
+	}
+	s.updateAccounts(accounts)
+}
+
+// AddBlock validates and stores b, making it the new head when it extends the
+// heaviest chain. Use ImportBlock to find out whether a reorg happened.
+func (s *State) AddBlock(b Block) (Hash, error) {
+	hash, _, err := s.ImportBlock(b)
+
+	return hash, err
+}
+
+// ImportBlock validates and stores b.
+//
+// Blocks extending the head are applied right away. Blocks on a competing
+// branch are kept aside until their branch has more cumulative work than the
+// canonical one, at which point the State rolls back to the fork point and
+// replays the branch. The returned Reorg is nil unless that happened.
+func (s *State) ImportBlock(b Block) (Hash, *Reorg, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	hash, err := b.Hash()
+	if err != nil {
+		return Hash{}, nil, err
+	}
+
+	if _, isKnown := s.blocks[hash]; isKnown {
+		return hash, nil, nil
+	}
+
+	if _, isBad := s.badBlocks[hash]; isBad {
+		return Hash{}, nil, fmt.Errorf("%w. Block '%x' is known to be invalid", ErrInvalidBlock, hash)
+	}
+
+	err = verifyTxRoot(b)
+	if err != nil {
+		return Hash{}, nil, invalidBlockErr(err)
+	}
+
+	var parent *blockNode
+	parentWork := new(big.Int)
+	isRoot := b.Header.Number == 0 && b.Header.Parent.IsEmpty()
+
+	if !isRoot {
+		if _, isBad := s.badBlocks[b.Header.Parent]; isBad {
+			s.badBlocks[hash] = struct{}{}
+			return Hash{}, nil, fmt.Errorf("%w. Block '%x' parent '%x' is invalid", ErrInvalidBlock, hash, b.Header.Parent)
+		}
+
+		var isKnown bool
+		parent, isKnown = s.blocks[b.Header.Parent]
+		if !isKnown {
+			return Hash{}, nil, fmt.Errorf("%w. Block '%x' parent is '%x'", ErrUnknownParent, hash, b.Header.Parent)
+		}
+
+		if b.Header.Number != parent.info.Number+1 {
+			return Hash{}, nil, fmt.Errorf("%w. Next expected block was '%d' not '%d'", ErrInvalidBlock, parent.info.Number+1, b.Header.Number)
+		}
+
+		parentWork = parent.totalWork
+	}
+
+	err = s.engine.VerifyHeader(chainView{s}, b.Header)
+	if err != nil {
+		return Hash{}, nil, invalidBlockErr(err)
+	}
+
+	node := &blockNode{info: NewBlockInfo(hash, b)}
+	node.totalWork = new(big.Int).Add(parentWork, node.info.work())
+
+	extendsHead := (isRoot && !s.hasGenesisBlock) || (!isRoot && s.hasGenesisBlock && b.Header.Parent == s.latestBlockHash)
+
+	if extendsHead {
+		pendingState := s.copy()
+		undo := newBlockUndo(b, pendingState)
+
+		err = applyBlock(b, pendingState)
+		if err != nil {
+			s.badBlocks[hash] = struct{}{}
+			return Hash{}, nil, invalidBlockErr(err)
+		}
+
+		err = s.persistBlock(hash, b)
+		if err != nil {
+			return Hash{}, nil, err
+		}
+
+		err = s.indexBlockTXs(hash, b)
+		if err != nil {
+			return Hash{}, nil, err
+		}
+
+		node.undo = undo
+		node.accounts = pendingState.accounts
+		s.blocks[hash] = node
+		s.canonical = append(s.canonical, hash)
+		err = s.commit(pendingState, hash, b)
+		if err != nil {
+			return Hash{}, nil, err
+		}
+		s.snapshotIfDue()
+
+		return hash, nil, nil
+	}
+
+	if node.totalWork.Cmp(s.headWork()) <= 0 {
+		err = s.persistBlock(hash, b)
+		if err != nil {
+			return Hash{}, nil, err
+		}
+
+		s.blocks[hash] = node
+		fmt.Printf("Stored block '%x' on a side branch at height %d\n", hash, b.Header.Number)
+
+		return hash, nil, nil
+	}
+
+	reorg, err := s.reorganise(node, b)
+	if err != nil {
+		return Hash{}, nil, err
+	}
+
+	fmt.Printf("Chain reorganisation of depth %d: '%x' -> '%x'\n", reorg.Depth, reorg.OldHead, reorg.NewHead)
+
+	return hash, reorg, nil
+}
+
+// branchReplay is the state once a competing branch is applied, see replayBranch.
+type branchReplay struct {
+	state      *State
+	reorg      *Reorg
+	forkNumber int64
+
+	// branch blocks from the fork point on, with their undo journal and state tree
+	branch []*blockNode
+	undos  []*blockUndo
+	trees  []*stateNode
+}
+
+// reorganise switches the canonical chain to the branch ending in newHead, whose block is b.
+func (s *State) reorganise(newHead *blockNode, b Block) (*Reorg, error) {
+	replay, err := s.replayBranch(newHead, b)
+	if err != nil {
+		return nil, err
+	}
+
+	reorg := replay.reorg
+	branch := replay.branch
+	forkNumber := replay.forkNumber
+
+	err = s.persistBlock(newHead.hash(), b)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, old := range reorg.Disconnected {
+		s.unindexBlockTXs(old)
+	}
+	for i, n := range branch {
+		err = s.indexBlockTXs(n.hash(), reorg.Connected[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := len(s.canonical) - 1; i > int(forkNumber); i-- {
+		s.blocks[s.canonical[i]].undo = nil
+		s.blocks[s.canonical[i]].accounts = nil
+	}
+
+	s.canonical = s.canonical[:forkNumber+1]
+	for i, n := range branch {
+		n.undo = replay.undos[i]
+		n.accounts = replay.trees[i]
+		s.canonical = append(s.canonical, n.hash())
+	}
+
+	s.blocks[newHead.hash()] = newHead
+	err = s.commit(replay.state, newHead.hash(), b)
+	if err != nil {
+		return nil, err
+	}
+	s.snapshotIfDue()
+
+	return reorg, nil
+}
+
+// verifySideBlock fully validates b, a block stored on a side branch whose
+// header only was checked, by applying its branch on a copy of the state.
+func (s *State) verifySideBlock(hash Hash, b Block) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	n, isKnown := s.blocks[hash]
+	if !isKnown {
+		return fmt.Errorf("block '%x' %w", hash, ErrNotFound)
+	}
+
+	_, err := s.replayBranch(n, b)
+
+	return err
+}
+
+// replayBranch applies the branch ending in newHead, whose block is b, on a
+// copy of the state rolled back to where the branch forks off the canonical
+// chain. The blocks of the branch which don't apply are marked bad.
+func (s *State) replayBranch(newHead *blockNode, b Block) (*branchReplay, error) {
+	// Walk back from the new head until the branch meets the canonical chain
+	branch := []*blockNode{newHead}
+	forkNumber := int64(-1)
+
+	for parentHash := newHead.info.Parent; !parentHash.IsEmpty(); {
+		parent, isKnown := s.blocks[parentHash]
+		if !isKnown {
+			return nil, fmt.Errorf("%w. Block '%x' ancestor '%x' is unknown", ErrUnknownParent, newHead.hash(), parentHash)
+		}
+
+		if s.isCanonical(parent) {
+			forkNumber = int64(parent.info.Number)
+			break
+		}
+
+		branch = append([]*blockNode{parent}, branch...)
+		parentHash = parent.info.Parent
+	}
+
+	pendingState := s.copy()
+	reorg := &Reorg{OldHead: s.latestBlockHash, NewHead: newHead.hash()}
+
+	for i := len(s.canonical) - 1; i > int(forkNumber); i-- {
+		old := s.blocks[s.canonical[i]]
+		if old.undo == nil {
+			return nil, fmt.Errorf("block '%x' forks before the snapshot the state was loaded from, at height %d", newHead.hash(), forkNumber+1)
+		}
+
+		oldBlock, err := s.store.Get(old.hash())
+		if err != nil {
+			return nil, err
+		}
+
+		old.undo.revert(pendingState)
+
+		reorg.Disconnected = append(reorg.Disconnected, oldBlock)
+		reorg.Depth++
+	}
+
+	if forkNumber < 0 {
+		pendingState.latestBlock = Block{}
+		pendingState.latestBlockHash = Hash{}
+		pendingState.hasGenesisBlock = false
+	} else {
+		forkPoint, err := s.getBlockByNumber(uint64(forkNumber))
+		if err != nil {
+			return nil, err
+		}
+
+		pendingState.latestBlock = forkPoint
+		pendingState.latestBlockHash = s.canonical[forkNumber]
+	}
+
+	undos := make([]*blockUndo, len(branch))
+	trees := make([]*stateNode, len(branch))
+	for i, n := range branch {
+		block := b
+		if n != newHead {
+			var err error
+			block, err = s.store.Get(n.hash())
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		undos[i] = newBlockUndo(block, pendingState)
+
+		err := applyBlock(block, pendingState)
+		if err != nil {
+			for _, bad := range branch[i:] {
+				s.badBlocks[bad.hash()] = struct{}{}
+				delete(s.blocks, bad.hash())
+			}
+
+			if n == newHead {
+				return nil, invalidBlockErr(err)
+			}
+
+			return nil, fmt.Errorf("%w. Block '%x': %s", ErrBadBranch, n.hash(), err)
+		}
+
+		trees[i] = pendingState.accounts
+		pendingState.latestBlock = block
+		pendingState.latestBlockHash = n.hash()
+		pendingState.hasGenesisBlock = true
+
+		reorg.Connected = append(reorg.Connected, block)
+	}
+
+	return &branchReplay{
+		state:      pendingState,
+		reorg:      reorg,
+		forkNumber: forkNumber,
+		branch:     branch,
+		undos:      undos,
+		trees:      trees,
+	}, nil
+}
+
+// loadBlocks rebuilds the block tree from the index and replays the heaviest
+// chain, reading from disk only the blocks that end up canonical.
+//
+// Blocks up to the newest valid snapshot are only indexed, not replayed. They
+// have no undo journal, so the chain can't be reorganised below the snapshot.
+func (s *State) loadBlocks() error {
+	var best *blockNode
+
+	err := s.store.Iterate(func(e BlockInfo) error {
+		var parent *blockNode
+		parentWork := new(big.Int)
+		if e.Number != 0 || !e.Parent.IsEmpty() {
+			var isKnown bool
+			parent, isKnown = s.blocks[e.Parent]
+			if !isKnown {
+				return fmt.Errorf("block '%x' parent '%x' is unknown", e.Hash, e.Parent)
+			}
+
+			parentWork = parent.totalWork
+		}
+
+		n := &blockNode{info: e, totalWork: new(big.Int).Add(parentWork, e.work())}
+		s.blocks[e.Hash] = n
+
+		// On equal work, the branch that got there first stays canonical
+		if best == nil || n.totalWork.Cmp(best.totalWork) > 0 {
+			best = n
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if best == nil {
+		return nil
+	}
+
+	path := []*blockNode{best}
+	for n := best; n.info.Number > 0; {
+		n = s.blocks[n.info.Parent]
+		path = append([]*blockNode{n}, path...)
+	}
+
+	snapshotNumber := s.loadNewestSnapshot(path)
+
+	for _, n := range path {
+		b, err := s.store.Get(n.hash())
+		if err != nil {
+			return err
+		}
+
+		if int64(n.info.Number) <= snapshotNumber {
+			err = s.indexBlockTXs(n.hash(), b)
+			if err != nil {
+				return err
+			}
+
+			if int64(n.info.Number) == snapshotNumber {
+				n.accounts = s.accounts
+			}
+
+			s.canonical = append(s.canonical, n.hash())
+			s.latestBlock = b
+			s.latestBlockHash = n.hash()
+			s.hasGenesisBlock = true
+
+			continue
+		}
+
+		err = s.engine.VerifyHeader(chainView{s}, b.Header)
+		if err != nil {
+			return err
+		}
+
+		n.undo = newBlockUndo(b, s)
+
+		err = applyBlock(b, s)
+		if err != nil {
+			return err
+		}
+
+		err = s.indexBlockTXs(n.hash(), b)
+		if err != nil {
+			return err
+		}
+
+		n.accounts = s.accounts
+		s.canonical = append(s.canonical, n.hash())
+		s.latestBlock = b
+		s.latestBlockHash = n.hash()
+		s.hasGenesisBlock = true
+	}
+
+	return s.store.SetHead(s.latestBlockHash)
+}
+
+func (s *State) commit(pendingState *State, hash Hash, b Block) error {
+	err := s.store.SetHead(hash)
+	if err != nil {
+		return err
+	}
+
+	s.Balances = pendingState.Balances
+	s.Account2Nonce = pendingState.Account2Nonce
+	s.accounts = pendingState.accounts
+	s.latestBlockHash = hash
+	s.latestBlock = b
+	s.hasGenesisBlock = true
+
+	return nil
+}
+
+func (s *State) headWork() *big.Int {
+	if !s.hasGenesisBlock {
+		return new(big.Int)
+	}
+
+	return s.blocks[s.latestBlockHash].totalWork
+}
+
+func (s *State) isCanonical(n *blockNode) bool {
+	number := n.info.Number
+
+	return number < uint64(len(s.canonical)) && s.canonical[number] == n.hash()
+}
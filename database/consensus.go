@@ -0,0 +1,164 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Engine is the part of a consensus engine the State relies on to validate
+// and apply blocks. Package consensus extends it with building and sealing
+// them, ProofOfWork is the default.
+type Engine interface {
+	// VerifyHeader checks the consensus fields of a header, its parent is known to chain
+	VerifyHeader(chain ChainReader, header BlockHeader) error
+	// Finalize credits the block rewards, once the block TXs are applied to balances
+	Finalize(genesis Genesis, b Block, balances map[common.Address]uint)
+}
+
+// ChainReader gives consensus engines read access to the known blocks,
+// canonical or not.
+type ChainReader interface {
+	Genesis() Genesis
+	GetBlockInfo(hash Hash) (BlockInfo, bool)
+	GetBlockByHash(hash Hash) (Block, error)
+}
+
+// chainView reads the block tree of a State whose lock is already held,
+// engines get it while the State validates a block.
+type chainView struct {
+	s *State
+}
+
+func (c chainView) Genesis() Genesis {
+	return c.s.genesis
+}
+
+func (c chainView) GetBlockInfo(hash Hash) (BlockInfo, bool) {
+	n, isKnown := c.s.blocks[hash]
+	if !isKnown {
+		return BlockInfo{}, false
+	}
+
+	return n.info, true
+}
+
+func (c chainView) GetBlockByHash(hash Hash) (Block, error) {
+	if _, isKnown := c.s.blocks[hash]; !isKnown {
+		return Block{}, fmt.Errorf("block '%x' %w", hash, ErrNotFound)
+	}
+
+	return c.s.store.Get(hash)
+}
+
+// ProofOfWork is the default consensus: the block hash must be below the
+// target of the block difficulty, which retargets every
+// DifficultyAdjustmentInterval blocks, and the miner earns the block reward.
+type ProofOfWork struct{}
+
+func (pow ProofOfWork) VerifyHeader(chain ChainReader, header BlockHeader) error {
+	hash, err := header.Hash()
+	if err != nil {
+		return err
+	}
+
+	if !IsBlockHashValid(hash, header.Difficulty) {
+		return fmt.Errorf("invalid block hash %x", hash)
+	}
+
+	expectedDifficulty, err := pow.CalcDifficulty(chain, header.Parent)
+	if err != nil {
+		return err
+	}
+
+	if header.Difficulty != expectedDifficulty {
+		return fmt.Errorf("block '%x' difficulty must be '%d' not '%d'", hash, expectedDifficulty, header.Difficulty)
+	}
+
+	return nil
+}
+
+func (pow ProofOfWork) Finalize(genesis Genesis, b Block, balances map[common.Address]uint) {
+	balances[b.Header.Miner] += genesis.BlockReward + b.Fees()
+}
+
+// CalcDifficulty returns the difficulty of the block following parent, the
+// empty hash meaning the first block of the chain.
+//
+// Every DifficultyAdjustmentInterval blocks the difficulty is scaled by how much
+// faster or slower than TargetBlockTime the previous interval was mined, at most
+// by a factor of 4 either way. In between it stays the same as the parent's.
+func (pow ProofOfWork) CalcDifficulty(chain ChainReader, parentHash Hash) (uint64, error) {
+	genesis := chain.Genesis()
+	if parentHash.IsEmpty() {
+		return genesis.Difficulty, nil
+	}
+
+	parent, isKnown := chain.GetBlockInfo(parentHash)
+	if !isKnown {
+		return 0, fmt.Errorf("block parent '%x' is unknown", parentHash)
+	}
+
+	interval := genesis.DifficultyAdjustmentInterval
+	number := parent.Number + 1
+
+	if genesis.TargetBlockTime == 0 || number%interval != 0 {
+		return parent.Difficulty, nil
+	}
+
+	first := parent
+	for i := uint64(1); i < interval; i++ {
+		first, isKnown = chain.GetBlockInfo(first.Parent)
+		if !isKnown {
+			return 0, fmt.Errorf("block '%x' ancestor is unknown", parentHash)
+		}
+	}
+
+	expectedTimespan := int64((interval - 1) * genesis.TargetBlockTime)
+	actualTimespan := int64(parent.Time) - int64(first.Time)
+
+	if actualTimespan < expectedTimespan/4 {
+		actualTimespan = expectedTimespan / 4
+	}
+	if actualTimespan > expectedTimespan*4 {
+		actualTimespan = expectedTimespan * 4
+	}
+	if actualTimespan < 1 {
+		actualTimespan = 1
+	}
+
+	difficulty := new(big.Int).SetUint64(parent.Difficulty)
+	difficulty.Mul(difficulty, big.NewInt(expectedTimespan))
+	difficulty.Div(difficulty, big.NewInt(actualTimespan))
+
+	if difficulty.Sign() <= 0 {
+		return 1, nil
+	}
+	if !difficulty.IsUint64() {
+		return math.MaxUint64, nil
+	}
+
+	return difficulty.Uint64(), nil
+}
+
+// GetBlockInfo returns the index entry of any known block, canonical or on a side branch.
+func (s *State) GetBlockInfo(hash Hash) (BlockInfo, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return chainView{s}.GetBlockInfo(hash)
+}
+
+// NextBlockDifficulty is the difficulty a block mined on top of the head must
+// have under proof of work.
+func (s *State) NextBlockDifficulty() uint64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	// The head is always known, only an unknown parent fails
+	difficulty, _ := ProofOfWork{}.CalcDifficulty(chainView{s}, s.latestBlockHash)
+
+	return difficulty
+}
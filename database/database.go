@@ -1,45 +1,105 @@
 package database
 
-import (
-	"bufio"
-	"encoding/json"
-	"os"
-	"reflect"
-)
-
-func GetBlocksAfter(blockHash Hash, dataDir string) ([]Block, error) {
-	f, err := os.OpenFile(getBlocksDBFilePath(dataDir), os.O_RDONLY, 0600)
-	if err != nil {
-		return nil, err
-	}
+import "fmt"
 
-	blocks := make([]Block, 0)
-	shouldStartCollecting := false
+// GetBlocksAfter returns up to limit canonical blocks following blockHash.
+//
+// An empty hash returns the chain from genesis. A hash on a competing branch
+// returns the canonical blocks after the fork point, and an unknown hash the
+// chain from genesis, so the caller can always reach the same head by importing
+// the result and asking again for the blocks after the last one, until it gets
+// less than limit blocks.
+func (s *State) GetBlocksAfter(blockHash Hash, limit uint64) ([]Block, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 
-	if reflect.DeepEqual(blockHash, Hash{}) {
-		shouldStartCollecting = true
-	}
+	from := uint64(0)
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return nil, err
+	if n, isKnown := s.blocks[blockHash]; isKnown {
+		for !s.isCanonical(n) {
+			parent, hasParent := s.blocks[n.info.Parent]
+			if !hasParent {
+				n = nil
+				break
+			}
+			n = parent
 		}
 
-		var blockFs BlockFS
-		err = json.Unmarshal(scanner.Bytes(), &blockFs)
+		if n != nil {
+			from = n.info.Number + 1
+		}
+	}
+
+	to := uint64(len(s.canonical))
+	if to-from > limit {
+		to = from + limit
+	}
+
+	blocks := make([]Block, 0, to-from)
+	for _, hash := range s.canonical[from:to] {
+		b, err := s.store.Get(hash)
 		if err != nil {
 			return nil, err
 		}
 
-		if shouldStartCollecting {
-			blocks = append(blocks, blockFs.Value)
-			continue
-		}
+		blocks = append(blocks, b)
+	}
+
+	return blocks, nil
+}
 
-		if blockHash == blockFs.Key {
-			shouldStartCollecting = true
+// GetBlockByHash returns any known block, canonical or on a side branch.
+func (s *State) GetBlockByHash(blockHash Hash) (Block, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	n, isKnown := s.blocks[blockHash]
+	if !isKnown {
+		return Block{}, fmt.Errorf("block '%x' %w", blockHash, ErrNotFound)
+	}
+
+	return s.store.Get(n.hash())
+}
+
+// HasBlock tells whether the block was already stored, canonical or not.
+func (s *State) HasBlock(blockHash Hash) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	_, isKnown := s.blocks[blockHash]
+
+	return isKnown
+}
+
+// GetBlockByNumber returns the canonical block at the given height.
+func (s *State) GetBlockByNumber(number uint64) (Block, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.getBlockByNumber(number)
+}
+
+func (s *State) getBlockByNumber(number uint64) (Block, error) {
+	if number >= uint64(len(s.canonical)) {
+		return Block{}, fmt.Errorf("block number '%d' %w", number, ErrNotFound)
+	}
+
+	return s.store.GetByNumber(number)
+}
+
+// GetBlocks returns up to limit canonical blocks, starting at height from.
+func (s *State) GetBlocks(from uint64, limit uint64) ([]Block, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	blocks := make([]Block, 0)
+	for number := from; number < uint64(len(s.canonical)) && number-from < limit; number++ {
+		b, err := s.getBlockByNumber(number)
+		if err != nil {
+			return nil, err
 		}
+
+		blocks = append(blocks, b)
 	}
 
 	return blocks, nil
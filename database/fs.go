@@ -9,6 +9,9 @@ import (
 const databaseFolderName = "database"
 const genesisFileName = "genesis.json"
 const blockFileName = "block.db"
+const blockIndexFileName = "block.idx"
+const snapshotsFolderName = "snapshots"
+const levelDBFolderName = "blocks.ldb"
 
 func InitDataDirIfNotExists(dataDir string, genesis []byte) error {
 	if fileExist(getGenesisJSONFilePath(dataDir)) {
@@ -42,6 +45,18 @@ func getBlocksDBFilePath(dataDir string) string {
 	return filepath.Join(getDatabaseDirPath(dataDir), blockFileName)
 }
 
+func getBlockIndexFilePath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), blockIndexFileName)
+}
+
+func getLevelDBDirPath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), levelDBFolderName)
+}
+
+func getSnapshotsDirPath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), snapshotsFolderName)
+}
+
 func fileExist(filePath string) bool {
 	_, err := os.Stat(filePath)
 	if err != nil && os.IsNotExist(err) {
@@ -1,40 +1,165 @@
 package database
 
 import (
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 )
 
+const DefaultBlockReward = 100
+const DefaultMaxBlockSize = 1 << 20
+const DefaultPoAPeriod = 5
+
+// Consensus engines a genesis can choose, see package consensus
+const ConsensusPoW = "pow"
+const ConsensusPoA = "poa"
+
+// Forks a genesis can schedule, by name, see Genesis.Forks
+
+// ForkBlockTXOrder applies the TXs of a block in the order the block lists
+// them. Before it, they're applied by time, and TXs of a sender sharing the
+// same second could be applied out of nonce order and fail the block.
+const ForkBlockTXOrder = "block_tx_order"
+
 var genesisJSON = `
 {
 	"genesis_time": "2020-08-17T15:53:00.000000000Z",
 	"chain_id": "the-blockchain-bar-ledger",
+	"block_reward": 100,
+	"difficulty": 16777216,
+	"target_block_time": 60,
+	"difficulty_adjustment_interval": 10,
+	"max_block_size": 1048576,
+	"forks": {},
 	"balances": {
 		"0xb61E2B65e6066b0575EdD91f992B8ee8Dbd96481": 1000000
 	}
 }`
 
+// Genesis is the chain configuration every node of the network must share.
+//
+// Settings missing from genesis.json fall back to their defaults.
 type Genesis struct {
-	Balances map[common.Address]uint `json:"balances"`
+	GenesisTime time.Time               `json:"genesis_time"`
+	ChainID     string                  `json:"chain_id"`
+	Balances    map[common.Address]uint `json:"balances"`
+
+	// BlockReward credited to the miner of every block
+	BlockReward uint `json:"block_reward,omitempty"`
+	// Difficulty of the first block
+	Difficulty uint64 `json:"difficulty,omitempty"`
+	// TargetBlockTime in seconds the difficulty retargets toward, 0 disables retargeting
+	TargetBlockTime uint64 `json:"target_block_time,omitempty"`
+	// DifficultyAdjustmentInterval is how many blocks pass between two retargets
+	DifficultyAdjustmentInterval uint64 `json:"difficulty_adjustment_interval,omitempty"`
+	// MaxBlockSize is the maximum size in bytes of a JSON encoded block
+	MaxBlockSize uint64 `json:"max_block_size,omitempty"`
+	// Forks maps the name of a consensus rule change, such as ForkBlockTXOrder, to the block number it activates at
+	Forks map[string]uint64 `json:"forks,omitempty"`
+	// Consensus is the engine validating blocks, ConsensusPoW when empty
+	Consensus string `json:"consensus,omitempty"`
+	// PoA configures the ConsensusPoA engine
+	PoA *PoAConfig `json:"poa,omitempty"`
+}
+
+// PoAConfig is the proof of authority setup of a chain.
+type PoAConfig struct {
+	// Signers are the accounts allowed to seal the first blocks, they vote to change the set later on
+	Signers []common.Address `json:"signers"`
+	// Period in seconds between two blocks, DefaultPoAPeriod when empty
+	Period uint64 `json:"period,omitempty"`
+}
+
+// DevChainID identifies the local development chains of --dev nodes, see DevGenesis.
+const DevChainID = "the-blockchain-bar-dev"
+
+// DevGenesis returns the genesis of a local development chain prefunding
+// devAccount. At difficulty 1 every hash is a valid block hash, so blocks are
+// sealed at the first nonce, and the difficulty never retargets.
+func DevGenesis(devAccount common.Address) Genesis {
+	return Genesis{
+		GenesisTime:  time.Date(2020, 8, 17, 15, 53, 0, 0, time.UTC),
+		ChainID:      DevChainID,
+		Balances:     map[common.Address]uint{devAccount: 1000000},
+		BlockReward:  DefaultBlockReward,
+		Difficulty:   1,
+		MaxBlockSize: DefaultMaxBlockSize,
+	}
+}
+
+// IsForkActive reports whether the named fork applies to the block at the given height.
+func (g Genesis) IsForkActive(fork string, number uint64) bool {
+	activation, isScheduled := g.Forks[fork]
+
+	return isScheduled && number >= activation
+}
+
+// Hash identifies the chain, two nodes with a different genesis hash can't sync.
+func (g Genesis) Hash() (Hash, error) {
+	genesisJSON, err := json.Marshal(g)
+	if err != nil {
+		return Hash{}, err
+	}
+
+	return sha256.Sum256(genesisJSON), nil
 }
 
 func writeGenesisToDisk(path string, genesis []byte) error {
 	return ioutil.WriteFile(path, genesis, 0644)
 }
 
+// LoadGenesis returns the genesis of the data dir, initialising the dir with
+// the default genesis when it's new.
+func LoadGenesis(dataDir string) (Genesis, error) {
+	err := InitDataDirIfNotExists(dataDir, []byte(genesisJSON))
+	if err != nil {
+		return Genesis{}, err
+	}
+
+	return loadGenesis(getGenesisJSONFilePath(dataDir))
+}
+
 func loadGenesis(path string) (Genesis, error) {
 	content, err := ioutil.ReadFile(path)
 	if err != nil {
 		return Genesis{}, err
 	}
 
-	var loadedGenesis Genesis
+	loadedGenesis := Genesis{
+		BlockReward:  DefaultBlockReward,
+		Difficulty:   DefaultDifficulty,
+		MaxBlockSize: DefaultMaxBlockSize,
+	}
+
 	err = json.Unmarshal(content, &loadedGenesis)
 	if err != nil {
 		return Genesis{}, err
 	}
 
+	if loadedGenesis.Difficulty == 0 {
+		return Genesis{}, fmt.Errorf("difficulty must be at least 1")
+	}
+
+	switch loadedGenesis.Consensus {
+	case "", ConsensusPoW:
+	case ConsensusPoA:
+		if loadedGenesis.PoA == nil || len(loadedGenesis.PoA.Signers) == 0 {
+			return Genesis{}, fmt.Errorf("the %s consensus needs at least 1 signer in 'poa'", ConsensusPoA)
+		}
+		if loadedGenesis.PoA.Period == 0 {
+			loadedGenesis.PoA.Period = DefaultPoAPeriod
+		}
+	default:
+		return Genesis{}, fmt.Errorf("unknown consensus '%s'", loadedGenesis.Consensus)
+	}
+
+	if loadedGenesis.TargetBlockTime > 0 && loadedGenesis.DifficultyAdjustmentInterval < 2 {
+		return Genesis{}, fmt.Errorf("difficulty_adjustment_interval must be at least 2 blocks to retarget, not %d", loadedGenesis.DifficultyAdjustmentInterval)
+	}
+
 	return loadedGenesis, nil
 }
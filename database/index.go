@@ -0,0 +1,218 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// The block index maps every block in block.db to its position in the file,
+// so blocks can be looked up and the chain rebuilt without decoding the whole DB.
+//
+// Layout: an 8 bytes header (magic + version) followed by fixed size records:
+//
+//	hash (32) | parent (32) | number (8) | time (8) | difficulty (8) | offset (8) | length (4)
+const blockIndexMagic = "TBBIDX"
+const blockIndexVersion = 2
+const blockIndexHeaderSize = 8
+const blockIndexRecordSize = blockInfoSize + 8 + 4
+
+type blockIndexEntry struct {
+	BlockInfo
+	Offset int64
+	Length uint32
+}
+
+func newBlockIndexEntry(hash Hash, b Block, offset int64, length uint32) blockIndexEntry {
+	return blockIndexEntry{NewBlockInfo(hash, b), offset, length}
+}
+
+func (e blockIndexEntry) end() int64 {
+	return e.Offset + int64(e.Length)
+}
+
+func (e blockIndexEntry) encode() []byte {
+	buf := make([]byte, blockIndexRecordSize)
+	copy(buf, e.BlockInfo.encode())
+	binary.BigEndian.PutUint64(buf[88:96], uint64(e.Offset))
+	binary.BigEndian.PutUint32(buf[96:100], e.Length)
+
+	return buf
+}
+
+func decodeBlockIndexEntry(buf []byte) blockIndexEntry {
+	var e blockIndexEntry
+	e.BlockInfo = decodeBlockInfo(buf[:blockInfoSize])
+	e.Offset = int64(binary.BigEndian.Uint64(buf[88:96]))
+	e.Length = binary.BigEndian.Uint32(buf[96:100])
+
+	return e
+}
+
+func blockIndexHeader() []byte {
+	header := make([]byte, blockIndexHeaderSize)
+	copy(header, blockIndexMagic)
+	header[blockIndexHeaderSize-1] = blockIndexVersion
+
+	return header
+}
+
+// openBlockIndex loads the index of dbFile, rebuilding it from scratch when it's
+// missing or unreadable and indexing any blocks appended after its last record.
+func openBlockIndex(path string, dbFile *os.File) (*os.File, []blockIndexEntry, error) {
+	dbInfo, err := dbFile.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries, isClean, err := readBlockIndex(path, dbInfo.Size())
+	if err != nil {
+		fmt.Printf("Rebuilding block index: %s\n", err)
+
+		entries = nil
+		err = writeBlockIndex(path, entries)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if !isClean {
+		fmt.Printf("Truncating block index to its %d records matching the blocks DB\n", len(entries))
+
+		err = writeBlockIndex(path, entries)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	indexedSize := int64(0)
+	if len(entries) > 0 {
+		indexedSize = entries[len(entries)-1].end()
+	}
+
+	if indexedSize < dbInfo.Size() {
+		missing, err := scanBlocksDB(dbFile, indexedSize)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+
+		for _, e := range missing {
+			_, err = f.Write(e.encode())
+			if err != nil {
+				f.Close()
+				return nil, nil, err
+			}
+		}
+
+		entries = append(entries, missing...)
+	}
+
+	return f, entries, nil
+}
+
+// readBlockIndex reads the index records of a blocks DB of dbSize bytes. A torn
+// last record, or records of blocks the DB lost, are left out and the index
+// reported as not clean.
+func readBlockIndex(path string, dbSize int64) ([]blockIndexEntry, bool, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(content) < blockIndexHeaderSize || !bytes.Equal(content[:blockIndexHeaderSize], blockIndexHeader()) {
+		return nil, false, fmt.Errorf("unknown block index format")
+	}
+
+	content = content[blockIndexHeaderSize:]
+	isClean := len(content)%blockIndexRecordSize == 0
+	content = content[:len(content)-len(content)%blockIndexRecordSize]
+
+	entries := make([]blockIndexEntry, 0, len(content)/blockIndexRecordSize)
+	end := int64(0)
+
+	for i := 0; i < len(content); i += blockIndexRecordSize {
+		e := decodeBlockIndexEntry(content[i : i+blockIndexRecordSize])
+		if e.Offset != end {
+			return nil, false, fmt.Errorf("block index doesn't match the blocks DB")
+		}
+
+		if e.end() > dbSize {
+			return entries, false, nil
+		}
+
+		entries = append(entries, e)
+		end = e.end()
+	}
+
+	return entries, isClean, nil
+}
+
+func writeBlockIndex(path string, entries []blockIndexEntry) error {
+	buf := bytes.NewBuffer(blockIndexHeader())
+	for _, e := range entries {
+		buf.Write(e.encode())
+	}
+
+	return ioutil.WriteFile(path, buf.Bytes(), 0600)
+}
+
+// scanBlocksDB indexes every block stored in dbFile from the given offset on.
+func scanBlocksDB(dbFile *os.File, from int64) ([]blockIndexEntry, error) {
+	reader := bufio.NewReader(io.NewSectionReader(dbFile, from, 1<<62))
+	entries := make([]blockIndexEntry, 0)
+	offset := from
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		blockFsJSON := bytes.TrimSpace(line)
+		if len(blockFsJSON) == 0 {
+			break
+		}
+
+		var blockFs BlockFS
+		err = json.Unmarshal(blockFsJSON, &blockFs)
+		if err != nil {
+			return nil, fmt.Errorf("unable to index block at offset %d. %s", offset, err.Error())
+		}
+
+		entries = append(entries, newBlockIndexEntry(blockFs.Key, blockFs.Value, offset, uint32(len(line))))
+
+		offset += int64(len(line))
+	}
+
+	return entries, nil
+}
+
+// readBlockAt decodes the block stored at the position e points to.
+func readBlockAt(dbFile *os.File, e blockIndexEntry) (Block, error) {
+	buf := make([]byte, e.Length)
+
+	_, err := dbFile.ReadAt(buf, e.Offset)
+	if err != nil {
+		return Block{}, fmt.Errorf("unable to read block '%x'. %s", e.Hash, err.Error())
+	}
+
+	var blockFs BlockFS
+	err = json.Unmarshal(buf, &blockFs)
+	if err != nil {
+		return Block{}, fmt.Errorf("unable to decode block '%x'. %s", e.Hash, err.Error())
+	}
+
+	return blockFs.Value, nil
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const lockFileName = "tbb.lock"
+
+// ErrDataDirLocked is returned when another process already opened the data dir.
+var ErrDataDirLocked = errors.New("data dir is locked")
+
+// dataDirLock is an exclusive OS lock on a file of the data dir, holding the
+// PID of its owner. The OS drops it when the process dies, so a crash never
+// leaves a stale lock behind.
+type dataDirLock struct {
+	f *os.File
+}
+
+func lockDataDir(dataDir string) (*dataDirLock, error) {
+	err := os.MkdirAll(dataDir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dataDir, lockFileName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = lockFile(f)
+	if err != nil {
+		f.Close()
+
+		owner := "unknown"
+		if content, readErr := ioutil.ReadFile(path); readErr == nil {
+			if pid, parseErr := strconv.Atoi(strings.TrimSpace(string(content))); parseErr == nil {
+				owner = strconv.Itoa(pid)
+			}
+		}
+
+		return nil, fmt.Errorf("%w: '%s' is in use by process %s. %s", ErrDataDirLocked, dataDir, owner, err.Error())
+	}
+
+	err = f.Truncate(0)
+	if err == nil {
+		_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err != nil {
+		unlockFile(f)
+		f.Close()
+		return nil, err
+	}
+
+	return &dataDirLock{f}, nil
+}
+
+func (l *dataDirLock) release() error {
+	err := unlockFile(l.f)
+	if err != nil {
+		l.f.Close()
+		return err
+	}
+
+	return l.f.Close()
+}
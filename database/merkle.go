@@ -0,0 +1,135 @@
+package database
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// The TX root is the root of a Merkle tree whose leaves are the block TX hashes.
+//
+// Leaves and inner nodes are hashed with a different prefix, so an inner node
+// can never pass for a TX. A node without a sibling moves up a level as is.
+const merkleLeafPrefix = 0x00
+const merkleNodePrefix = 0x01
+
+// MerkleProofStep is a sibling hash on the path from a TX up to the TX root.
+type MerkleProofStep struct {
+	Hash Hash `json:"hash"`
+	// Left tells whether the sibling is the left node of the pair
+	Left bool `json:"left"`
+}
+
+// TxProof proves a TX is part of the block whose header holds the TX root.
+type TxProof struct {
+	TxHash Hash              `json:"tx_hash"`
+	Steps  []MerkleProofStep `json:"steps"`
+}
+
+// TxRoot computes the Merkle root of txs, the empty hash when there are none.
+func TxRoot(txs []SignedTx) (Hash, error) {
+	levels, err := merkleLevels(txs)
+	if err != nil {
+		return Hash{}, err
+	}
+
+	if len(levels) == 0 {
+		return Hash{}, nil
+	}
+
+	return levels[len(levels)-1][0], nil
+}
+
+// NewTxProof builds the inclusion proof of the TX at index in txs.
+func NewTxProof(txs []SignedTx, index int) (TxProof, error) {
+	if index < 0 || index >= len(txs) {
+		return TxProof{}, fmt.Errorf("TX index %d is out of the %d block TXs", index, len(txs))
+	}
+
+	txHash, err := txs[index].Hash()
+	if err != nil {
+		return TxProof{}, err
+	}
+
+	levels, err := merkleLevels(txs)
+	if err != nil {
+		return TxProof{}, err
+	}
+
+	proof := TxProof{TxHash: txHash, Steps: make([]MerkleProofStep, 0)}
+
+	for _, level := range levels[:len(levels)-1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof.Steps = append(proof.Steps, MerkleProofStep{level[sibling], sibling < index})
+		}
+
+		index /= 2
+	}
+
+	return proof, nil
+}
+
+// VerifyTxProof checks proof leads from its TX up to txRoot. Light clients
+// only need the block header, which the block hash commits to, to trust txRoot.
+func VerifyTxProof(proof TxProof, txRoot Hash) bool {
+	hash := merkleLeaf(proof.TxHash)
+
+	for _, step := range proof.Steps {
+		if step.Left {
+			hash = merkleNode(step.Hash, hash)
+		} else {
+			hash = merkleNode(hash, step.Hash)
+		}
+	}
+
+	return hash == txRoot
+}
+
+// merkleLevels returns every level of the tree, from the leaves up to the root.
+func merkleLevels(txs []SignedTx) ([][]Hash, error) {
+	if len(txs) == 0 {
+		return nil, nil
+	}
+
+	level := make([]Hash, len(txs))
+	for i, tx := range txs {
+		txHash, err := tx.Hash()
+		if err != nil {
+			return nil, err
+		}
+
+		level[i] = merkleLeaf(txHash)
+	}
+
+	levels := [][]Hash{level}
+
+	for len(level) > 1 {
+		next := make([]Hash, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+
+			next = append(next, merkleNode(level[i], level[i+1]))
+		}
+
+		levels = append(levels, next)
+		level = next
+	}
+
+	return levels, nil
+}
+
+func merkleLeaf(txHash Hash) Hash {
+	return sha256.Sum256(append([]byte{merkleLeafPrefix}, txHash[:]...))
+}
+
+func merkleNode(left, right Hash) Hash {
+	buf := make([]byte, 0, 1+2*len(left))
+	buf = append(buf, merkleNodePrefix)
+	buf = append(buf, left[:]...)
+	buf = append(buf, right[:]...)
+
+	return sha256.Sum256(buf)
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestTxProof(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+
+	txs := make([]SignedTx, 0)
+
+	// Odd and even sized trees, with nodes moving up a level without a sibling
+	for n := 1; n <= 9; n++ {
+		txs = append(txs, signTestTx(t, key, sender, uint(n), uint(n)))
+
+		txRoot, err := TxRoot(txs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := range txs {
+			proof, err := NewTxProof(txs, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !VerifyTxProof(proof, txRoot) {
+				t.Fatalf("proof of TX %d out of %d doesn't verify", i, n)
+			}
+
+			if n > 1 {
+				proof.Steps[0].Left = !proof.Steps[0].Left
+				if VerifyTxProof(proof, txRoot) {
+					t.Fatalf("tampered proof of TX %d out of %d shouldn't verify", i, n)
+				}
+			}
+		}
+	}
+
+	otherTxs := append([]SignedTx{}, txs[1:]...)
+	otherRoot, err := TxRoot(otherTxs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proof, err := NewTxProof(txs, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if VerifyTxProof(proof, otherRoot) {
+		t.Fatal("a proof shouldn't verify against the TX root of another block")
+	}
+}
@@ -0,0 +1,251 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// A snapshot saves the balances and nonces as of a canonical block, so the
+// State can start from it instead of replaying every block since genesis.
+// The block header state root tells whether a snapshot can be trusted.
+const snapshotVersion = 1
+const snapshotFilePrefix = "snapshot_"
+const snapshotFileExt = ".json"
+
+// SnapshotInterval is the number of blocks between two snapshots the State writes on its own.
+const SnapshotInterval = 1000
+
+// snapshotsToKeep older snapshots are deleted once a new one is written
+const snapshotsToKeep = 3
+
+type snapshot struct {
+	Version     int                     `json:"version"`
+	GenesisHash Hash                    `json:"genesis_hash"`
+	BlockHash   Hash                    `json:"block_hash"`
+	Block       Block                   `json:"block"`
+	Balances    map[common.Address]uint `json:"balances"`
+	Nonces      map[common.Address]uint `json:"nonces"`
+}
+
+// Snapshot saves the state of the current head to disk and returns the snapshot path.
+func (s *State) Snapshot() (string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.writeSnapshot()
+}
+
+func (s *State) writeSnapshot() (string, error) {
+	if !s.hasGenesisBlock {
+		return "", fmt.Errorf("no blocks to snapshot yet")
+	}
+
+	snap := snapshot{
+		Version:     snapshotVersion,
+		GenesisHash: s.genesisHash,
+		BlockHash:   s.latestBlockHash,
+		Block:       s.latestBlock,
+		Balances:    s.Balances,
+		Nonces:      s.Account2Nonce,
+	}
+
+	snapJSON, err := json.Marshal(snap)
+	if err != nil {
+		return "", err
+	}
+
+	dir := getSnapshotsDirPath(s.dataDir)
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+
+	// Write aside and rename, a crash never leaves a half written snapshot behind
+	tmpFile, err := ioutil.TempFile(dir, snapshotFilePrefix+"*.tmp")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tmpFile.Write(snapJSON)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return "", err
+	}
+
+	path := getSnapshotFilePath(s.dataDir, s.latestBlock.Header.Number)
+	err = os.Rename(tmpFile.Name(), path)
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return "", err
+	}
+
+	fmt.Printf("Saved state snapshot of block %d '%x'\n", s.latestBlock.Header.Number, s.latestBlockHash)
+
+	numbers, err := listSnapshots(s.dataDir)
+	if err != nil {
+		return path, err
+	}
+
+	for i := snapshotsToKeep; i < len(numbers); i++ {
+		os.Remove(getSnapshotFilePath(s.dataDir, numbers[i]))
+	}
+
+	return path, nil
+}
+
+// snapshotIfDue writes a snapshot every SnapshotInterval blocks. Failing to
+// write one only costs a longer replay on the next start.
+func (s *State) snapshotIfDue() {
+	// States without a data dir, like the one verifying the DB, keep nothing on disk
+	if s.dataDir == "" {
+		return
+	}
+
+	if s.latestBlock.Header.Number == 0 || s.latestBlock.Header.Number%SnapshotInterval != 0 {
+		return
+	}
+
+	_, err := s.writeSnapshot()
+	if err != nil {
+		fmt.Printf("Unable to save state snapshot: %s\n", err)
+	}
+}
+
+// loadNewestSnapshot loads the newest valid snapshot of a block on the chain
+// path, which starts at genesis. It returns the number of that block, or -1
+// when there is no snapshot to start from.
+func (s *State) loadNewestSnapshot(path []*blockNode) int64 {
+	numbers, err := listSnapshots(s.dataDir)
+	if err != nil {
+		fmt.Printf("Unable to list state snapshots: %s\n", err)
+		return -1
+	}
+
+	for _, number := range numbers {
+		if number >= uint64(len(path)) {
+			continue
+		}
+
+		snapState, err := s.readSnapshot(number, path[number])
+		if err != nil {
+			fmt.Printf("Skipping state snapshot of block %d: %s\n", number, err)
+			continue
+		}
+
+		s.Balances = snapState.Balances
+		s.Account2Nonce = snapState.Account2Nonce
+		s.accounts = snapState.accounts
+
+		fmt.Printf("Loaded state snapshot of block %d '%x'\n", number, path[number].hash())
+
+		return int64(number)
+	}
+
+	return -1
+}
+
+func (s *State) readSnapshot(number uint64, n *blockNode) (*State, error) {
+	content, err := ioutil.ReadFile(getSnapshotFilePath(s.dataDir, number))
+	if err != nil {
+		return nil, err
+	}
+
+	var snap snapshot
+	err = json.Unmarshal(content, &snap)
+	if err != nil {
+		return nil, err
+	}
+
+	if snap.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported version %d", snap.Version)
+	}
+
+	if snap.GenesisHash != s.genesisHash {
+		return nil, fmt.Errorf("snapshot of another chain '%x'", snap.GenesisHash)
+	}
+
+	if snap.BlockHash != n.hash() {
+		return nil, fmt.Errorf("block '%x' is not canonical", snap.BlockHash)
+	}
+
+	blockHash, err := snap.Block.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	if blockHash != snap.BlockHash {
+		return nil, fmt.Errorf("block doesn't match its hash '%x'", snap.BlockHash)
+	}
+
+	snapState := &State{
+		Balances:      make(map[common.Address]uint),
+		Account2Nonce: make(map[common.Address]uint),
+	}
+
+	accounts := make([]common.Address, 0, len(snap.Balances)+len(snap.Nonces))
+	for account, balance := range snap.Balances {
+		snapState.Balances[account] = balance
+		accounts = append(accounts, account)
+	}
+	for account, nonce := range snap.Nonces {
+		snapState.Account2Nonce[account] = nonce
+		accounts = append(accounts, account)
+	}
+	snapState.updateAccounts(accounts)
+
+	if stateRoot := snapState.stateRoot(); stateRoot != snap.Block.Header.StateRoot {
+		return nil, fmt.Errorf("state root '%x' doesn't match the block one '%x'", stateRoot, snap.Block.Header.StateRoot)
+	}
+
+	return snapState, nil
+}
+
+// listSnapshots returns the block numbers of the snapshots on disk, newest first.
+func listSnapshots(dataDir string) ([]uint64, error) {
+	files, err := ioutil.ReadDir(getSnapshotsDirPath(dataDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := make([]uint64, 0, len(files))
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasPrefix(name, snapshotFilePrefix) || !strings.HasSuffix(name, snapshotFileExt) {
+			continue
+		}
+
+		number, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, snapshotFilePrefix), snapshotFileExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		numbers = append(numbers, number)
+	}
+
+	sort.Slice(numbers, func(i, j int) bool {
+		return numbers[i] > numbers[j]
+	})
+
+	return numbers, nil
+}
+
+func getSnapshotFilePath(dataDir string, number uint64) string {
+	return filepath.Join(getSnapshotsDirPath(dataDir), fmt.Sprintf("%s%010d%s", snapshotFilePrefix, number, snapshotFileExt))
+}
@@ -1,93 +1,136 @@
 package database
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
-	"os"
-	"reflect"
 	"sort"
+	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
 )
 
+// State is safe for concurrent use. Blocks are imported under an exclusive
+// lock while every getter only needs a shared one.
+//
+// The Balances and Account2Nonce maps are swapped for fresh copies on every
+// imported block and never modified once published, use LatestBalances,
+// GetBalance and GetNextAccountNonce to read them from other goroutines.
 type State struct {
 	Balances      map[common.Address]uint
 	Account2Nonce map[common.Address]uint
 
-	dbFile *os.File
+	lock sync.RWMutex
+
+	dataDir     string
+	dataDirLock *dataDirLock
+	store       BlockStore
 
 	latestBlock     Block
 	latestBlockHash Hash
 	hasGenesisBlock bool
+
+	genesis     Genesis
+	genesisHash Hash
+
+	blocks    map[Hash]*blockNode
+	canonical []Hash
+	badBlocks map[Hash]struct{}
+
+	// Indexes of the canonical TXs, by hash and by account
+	txs        map[Hash]TxLocation
+	accountTXs map[common.Address][]Hash
+
+	// accounts is the state tree of Balances and Account2Nonce, see StateRoot
+	accounts *stateNode
+
+	engine Engine
 }
 
+// NewStateFromDisk loads the State from the blocks stored in block.db.
 func NewStateFromDisk(dataDir string) (*State, error) {
+	return NewStateWithBlockStore(dataDir, BlockStoreFile)
+}
+
+// NewStateWithBlockStore loads the State from the block store of the given
+// kind, see OpenBlockStore.
+func NewStateWithBlockStore(dataDir string, blockStore string) (*State, error) {
+	return NewStateWithEngine(dataDir, blockStore, ProofOfWork{})
+}
+
+// NewStateWithEngine loads the State from the block store of the given kind,
+// validating blocks with the consensus engine of the chain.
+func NewStateWithEngine(dataDir string, blockStore string, engine Engine) (*State, error) {
 	err := InitDataDirIfNotExists(dataDir, []byte(genesisJSON))
 	if err != nil {
 		return &State{}, err
 	}
 
+	dataDirLock, err := lockDataDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
 	gen, err := loadGenesis(getGenesisJSONFilePath(dataDir))
 	if err != nil {
+		dataDirLock.release()
 		return nil, err
 	}
 
-	balances := make(map[common.Address]uint)
-	for account, balance := range gen.Balances {
-		balances[account] = balance
+	genHash, err := gen.Hash()
+	if err != nil {
+		dataDirLock.release()
+		return nil, err
 	}
 
-	account2nonce := make(map[common.Address]uint)
+	store, err := OpenBlockStore(blockStore, dataDir)
+	if err != nil {
+		dataDirLock.release()
+		return nil, err
+	}
+
+	state := newState(dataDir, gen, genHash, store, engine)
+	state.dataDirLock = dataDirLock
 
-	dbFilePath := getBlocksDBFilePath(dataDir)
-	f, err := os.OpenFile(
-		dbFilePath,
-		os.O_APPEND|os.O_RDWR,
-		0600,
-	)
+	err = state.loadBlocks()
 	if err != nil {
+		state.Close()
 		return nil, err
 	}
 
-	state := &State{
-		balances,
-		account2nonce,
-		f,
-		Block{},
-		Hash{},
-		false,
-	}
-
-	scanner := bufio.NewScanner(f)
-	// Iterate over each the tx.db file's lines
-	for scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return nil, err
-		}
+	return state, nil
+}
 
-		blockFsJSON := scanner.Bytes()
-		if len(blockFsJSON) == 0 {
-			break
-		}
+// newState returns a State at genesis, before any block.
+func newState(dataDir string, gen Genesis, genHash Hash, store BlockStore, engine Engine) *State {
+	balances := make(map[common.Address]uint)
+	for account, balance := range gen.Balances {
+		balances[account] = balance
+	}
 
-		var blockFs BlockFS
-		err = json.Unmarshal(blockFsJSON, &blockFs)
-		if err != nil {
-			return nil, err
-		}
+	account2nonce := make(map[common.Address]uint)
 
-		err = applyBlock(blockFs.Value, state)
-		if err != nil {
-			return nil, err
-		}
+	state := &State{
+		Balances:      balances,
+		Account2Nonce: account2nonce,
+		genesis:       gen,
+		genesisHash:   genHash,
+		dataDir:       dataDir,
+		store:         store,
+		blocks:        make(map[Hash]*blockNode),
+		canonical:     make([]Hash, 0),
+		badBlocks:     make(map[Hash]struct{}),
+		txs:           make(map[Hash]TxLocation),
+		accountTXs:    make(map[common.Address][]Hash),
+		engine:        engine,
+	}
 
-		state.latestBlock = blockFs.Value
-		state.latestBlockHash = blockFs.Key
-		state.hasGenesisBlock = true
+	genesisAccounts := make([]common.Address, 0, len(gen.Balances))
+	for account := range gen.Balances {
+		genesisAccounts = append(genesisAccounts, account)
 	}
+	state.updateAccounts(genesisAccounts)
 
-	return state, nil
+	return state
 }
 
 func (s *State) AddBlocks(blocks []Block) error {
@@ -100,52 +143,121 @@ func (s *State) AddBlocks(blocks []Block) error {
 
 	return nil
 }
-func (s *State) AddBlock(b Block) (Hash, error) {
-	pendingState := s.copy()
+func (s *State) persistBlock(blockHash Hash, b Block) error {
+	return s.store.Put(blockHash, b)
+}
 
-	err := applyBlock(b, &pendingState)
-	if err != nil {
-		return Hash{}, err
+func (s *State) GetNextAccountNonce(account common.Address) uint {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.getNextAccountNonce(account)
+}
+
+func (s *State) getNextAccountNonce(account common.Address) uint {
+	return s.Account2Nonce[account] + 1
+}
+
+// ValidatePendingTX checks tx would apply on top of the current state once
+// the sender's own TXs among pendingTXs got mined before it.
+func (s *State) ValidatePendingTX(tx SignedTx, pendingTXs []SignedTx) error {
+	s.lock.RLock()
+	pendingState := &State{
+		Balances:      map[common.Address]uint{tx.From: s.Balances[tx.From]},
+		Account2Nonce: map[common.Address]uint{tx.From: s.Account2Nonce[tx.From]},
 	}
+	s.lock.RUnlock()
 
-	blockHash, err := b.Hash()
-	if err != nil {
-		return Hash{}, err
+	senderTXs := make([]SignedTx, 0)
+	for _, pendingTX := range pendingTXs {
+		if pendingTX.From == tx.From {
+			senderTXs = append(senderTXs, pendingTX)
+		}
 	}
 
-	blockFs := BlockFS{blockHash, b}
-	blockFsJSON, err := json.Marshal(blockFs)
-	if err != nil {
-		return Hash{}, err
+	sort.Slice(senderTXs, func(i, j int) bool {
+		return senderTXs[i].Nonce < senderTXs[j].Nonce
+	})
+
+	for _, pendingTX := range senderTXs {
+		err := applyTx(pendingTX, pendingState)
+		if err != nil {
+			break
+		}
+	}
+
+	return applyTx(tx, pendingState)
+}
+
+// FilterBlockTXs splits the TXs of b, the next block on top of the head, into
+// the ones which apply one after the other and the ones which don't, each in
+// the order of b.TXs. Mining only the valid ones, no TX fails the block.
+func (s *State) FilterBlockTXs(b Block) ([]SignedTx, []SignedTx, error) {
+	s.lock.RLock()
+	if b.Header.Parent != s.latestBlockHash {
+		s.lock.RUnlock()
+		return nil, nil, fmt.Errorf("block parent '%x' is not the head '%x'", b.Header.Parent, s.latestBlockHash)
 	}
+	pendingState := s.copy()
+	s.lock.RUnlock()
 
-	fmt.Printf("\nPersisting new block to disk:\n")
-	fmt.Printf("\t%s\n", blockFsJSON)
+	invalidTXs := make(map[Hash]struct{})
+	for _, tx := range orderBlockTXs(s.genesis, b) {
+		txHash, err := tx.Hash()
+		if err != nil {
+			return nil, nil, err
+		}
 
-	_, err = s.dbFile.Write(append(blockFsJSON, '\n'))
-	if err != nil {
-		return Hash{}, err
+		// applyTx only changes the state once the TX is valid
+		err = applyTx(tx, pendingState)
+		if err != nil {
+			invalidTXs[txHash] = struct{}{}
+		}
 	}
 
-	s.Balances = pendingState.Balances
-	s.Account2Nonce = pendingState.Account2Nonce
-	s.latestBlockHash = blockHash
-	s.latestBlock = b
-	s.hasGenesisBlock = true
+	valid := make([]SignedTx, 0, len(b.TXs))
+	invalid := make([]SignedTx, 0, len(invalidTXs))
+	for _, tx := range b.TXs {
+		txHash, _ := tx.Hash()
+		if _, isInvalid := invalidTXs[txHash]; isInvalid {
+			invalid = append(invalid, tx)
+		} else {
+			valid = append(valid, tx)
+		}
+	}
 
-	return blockHash, nil
+	return valid, invalid, nil
 }
 
-func (s *State) GetNextAccountNonce(account common.Address) uint {
-	return s.Account2Nonce[account] + 1
+func (s *State) GetBalance(account common.Address) uint {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.Balances[account]
+}
+
+// LatestBalances returns a copy of all balances along with the block they are at.
+func (s *State) LatestBalances() (Hash, map[common.Address]uint) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	balances := make(map[common.Address]uint, len(s.Balances))
+	for acc, balance := range s.Balances {
+		balances[acc] = balance
+	}
+
+	return s.latestBlockHash, balances
 }
 
 func (s *State) NextBlockNumber() uint64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
 	if !s.hasGenesisBlock {
 		return uint64(0)
 	}
 
-	return s.LatestBlock().Header.Number + 1
+	return s.latestBlock.Header.Number + 1
 }
 
 func (s *State) apply(tx Tx) error {
@@ -165,24 +277,58 @@ func (s *State) apply(tx Tx) error {
 }
 
 func (s *State) LatestBlock() Block {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
 	return s.latestBlock
 }
 
 func (s *State) LatestBlockHash() Hash {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
 	return s.latestBlockHash
 }
 
+// Genesis is the chain configuration the State validates blocks against, it never
+// changes once the State is loaded.
+func (s *State) Genesis() Genesis {
+	return s.genesis
+}
+
+func (s *State) GenesisHash() Hash {
+	return s.genesisHash
+}
+
 func (s *State) Close() error {
-	return s.dbFile.Close()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	err := s.store.Close()
+
+	// Only once the store is closed can another process open the data dir
+	if s.dataDirLock != nil {
+		lockErr := s.dataDirLock.release()
+		s.dataDirLock = nil
+		if err == nil {
+			err = lockErr
+		}
+	}
+
+	return err
 }
 
-func (s *State) copy() State {
-	c := State{}
+func (s *State) copy() *State {
+	c := &State{}
+	c.genesis = s.genesis
+	c.genesisHash = s.genesisHash
 	c.hasGenesisBlock = s.hasGenesisBlock
 	c.latestBlock = s.latestBlock
 	c.latestBlockHash = s.latestBlockHash
 	c.Balances = make(map[common.Address]uint)
 	c.Account2Nonce = make(map[common.Address]uint)
+	c.accounts = s.accounts
+	c.engine = s.engine
 
 	for acc, balance := range s.Balances {
 		c.Balances[acc] = balance
@@ -195,6 +341,8 @@ func (s *State) copy() State {
 	return c
 }
 
+// applyBlock applies b on top of the head of s. The consensus engine verified
+// its header beforehand, see ImportBlock.
 func applyBlock(b Block, s *State) error {
 	nextExpectedNumber := s.latestBlock.Header.Number + 1
 
@@ -202,34 +350,50 @@ func applyBlock(b Block, s *State) error {
 		return fmt.Errorf("next expected block was '%d' not '%d'", nextExpectedNumber, b.Header.Number)
 	}
 
-	if s.hasGenesisBlock && s.latestBlock.Header.Number > 0 && !reflect.DeepEqual(b.Header.Parent, s.latestBlockHash) {
+	if s.hasGenesisBlock && b.Header.Parent != s.latestBlockHash {
 		return fmt.Errorf("next block parent hash must be '%x' not '%x'", s.latestBlockHash, b.Header.Parent)
 	}
 
-	hash, err := b.Hash()
+	blockJSON, err := json.Marshal(b)
 	if err != nil {
 		return err
 	}
 
-	if !IsBlockHashValid(hash) {
-		return fmt.Errorf("invalid block ash %x", hash)
+	if uint64(len(blockJSON)) > s.genesis.MaxBlockSize {
+		return fmt.Errorf("block size %d bytes exceeds the %d bytes limit", len(blockJSON), s.genesis.MaxBlockSize)
+	}
+
+	err = verifyTxRoot(b)
+	if err != nil {
+		return err
 	}
 
-	err = applyTXs(b.TXs, s)
+	err = applyBlockTXs(b, s)
 	if err != nil {
 		return err
 	}
 
-	s.Balances[b.Header.Miner] += BlockReward
+	if stateRoot := s.stateRoot(); stateRoot != b.Header.StateRoot {
+		return fmt.Errorf("block state root must be '%x' not '%x'", stateRoot, b.Header.StateRoot)
+	}
 
 	return nil
 }
 
-func applyTXs(txs []SignedTx, s *State) error {
-	sort.Slice(txs, func(i, j int) bool {
-		return txs[i].Time < txs[j].Time
-	})
+// applyBlockTXs applies the block TXs and its rewards, without checking the header.
+func applyBlockTXs(b Block, s *State) error {
+	err := applyTXs(orderBlockTXs(s.genesis, b), s)
+	if err != nil {
+		return err
+	}
+
+	s.engine.Finalize(s.genesis, b, s.Balances)
+	s.updateAccounts(blockAccounts(b))
+
+	return nil
+}
 
+func applyTXs(txs []SignedTx, s *State) error {
 	for _, tx := range txs {
 		err := applyTx(tx, s)
 		if err != nil {
@@ -240,6 +404,23 @@ func applyTXs(txs []SignedTx, s *State) error {
 	return nil
 }
 
+// orderBlockTXs returns the TXs of b in the order they're applied, see ForkBlockTXOrder.
+func orderBlockTXs(g Genesis, b Block) []SignedTx {
+	if g.IsForkActive(ForkBlockTXOrder, b.Header.Number) {
+		return b.TXs
+	}
+
+	// Sort a copy, re-ordering the block's own TXs would change its hash
+	txs := make([]SignedTx, len(b.TXs))
+	copy(txs, b.TXs)
+
+	sort.Slice(txs, func(i, j int) bool {
+		return txs[i].Time < txs[j].Time
+	})
+
+	return txs
+}
+
 func applyTx(tx SignedTx, s *State) error {
 	ok, err := tx.IsAuthentic()
 	if err != nil {
@@ -252,7 +433,7 @@ func applyTx(tx SignedTx, s *State) error {
 		)
 	}
 
-	expectedNonce := s.GetNextAccountNonce(tx.From)
+	expectedNonce := s.getNextAccountNonce(tx.From)
 	if tx.Nonce != expectedNonce {
 		return fmt.Errorf(
 			"wrong TX. Sender '%s' next nonce must be '%d' not '%d'",
@@ -262,11 +443,16 @@ func applyTx(tx SignedTx, s *State) error {
 		)
 	}
 
-	if tx.Value > s.Balances[tx.From] {
-		return fmt.Errorf("wrong TX. Sender '%s' balance is %d TBB. Tx cost is %d TBB", tx.From, s.Balances[tx.From], tx.Value)
+	if tx.Cost() < tx.Value {
+		return fmt.Errorf("wrong TX. Sender '%s' value and fee overflow", tx.From.String())
 	}
 
-	s.Balances[tx.From] -= tx.Value
+	if tx.Cost() > s.Balances[tx.From] {
+		return fmt.Errorf("wrong TX. Sender '%s' balance is %d TBB. Tx cost is %d TBB", tx.From, s.Balances[tx.From], tx.Cost())
+	}
+
+	// The fee is credited to the miner once the whole block applied
+	s.Balances[tx.From] -= tx.Cost()
 	s.Balances[tx.To] += tx.Value
 	s.Account2Nonce[tx.From] = tx.Nonce
 
@@ -0,0 +1,851 @@
+package database
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Low enough for the tests to mine a block in a few hashes
+const testDifficulty = 16
+
+var testMinerA = NewAccount("0x000000000000000000000000000000000000000a")
+var testMinerB = NewAccount("0x000000000000000000000000000000000000000b")
+var testReceiver = NewAccount("0x0000000000000000000000000000000000000001")
+
+func TestState_ReorgToHeavierBranch(t *testing.T) {
+	dataDir, s, sender, senderKey := setupTestState(t, Genesis{Difficulty: testDifficulty})
+	defer os.RemoveAll(dataDir)
+	defer s.Close()
+
+	genesisHash := addTestBlock(t, s, mineTestBlock(t, s, Hash{}, 0, 1, testMinerA, signTestTx(t, senderKey, sender, 10, 1)))
+
+	// Two miners find block 1 at the same time, the first one seen stays canonical
+	a1 := mineTestBlock(t, s, genesisHash, 1, 2, testMinerA, signTestTx(t, senderKey, sender, 20, 2))
+	a1Hash := addTestBlock(t, s, a1)
+
+	b1 := mineTestBlock(t, s, genesisHash, 1, 2, testMinerB, signTestTx(t, senderKey, sender, 30, 2))
+	b1Hash, reorg, err := s.ImportBlock(b1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reorg != nil || s.LatestBlockHash() != a1Hash {
+		t.Fatal("a competing block with equal work should not replace the head")
+	}
+
+	// Extending the competing branch gives it more work than the canonical one
+	b2 := mineTestBlock(t, s, b1Hash, 2, 3, testMinerB)
+	_, reorg, err = s.ImportBlock(b2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reorg == nil || reorg.Depth != 1 {
+		t.Fatalf("expected a reorg of depth 1, got %+v", reorg)
+	}
+
+	if s.Balances[testReceiver] != 40 {
+		t.Fatalf("receiver balance should be 40 not %d", s.Balances[testReceiver])
+	}
+	if s.Balances[testMinerA] != DefaultBlockReward || s.Balances[testMinerB] != 2*DefaultBlockReward {
+		t.Fatal("block rewards of the disconnected block were not rolled back")
+	}
+	if s.Account2Nonce[sender] != 2 {
+		t.Fatalf("sender nonce should be 2 not %d", s.Account2Nonce[sender])
+	}
+
+	blocks, err := s.GetBlocksAfter(a1Hash, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || blocks[0].Header.Miner != testMinerB {
+		t.Fatal("syncing from a stale branch should return the canonical blocks after the fork point")
+	}
+
+	// Paged, the last block returned is the cursor to the next page
+	blocks, err = s.GetBlocksAfter(a1Hash, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 || blocks[0].Header.Number != 1 {
+		t.Fatal("syncing should return no more blocks than the limit")
+	}
+
+	blocks, err = s.GetBlocksAfter(b1Hash, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 || blocks[0].Header.Number != 2 {
+		t.Fatal("syncing from the last block of a page should return the next page")
+	}
+}
+
+func TestState_RejectsInvalidBranch(t *testing.T) {
+	dataDir, s, sender, senderKey := setupTestState(t, Genesis{Difficulty: testDifficulty})
+	defer os.RemoveAll(dataDir)
+
+	genesisHash := addTestBlock(t, s, mineTestBlock(t, s, Hash{}, 0, 1, testMinerA, signTestTx(t, senderKey, sender, 10, 1)))
+	a1Hash := addTestBlock(t, s, mineTestBlock(t, s, genesisHash, 1, 2, testMinerA))
+
+	// The competing branch replays an already used nonce
+	b1Hash := addTestBlock(t, s, mineTestBlock(t, s, genesisHash, 1, 2, testMinerB, signTestTx(t, senderKey, sender, 10, 1)))
+	_, _, err := s.ImportBlock(mineTestBlock(t, s, b1Hash, 2, 3, testMinerB))
+	if !errors.Is(err, ErrBadBranch) || errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("the valid block ending an invalid branch should fail with ErrBadBranch only, got: %v", err)
+	}
+
+	if s.LatestBlockHash() != a1Hash || s.Balances[testReceiver] != 10 {
+		t.Fatal("a failed reorg should leave the canonical chain untouched")
+	}
+
+	// This time the new head itself replays the nonce
+	c1Hash := addTestBlock(t, s, mineTestBlock(t, s, genesisHash, 1, 3, testMinerB))
+	_, _, err = s.ImportBlock(mineTestBlock(t, s, c1Hash, 2, 4, testMinerB, signTestTx(t, senderKey, sender, 10, 1)))
+	if !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("an invalid new head should fail with ErrInvalidBlock, got: %v", err)
+	}
+
+	if s.LatestBlockHash() != a1Hash {
+		t.Fatal("a failed reorg should leave the canonical chain untouched")
+	}
+
+	s.Close()
+
+	reloaded, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloaded.Close()
+
+	if reloaded.LatestBlockHash() != a1Hash {
+		t.Fatal("the invalid branch should not become canonical after a restart")
+	}
+}
+
+func TestState_RemembersInvalidHeadBlock(t *testing.T) {
+	dataDir, s, sender, senderKey := setupTestState(t, Genesis{Difficulty: testDifficulty})
+	defer os.RemoveAll(dataDir)
+	defer s.Close()
+
+	genesisHash := addTestBlock(t, s, mineTestBlock(t, s, Hash{}, 0, 1, testMinerA, signTestTx(t, senderKey, sender, 10, 1)))
+
+	// Extends the head but replays an already used nonce
+	bad := mineTestBlock(t, s, genesisHash, 1, 2, testMinerA, signTestTx(t, senderKey, sender, 10, 1))
+	badHash, err := bad.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = s.ImportBlock(bad)
+	if !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("a block failing to apply on the head should be invalid, got: %v", err)
+	}
+
+	_, _, err = s.ImportBlock(bad)
+	if err == nil || !strings.Contains(err.Error(), "known to be invalid") {
+		t.Fatalf("the block should be remembered as invalid, got: %v", err)
+	}
+
+	_, _, err = s.ImportBlock(mineTestNonce(NewBlock(badHash, 2, 0, 3, testMinerA, bad.Header.Difficulty, nil)))
+	if err == nil || !strings.Contains(err.Error(), "is invalid") {
+		t.Fatalf("a child of the invalid block should be refused, got: %v", err)
+	}
+}
+
+func TestState_RebuildsBlockIndex(t *testing.T) {
+	dataDir, s, sender, senderKey := setupTestState(t, Genesis{Difficulty: testDifficulty})
+	defer os.RemoveAll(dataDir)
+
+	hash := addTestBlock(t, s, mineTestBlock(t, s, Hash{}, 0, 1, testMinerA, signTestTx(t, senderKey, sender, 10, 1)))
+	hash = addTestBlock(t, s, mineTestBlock(t, s, hash, 1, 2, testMinerA))
+	hash = addTestBlock(t, s, mineTestBlock(t, s, hash, 2, 3, testMinerB))
+	s.Close()
+
+	// Lose the last index record, as if the node crashed between the two writes
+	indexPath := getBlockIndexFilePath(dataDir)
+	info, err := os.Stat(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Truncate(indexPath, info.Size()-blockIndexRecordSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.LatestBlockHash() != hash {
+		t.Fatal("blocks missing from the index should be indexed on startup")
+	}
+	s.Close()
+
+	err = os.Remove(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if s.LatestBlockHash() != hash || s.Balances[testReceiver] != 10 {
+		t.Fatal("a missing index should be rebuilt from the blocks DB")
+	}
+
+	b, err := s.GetBlockByNumber(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Header.Miner != testMinerB {
+		t.Fatal("block looked up by number doesn't match the mined one")
+	}
+}
+
+func TestState_DifficultyRetarget(t *testing.T) {
+	genesis := Genesis{
+		Difficulty:                   testDifficulty,
+		TargetBlockTime:              10,
+		DifficultyAdjustmentInterval: 3,
+	}
+	dataDir, s, _, _ := setupTestState(t, genesis)
+	defer os.RemoveAll(dataDir)
+	defer s.Close()
+
+	// Mine the first interval twice as fast as the 10s target
+	hash := addTestBlock(t, s, mineTestBlock(t, s, Hash{}, 0, 100, testMinerA))
+	hash = addTestBlock(t, s, mineTestBlock(t, s, hash, 1, 105, testMinerA))
+	hash = addTestBlock(t, s, mineTestBlock(t, s, hash, 2, 110, testMinerA))
+
+	if s.NextBlockDifficulty() != 2*testDifficulty {
+		t.Fatalf("difficulty should double to %d, not %d", 2*testDifficulty, s.NextBlockDifficulty())
+	}
+
+	tooEasy := NewBlock(hash, 3, 0, 111, testMinerA, testDifficulty, nil)
+	_, err := s.AddBlock(mineTestNonce(tooEasy))
+	if err == nil {
+		t.Fatal("a block ignoring the retarget should be rejected")
+	}
+
+	hash = addTestBlock(t, s, mineTestBlock(t, s, hash, 3, 111, testMinerA))
+	hash = addTestBlock(t, s, mineTestBlock(t, s, hash, 4, 200, testMinerA))
+
+	// The second interval is way too slow, the difficulty can only drop 4 times at most
+	hash = addTestBlock(t, s, mineTestBlock(t, s, hash, 5, 400, testMinerA))
+	if s.NextBlockDifficulty() != testDifficulty/2 {
+		t.Fatalf("difficulty should drop to %d, not %d", testDifficulty/2, s.NextBlockDifficulty())
+	}
+}
+
+func TestState_TxIndexFollowsReorgs(t *testing.T) {
+	dataDir, s, sender, senderKey := setupTestState(t, Genesis{Difficulty: testDifficulty})
+	defer os.RemoveAll(dataDir)
+
+	tx1 := signTestTx(t, senderKey, sender, 10, 1)
+	tx2 := signTestTx(t, senderKey, sender, 20, 2)
+	tx3 := signTestTx(t, senderKey, sender, 30, 2)
+
+	genesisHash := addTestBlock(t, s, mineTestBlock(t, s, Hash{}, 0, 1, testMinerA, tx1))
+	addTestBlock(t, s, mineTestBlock(t, s, genesisHash, 1, 2, testMinerA, tx2))
+
+	tx2Hash, _ := tx2.Hash()
+	if _, err := s.GetTx(tx2Hash); err != nil {
+		t.Fatal(err)
+	}
+
+	b1Hash := addTestBlock(t, s, mineTestBlock(t, s, genesisHash, 1, 2, testMinerB, tx3))
+	addTestBlock(t, s, mineTestBlock(t, s, b1Hash, 2, 3, testMinerB))
+
+	_, err := s.GetTx(tx2Hash)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatal("the reorg should have removed the TX of the disconnected block")
+	}
+	s.Close()
+
+	// The index is rebuilt on startup and must match the chain as it was left
+	s, err = NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	_, err = s.GetTx(tx2Hash)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatal("a TX of a disconnected block should no longer be found")
+	}
+
+	tx3Hash, _ := tx3.Hash()
+	tx, err := s.GetTx(tx3Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.BlockHash != b1Hash || tx.BlockNumber != 1 || tx.TX.Value != 30 {
+		t.Fatalf("TX indexed at the wrong location %+v", tx)
+	}
+
+	for _, acc := range []common.Address{sender, testReceiver} {
+		history, err := s.GetAccountTXs(acc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != 2 || history[0].TX.Value != 10 || history[1].Hash != tx3Hash {
+			t.Fatalf("account '%s' history should hold the 2 canonical TXs, got %+v", acc.Hex(), history)
+		}
+	}
+
+	blocks, err := s.GetBlocks(1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || blocks[0].Header.Miner != testMinerB {
+		t.Fatal("expected the 2 canonical blocks after the genesis block")
+	}
+}
+
+func TestState_TxFeesGoToMiner(t *testing.T) {
+	dataDir, s, sender, senderKey := setupTestState(t, Genesis{Difficulty: testDifficulty})
+	defer os.RemoveAll(dataDir)
+	defer s.Close()
+
+	tx1 := signTestTxWithFee(t, senderKey, sender, 10, 5, 1)
+	tx2 := signTestTxWithFee(t, senderKey, sender, 20, 7, 2)
+	addTestBlock(t, s, mineTestBlock(t, s, Hash{}, 0, 1, testMinerA, tx1, tx2))
+
+	if s.GetBalance(sender) != 1000-10-5-20-7 {
+		t.Fatalf("sender should pay the values and fees, balance is %d", s.GetBalance(sender))
+	}
+	if s.GetBalance(testReceiver) != 30 {
+		t.Fatalf("receiver should only get the values, balance is %d", s.GetBalance(testReceiver))
+	}
+	if s.GetBalance(testMinerA) != DefaultBlockReward+12 {
+		t.Fatalf("miner should get the block reward and the fees, balance is %d", s.GetBalance(testMinerA))
+	}
+
+	err := s.ValidatePendingTX(signTestTxWithFee(t, senderKey, sender, s.GetBalance(sender), 1, 3), nil)
+	if err == nil {
+		t.Fatal("a TX whose value and fee exceed the balance should be refused")
+	}
+}
+
+func TestState_RejectsBlockWithForeignTXs(t *testing.T) {
+	dataDir, s, sender, senderKey := setupTestState(t, Genesis{Difficulty: testDifficulty})
+	defer os.RemoveAll(dataDir)
+	defer s.Close()
+
+	b := mineTestBlock(t, s, Hash{}, 0, 1, testMinerA, signTestTx(t, senderKey, sender, 10, 1))
+
+	// Same header and PoW, different payload
+	b.TXs = []SignedTx{signTestTx(t, senderKey, sender, 999, 1)}
+
+	_, err := s.AddBlock(b)
+	if err == nil {
+		t.Fatal("a block whose TXs don't match its TX root should be rejected")
+	}
+}
+
+func TestState_ValidatePendingTX(t *testing.T) {
+	dataDir, s, sender, senderKey := setupTestState(t, Genesis{Difficulty: testDifficulty})
+	defer os.RemoveAll(dataDir)
+	defer s.Close()
+
+	pendingTXs := []SignedTx{signTestTx(t, senderKey, sender, 600, 1)}
+
+	err := s.ValidatePendingTX(signTestTx(t, senderKey, sender, 300, 2), pendingTXs)
+	if err != nil {
+		t.Fatalf("a TX following the sender's pending ones should be valid. %s", err)
+	}
+
+	err = s.ValidatePendingTX(signTestTx(t, senderKey, sender, 300, 1), pendingTXs)
+	if err == nil {
+		t.Fatal("a TX reusing a pending nonce should be refused")
+	}
+
+	err = s.ValidatePendingTX(signTestTx(t, senderKey, sender, 500, 2), pendingTXs)
+	if err == nil {
+		t.Fatal("a TX spending more than what the pending TXs leave should be refused")
+	}
+
+	otherKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.ValidatePendingTX(signTestTx(t, otherKey, sender, 300, 2), pendingTXs)
+	if err == nil {
+		t.Fatal("a TX signed by another account should be refused")
+	}
+}
+
+func TestState_ForkBlockTXOrder(t *testing.T) {
+	genesis := Genesis{Difficulty: testDifficulty, Forks: map[string]uint64{ForkBlockTXOrder: 1}}
+	dataDir, s, sender, senderKey := setupTestState(t, genesis)
+	defer os.RemoveAll(dataDir)
+	defer s.Close()
+
+	// Listed in nonce order, signed the other way around
+	outOfTimeOrder := func(nonce uint) []SignedTx {
+		return []SignedTx{
+			signTestTxAt(t, senderKey, sender, 10, nonce, 1000),
+			signTestTxAt(t, senderKey, sender, 10, nonce+1, 999),
+		}
+	}
+
+	// Before the fork, TXs apply by time and the block is invalid
+	b := NewBlock(Hash{}, 0, 0, 1, testMinerA, testDifficulty, outOfTimeOrder(1))
+	valid, invalid, err := s.FilterBlockTXs(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(valid) != 1 || len(invalid) != 1 || invalid[0].Nonce != 2 {
+		t.Fatalf("only the first nonce should apply before the fork, got %d valid TXs", len(valid))
+	}
+
+	_, err = s.AddBlock(mineTestNonce(b))
+	if err == nil || !strings.Contains(err.Error(), "next nonce must be") {
+		t.Fatalf("a block applying its TXs out of nonce order should be rejected before the fork, got %v", err)
+	}
+
+	genesisHash := addTestBlock(t, s, mineTestBlock(t, s, Hash{}, 0, 1, testMinerA, valid...))
+
+	// From the fork on, they apply in the block order
+	b = NewBlock(genesisHash, 1, 0, 2, testMinerA, testDifficulty, outOfTimeOrder(2))
+	valid, invalid, err = s.FilterBlockTXs(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(valid) != 2 || len(invalid) != 0 {
+		t.Fatalf("both TXs should apply from the fork on, got %d invalid TXs", len(invalid))
+	}
+
+	addTestBlock(t, s, mineTestBlock(t, s, genesisHash, 1, 2, testMinerA, valid...))
+	if s.GetNextAccountNonce(sender) != 4 {
+		t.Fatalf("the 3 TXs should be mined, the sender's next nonce is %d", s.GetNextAccountNonce(sender))
+	}
+}
+
+func setupTestState(t *testing.T, genesis Genesis) (string, *State, common.Address, *ecdsa.PrivateKey) {
+	senderKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := crypto.PubkeyToAddress(senderKey.PublicKey)
+
+	genesis.Balances = map[common.Address]uint{sender: 1000}
+	genesisJson, err := json.Marshal(genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataDir, err := ioutil.TempDir(os.TempDir(), "tbb_database_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = InitDataDirIfNotExists(dataDir, genesisJson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dataDir, s, sender, senderKey
+}
+
+func signTestTx(t *testing.T, key *ecdsa.PrivateKey, from common.Address, value, nonce uint) SignedTx {
+	return signTestTxWithFee(t, key, from, value, 0, nonce)
+}
+
+// signTestTxAt signs a TX made at the given unix time.
+func signTestTxAt(t *testing.T, key *ecdsa.PrivateKey, from common.Address, value, nonce uint, time uint64) SignedTx {
+	tx := NewTx(from, testReceiver, value, nonce, "")
+	tx.Time = time
+
+	return signTestTxWithKey(t, key, tx)
+}
+
+func signTestTxWithFee(t *testing.T, key *ecdsa.PrivateKey, from common.Address, value, fee, nonce uint) SignedTx {
+	return signTestTxWithKey(t, key, NewTxWithFee(from, testReceiver, value, nonce, "", fee))
+}
+
+func signTestTxWithKey(t *testing.T, key *ecdsa.PrivateKey, tx Tx) SignedTx {
+	rawTx, err := tx.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txHash := sha256.Sum256(rawTx)
+	sig, err := crypto.Sign(txHash[:], key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewSignedTx(tx, sig)
+}
+
+// mineTestBlock mines a block at the difficulty the State expects on top of parent.
+func mineTestBlock(t *testing.T, s *State, parent Hash, number, time uint64, miner common.Address, txs ...SignedTx) Block {
+	difficulty, err := ProofOfWork{}.CalcDifficulty(s, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewBlock(parent, number, 0, time, miner, difficulty, txs)
+	b.Header.StateRoot = testStateRootAfter(t, s, b)
+
+	return mineTestNonce(b)
+}
+
+// testStateRootAfter replays the branch of b from genesis, b may extend any known block.
+// Invalid branches get the empty root, the State rejects their blocks anyway.
+func testStateRootAfter(t *testing.T, s *State, b Block) Hash {
+	branch := []Block{b}
+	for parent := b.Header.Parent; !parent.IsEmpty(); {
+		block, err := s.GetBlockByHash(parent)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		branch = append([]Block{block}, branch...)
+		parent = block.Header.Parent
+	}
+
+	pendingState := &State{
+		Balances:      make(map[common.Address]uint),
+		Account2Nonce: make(map[common.Address]uint),
+		genesis:       s.genesis,
+		engine:        s.engine,
+	}
+	for account, balance := range s.genesis.Balances {
+		pendingState.Balances[account] = balance
+		pendingState.updateAccounts([]common.Address{account})
+	}
+
+	for _, block := range branch {
+		err := applyBlockTXs(block, pendingState)
+		if err != nil {
+			return Hash{}
+		}
+	}
+
+	return pendingState.stateRoot()
+}
+
+func mineTestNonce(b Block) Block {
+	for ; ; b.Header.Nonce++ {
+		hash, _ := b.Hash()
+		if IsBlockHashValid(hash, b.Header.Difficulty) {
+			return b
+		}
+	}
+}
+
+func addTestBlock(t *testing.T, s *State, b Block) Hash {
+	hash, err := s.AddBlock(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hash
+}
+
+func TestState_StateRoot(t *testing.T) {
+	dataDir, s, sender, senderKey := setupTestState(t, Genesis{Difficulty: testDifficulty})
+	defer os.RemoveAll(dataDir)
+	defer s.Close()
+
+	genesisHash := addTestBlock(t, s, mineTestBlock(t, s, Hash{}, 0, 1, testMinerA, signTestTx(t, senderKey, sender, 10, 1)))
+
+	b := NewBlock(genesisHash, 1, 0, 2, testMinerA, testDifficulty, []SignedTx{signTestTx(t, senderKey, sender, 20, 2)})
+	stateRoot, err := s.NextStateRoot(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrongRoot := b
+	wrongRoot.Header.StateRoot = genesisHash
+	_, err = s.AddBlock(mineTestNonce(wrongRoot))
+	if err == nil {
+		t.Fatal("a block with the wrong state root should be rejected")
+	}
+
+	b.Header.StateRoot = stateRoot
+	b1Hash := addTestBlock(t, s, mineTestNonce(b))
+
+	for _, blockHash := range []Hash{genesisHash, b1Hash} {
+		proof, header, err := s.GetAccountProof(sender, blockHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !VerifyAccountProof(proof, header.StateRoot) {
+			t.Fatalf("account proof at block '%x' doesn't verify", blockHash)
+		}
+	}
+
+	proof, header, err := s.GetAccountProof(sender, genesisHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proof.Balance != 990 || proof.Nonce != 1 {
+		t.Fatalf("sender had 990 TBB at nonce 1 in the genesis block, the proof says %d at %d", proof.Balance, proof.Nonce)
+	}
+
+	// Reorg away from b1, its state goes with it
+	a1Hash := addTestBlock(t, s, mineTestBlock(t, s, genesisHash, 1, 2, testMinerB))
+	addTestBlock(t, s, mineTestBlock(t, s, a1Hash, 2, 3, testMinerB))
+
+	_, _, err = s.GetAccountProof(sender, b1Hash)
+	if err == nil {
+		t.Fatal("the state of a block that left the canonical chain shouldn't be provable")
+	}
+
+	proof, header, err = s.GetAccountProof(testMinerB, Hash{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyAccountProof(proof, header.StateRoot) || proof.Balance != 2*s.genesis.BlockReward {
+		t.Fatalf("miner B should be proven to hold %d TBB, not %d", 2*s.genesis.BlockReward, proof.Balance)
+	}
+}
+
+func TestState_LoadsNewestSnapshot(t *testing.T) {
+	dataDir, s, sender, senderKey := setupTestState(t, Genesis{Difficulty: testDifficulty})
+	defer os.RemoveAll(dataDir)
+
+	tx1 := signTestTx(t, senderKey, sender, 10, 1)
+	genesisHash := addTestBlock(t, s, mineTestBlock(t, s, Hash{}, 0, 1, testMinerA, tx1))
+	hash := addTestBlock(t, s, mineTestBlock(t, s, genesisHash, 1, 2, testMinerA, signTestTx(t, senderKey, sender, 20, 2)))
+
+	_, err := s.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash = addTestBlock(t, s, mineTestBlock(t, s, hash, 2, 3, testMinerB, signTestTx(t, senderKey, sender, 30, 3)))
+	_, balances := s.LatestBalances()
+	s.Close()
+
+	s, err = NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.LatestBlockHash() != hash || s.GetNextAccountNonce(sender) != 4 {
+		t.Fatal("the blocks after the snapshot should be replayed")
+	}
+	for account, balance := range balances {
+		if s.GetBalance(account) != balance {
+			t.Fatalf("account %s balance should be %d TBB, not %d", account.Hex(), balance, s.GetBalance(account))
+		}
+	}
+
+	if s.blocks[genesisHash].undo != nil || s.blocks[hash].undo == nil {
+		t.Fatal("only the blocks after the snapshot should be replayed")
+	}
+
+	tx1Hash, _ := tx1.Hash()
+	_, err = s.GetTx(tx1Hash)
+	if err != nil {
+		t.Fatal("TXs before the snapshot should still be indexed")
+	}
+
+	// A reorg below the snapshot can't roll the state back
+	forkHash := addTestBlock(t, s, mineTestBlock(t, s, genesisHash, 1, 2, testMinerB))
+	forkHash = addTestBlock(t, s, mineTestBlock(t, s, forkHash, 2, 3, testMinerB))
+	_, _, err = s.ImportBlock(mineTestBlock(t, s, forkHash, 3, 4, testMinerB))
+	if err == nil || s.LatestBlockHash() != hash {
+		t.Fatal("a branch forking before the snapshot should be refused")
+	}
+	s.Close()
+
+	// Tamper the snapshot balances, its state root gives it away
+	snapPath := getSnapshotFilePath(dataDir, 1)
+	content, err := ioutil.ReadFile(snapPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snap snapshot
+	err = json.Unmarshal(content, &snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap.Balances[testMinerB] = 1000000
+	content, err = json.Marshal(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(snapPath, content, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if s.blocks[genesisHash].undo == nil || s.GetBalance(testMinerB) != balances[testMinerB] {
+		t.Fatal("an invalid snapshot should be ignored and every block replayed")
+	}
+}
+
+func TestState_TruncatesTornRecord(t *testing.T) {
+	dataDir, s, sender, senderKey := setupTestState(t, Genesis{Difficulty: testDifficulty})
+	defer os.RemoveAll(dataDir)
+
+	hash := addTestBlock(t, s, mineTestBlock(t, s, Hash{}, 0, 1, testMinerA, signTestTx(t, senderKey, sender, 10, 1)))
+	hash = addTestBlock(t, s, mineTestBlock(t, s, hash, 1, 2, testMinerA))
+	s.Close()
+
+	// A crash cut the write of the next block and of its index record short
+	appendTestBytes(t, getBlocksDBFilePath(dataDir), []byte(`{"hash":"00000a","block":{"header":`))
+	appendTestBytes(t, getBlockIndexFilePath(dataDir), make([]byte, blockIndexRecordSize/2))
+
+	s, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.LatestBlockHash() != hash {
+		t.Fatal("the blocks before the torn record should load")
+	}
+
+	hash = addTestBlock(t, s, mineTestBlock(t, s, hash, 2, 3, testMinerB))
+	s.Close()
+
+	report, err := VerifyBlocksDB(dataDir, BlockStoreFile, ProofOfWork{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Err != nil || report.Blocks != 3 {
+		t.Fatalf("the 3 blocks should be valid once the torn record is gone, %+v", report)
+	}
+}
+
+func TestRepairBlocksDB(t *testing.T) {
+	dataDir, s, sender, senderKey := setupTestState(t, Genesis{Difficulty: testDifficulty})
+	defer os.RemoveAll(dataDir)
+
+	genesisHash := addTestBlock(t, s, mineTestBlock(t, s, Hash{}, 0, 1, testMinerA, signTestTx(t, senderKey, sender, 10, 1)))
+	hash := addTestBlock(t, s, mineTestBlock(t, s, genesisHash, 1, 2, testMinerA, signTestTx(t, senderKey, sender, 20, 2)))
+	addTestBlock(t, s, mineTestBlock(t, s, hash, 2, 3, testMinerA))
+	s.Close()
+
+	// Flip the value of the TX in block 1, the record no longer matches its hash
+	dbPath := getBlocksDBFilePath(dataDir)
+	content, err := ioutil.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content = bytes.Replace(content, []byte(`"value":20`), []byte(`"value":90`), 1)
+	err = ioutil.WriteFile(dbPath, content, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := VerifyBlocksDB(dataDir, BlockStoreFile, ProofOfWork{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Err == nil || report.Blocks != 1 || report.BadHash != hash || report.BadNumber != 1 {
+		t.Fatalf("block 1 should be reported as the first bad one, %+v", report)
+	}
+
+	_, err = RepairBlocksDB(dataDir, BlockStoreFile, ProofOfWork{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if s.LatestBlockHash() != genesisHash || s.GetBalance(testReceiver) != 10 {
+		t.Fatal("the repaired DB should keep the blocks before the bad one only")
+	}
+}
+
+func TestVerifyBlocksDB_ChecksSideBranches(t *testing.T) {
+	dataDir, s, sender, senderKey := setupTestState(t, Genesis{Difficulty: testDifficulty})
+	defer os.RemoveAll(dataDir)
+
+	genesisHash := addTestBlock(t, s, mineTestBlock(t, s, Hash{}, 0, 1, testMinerA, signTestTx(t, senderKey, sender, 10, 1)))
+	addTestBlock(t, s, mineTestBlock(t, s, genesisHash, 1, 2, testMinerA, signTestTx(t, senderKey, sender, 20, 2)))
+
+	// Not heavier than the canonical block, the side block is stored with its header checked only
+	overspending := mineTestBlock(t, s, genesisHash, 1, 3, testMinerB, signTestTx(t, senderKey, sender, 5000, 2))
+	sideHash := addTestBlock(t, s, overspending)
+	s.Close()
+
+	report, err := VerifyBlocksDB(dataDir, BlockStoreFile, ProofOfWork{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Err == nil || report.Blocks != 2 || report.BadHash != sideHash {
+		t.Fatalf("the side block overspending should be reported, %+v", report)
+	}
+
+	_, err = VerifyBlocksDB(dataDir, BlockStoreLevelDB, ProofOfWork{})
+	if err == nil {
+		t.Fatal("a node storing its blocks in LevelDB has no block.db to verify")
+	}
+}
+
+func appendTestBytes(t *testing.T, path string, content []byte) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestState_LocksDataDir(t *testing.T) {
+	dataDir, s, _, _ := setupTestState(t, Genesis{Difficulty: testDifficulty})
+	defer os.RemoveAll(dataDir)
+
+	_, err := NewStateFromDisk(dataDir)
+	if !errors.Is(err, ErrDataDirLocked) {
+		t.Fatalf("a second State shouldn't open a data dir in use, got %v", err)
+	}
+	if !strings.Contains(err.Error(), fmt.Sprintf("process %d", os.Getpid())) {
+		t.Fatalf("the error should name the process holding the lock, got '%s'", err)
+	}
+
+	_, err = VerifyBlocksDB(dataDir, BlockStoreFile, ProofOfWork{})
+	if !errors.Is(err, ErrDataDirLocked) {
+		t.Fatal("the DB shouldn't be verified while a State has it open")
+	}
+
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatalf("the lock should be released once the State is closed, got %v", err)
+	}
+	s.Close()
+}
@@ -0,0 +1,310 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// The state root is the root of a sparse Merkle tree holding every account,
+// keyed by the bits of its address. Accounts with no balance and no nonce are
+// left out, so the same balances and nonces always give the same root.
+//
+// The tree is immutable: an update returns a new root sharing every untouched
+// subtree with the previous one, which makes keeping the tree of every
+// canonical block cheap. A subtree holding a single account is stored as a
+// leaf right away rather than as a chain of nodes down to the last level.
+const stateTreeDepth = common.AddressLength * 8
+
+// emptySubtrees[d] is the hash of an empty subtree rooted at depth d
+var emptySubtrees = func() [stateTreeDepth + 1]Hash {
+	var empty [stateTreeDepth + 1]Hash
+	for d := stateTreeDepth - 1; d >= 0; d-- {
+		empty[d] = merkleNode(empty[d+1], empty[d+1])
+	}
+
+	return empty
+}()
+
+type stateNode struct {
+	hash Hash
+
+	left  *stateNode
+	right *stateNode
+
+	isLeaf    bool
+	account   common.Address
+	balance   uint
+	nonce     uint
+	valueHash Hash
+}
+
+// StateProofStep is a non empty sibling hash on the path from an account up to
+// the state root. Siblings missing from the proof are empty subtrees.
+type StateProofStep struct {
+	Depth int  `json:"depth"`
+	Hash  Hash `json:"hash"`
+}
+
+// AccountProof proves the balance and nonce of an account, or that it has
+// neither, against a state root.
+type AccountProof struct {
+	Account common.Address   `json:"account"`
+	Balance uint             `json:"balance"`
+	Nonce   uint             `json:"nonce"`
+	Steps   []StateProofStep `json:"steps"`
+}
+
+func accountValueHash(account common.Address, balance, nonce uint) Hash {
+	if balance == 0 && nonce == 0 {
+		return Hash{}
+	}
+
+	buf := make([]byte, 0, 1+common.AddressLength+16)
+	buf = append(buf, merkleLeafPrefix)
+	buf = append(buf, account[:]...)
+	buf = append(buf, make([]byte, 16)...)
+	binary.BigEndian.PutUint64(buf[1+common.AddressLength:], uint64(balance))
+	binary.BigEndian.PutUint64(buf[1+common.AddressLength+8:], uint64(nonce))
+
+	return sha256.Sum256(buf)
+}
+
+func addressBit(account common.Address, depth int) byte {
+	return (account[depth/8] >> (7 - uint(depth%8))) & 1
+}
+
+// foldStatePath hashes a value up from the last level to the given depth,
+// next to empty siblings only.
+func foldStatePath(account common.Address, valueHash Hash, depth int) Hash {
+	hash := valueHash
+	for d := stateTreeDepth; d > depth; d-- {
+		if addressBit(account, d-1) == 0 {
+			hash = merkleNode(hash, emptySubtrees[d])
+		} else {
+			hash = merkleNode(emptySubtrees[d], hash)
+		}
+	}
+
+	return hash
+}
+
+func stateNodeHash(n *stateNode, depth int) Hash {
+	if n == nil {
+		return emptySubtrees[depth]
+	}
+
+	return n.hash
+}
+
+func newStateLeaf(leaf *stateNode, depth int) *stateNode {
+	return &stateNode{
+		hash:      foldStatePath(leaf.account, leaf.valueHash, depth),
+		isLeaf:    true,
+		account:   leaf.account,
+		balance:   leaf.balance,
+		nonce:     leaf.nonce,
+		valueHash: leaf.valueHash,
+	}
+}
+
+// updateStateTree returns the tree rooted at n, at the given depth, with the
+// account set to its new balance and nonce.
+func updateStateTree(n *stateNode, depth int, account common.Address, balance, nonce uint) *stateNode {
+	var leaf *stateNode
+	if valueHash := accountValueHash(account, balance, nonce); !valueHash.IsEmpty() {
+		leaf = &stateNode{isLeaf: true, account: account, balance: balance, nonce: nonce, valueHash: valueHash}
+	}
+
+	return setStateLeaf(n, depth, account, leaf)
+}
+
+func setStateLeaf(n *stateNode, depth int, account common.Address, leaf *stateNode) *stateNode {
+	if n == nil {
+		if leaf == nil {
+			return nil
+		}
+
+		return newStateLeaf(leaf, depth)
+	}
+
+	if n.isLeaf {
+		if n.account == account {
+			if leaf == nil {
+				return nil
+			}
+
+			return newStateLeaf(leaf, depth)
+		}
+
+		if leaf == nil {
+			return n
+		}
+
+		// Another account shares the subtree, push the current one a level down
+		split := &stateNode{}
+		if addressBit(n.account, depth) == 0 {
+			split.left = newStateLeaf(n, depth+1)
+		} else {
+			split.right = newStateLeaf(n, depth+1)
+		}
+
+		return setStateLeaf(split, depth, account, leaf)
+	}
+
+	left, right := n.left, n.right
+	if addressBit(account, depth) == 0 {
+		left = setStateLeaf(left, depth+1, account, leaf)
+	} else {
+		right = setStateLeaf(right, depth+1, account, leaf)
+	}
+
+	switch {
+	case left == nil && right == nil:
+		return nil
+	case left == nil && right.isLeaf:
+		return newStateLeaf(right, depth)
+	case right == nil && left.isLeaf:
+		return newStateLeaf(left, depth)
+	}
+
+	return &stateNode{
+		hash:  merkleNode(stateNodeHash(left, depth+1), stateNodeHash(right, depth+1)),
+		left:  left,
+		right: right,
+	}
+}
+
+// proveAccount walks the tree rooted at n down to the account.
+func proveAccount(n *stateNode, account common.Address) AccountProof {
+	proof := AccountProof{Account: account, Steps: make([]StateProofStep, 0)}
+
+	for depth := 0; n != nil; depth++ {
+		if n.isLeaf {
+			if n.account == account {
+				proof.Balance = n.balance
+				proof.Nonce = n.nonce
+				break
+			}
+
+			// The account is missing, the paths of both accounts part at the first differing bit
+			fork := depth
+			for addressBit(account, fork) == addressBit(n.account, fork) {
+				fork++
+			}
+
+			proof.Steps = append(proof.Steps, StateProofStep{fork + 1, foldStatePath(n.account, n.valueHash, fork+1)})
+			break
+		}
+
+		child, sibling := n.left, n.right
+		if addressBit(account, depth) == 1 {
+			child, sibling = n.right, n.left
+		}
+
+		if sibling != nil {
+			proof.Steps = append(proof.Steps, StateProofStep{depth + 1, sibling.hash})
+		}
+
+		n = child
+	}
+
+	return proof
+}
+
+// VerifyAccountProof checks the proof leads from the account balance and
+// nonce up to stateRoot, which the header of the block commits to.
+func VerifyAccountProof(proof AccountProof, stateRoot Hash) bool {
+	siblings := make(map[int]Hash)
+	for _, step := range proof.Steps {
+		if step.Depth < 1 || step.Depth > stateTreeDepth {
+			return false
+		}
+
+		siblings[step.Depth] = step.Hash
+	}
+
+	hash := accountValueHash(proof.Account, proof.Balance, proof.Nonce)
+	for d := stateTreeDepth; d > 0; d-- {
+		sibling, ok := siblings[d]
+		if !ok {
+			sibling = emptySubtrees[d]
+		}
+
+		if addressBit(proof.Account, d-1) == 0 {
+			hash = merkleNode(hash, sibling)
+		} else {
+			hash = merkleNode(sibling, hash)
+		}
+	}
+
+	return hash == stateRoot
+}
+
+// updateAccounts writes the current balance and nonce of the accounts to the state tree.
+func (s *State) updateAccounts(accounts []common.Address) {
+	for _, account := range accounts {
+		s.accounts = updateStateTree(s.accounts, 0, account, s.Balances[account], s.Account2Nonce[account])
+	}
+}
+
+func (s *State) stateRoot() Hash {
+	return stateNodeHash(s.accounts, 0)
+}
+
+// NextStateRoot returns the state root the header of b must commit to, b
+// being the next block on top of the current head.
+func (s *State) NextStateRoot(b Block) (Hash, error) {
+	s.lock.RLock()
+	if b.Header.Parent != s.latestBlockHash {
+		s.lock.RUnlock()
+		return Hash{}, fmt.Errorf("block parent '%x' is not the head '%x'", b.Header.Parent, s.latestBlockHash)
+	}
+	pendingState := s.copy()
+	s.lock.RUnlock()
+
+	err := applyBlockTXs(b, pendingState)
+	if err != nil {
+		return Hash{}, err
+	}
+
+	return pendingState.stateRoot(), nil
+}
+
+// GetAccountProof proves the balance and nonce of an account as of a
+// canonical block, the head when blockHash is empty, along with the header of
+// the block holding the state root to verify it against.
+func (s *State) GetAccountProof(account common.Address, blockHash Hash) (AccountProof, BlockHeader, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if blockHash.IsEmpty() {
+		if !s.hasGenesisBlock {
+			return AccountProof{}, BlockHeader{}, fmt.Errorf("block %w, the chain is empty", ErrNotFound)
+		}
+
+		blockHash = s.latestBlockHash
+	}
+
+	n, isKnown := s.blocks[blockHash]
+	if !isKnown {
+		return AccountProof{}, BlockHeader{}, fmt.Errorf("block '%x' %w", blockHash, ErrNotFound)
+	}
+
+	if !s.isCanonical(n) {
+		return AccountProof{}, BlockHeader{}, fmt.Errorf("block '%x' is not canonical, its state is not kept", blockHash)
+	}
+
+	if n.accounts == nil {
+		return AccountProof{}, BlockHeader{}, fmt.Errorf("block '%x' is older than the state snapshot, its state is not kept", blockHash)
+	}
+
+	b, err := s.store.Get(n.hash())
+	if err != nil {
+		return AccountProof{}, BlockHeader{}, err
+	}
+
+	return proveAccount(n.accounts, account), b.Header, nil
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestAccountProof(t *testing.T) {
+	// Neighbouring addresses share most of their path, far apart ones don't
+	accounts := []common.Address{
+		NewAccount("0x0000000000000000000000000000000000000001"),
+		NewAccount("0x0000000000000000000000000000000000000002"),
+		NewAccount("0x0000000000000000000000000000000000000003"),
+		NewAccount("0x8000000000000000000000000000000000000000"),
+		NewAccount("0xffffffffffffffffffffffffffffffffffffffff"),
+	}
+	missing := NewAccount("0x0000000000000000000000000000000000000004")
+
+	var tree *stateNode
+	for i, account := range accounts {
+		tree = updateStateTree(tree, 0, account, uint(i+1)*100, uint(i))
+	}
+	root := stateNodeHash(tree, 0)
+
+	for i, account := range accounts {
+		proof := proveAccount(tree, account)
+		if proof.Balance != uint(i+1)*100 || proof.Nonce != uint(i) {
+			t.Fatalf("proof of account %s holds balance %d and nonce %d", account.Hex(), proof.Balance, proof.Nonce)
+		}
+
+		if !VerifyAccountProof(proof, root) {
+			t.Fatalf("proof of account %s doesn't verify", account.Hex())
+		}
+
+		proof.Balance++
+		if VerifyAccountProof(proof, root) {
+			t.Fatalf("tampered proof of account %s shouldn't verify", account.Hex())
+		}
+	}
+
+	proof := proveAccount(tree, missing)
+	if !VerifyAccountProof(proof, root) {
+		t.Fatal("proof of a missing account doesn't verify")
+	}
+
+	proof.Balance = 1
+	if VerifyAccountProof(proof, root) {
+		t.Fatal("a missing account shouldn't be provable with a balance")
+	}
+
+	// The root only depends on the accounts, not on the order they were written in
+	var reversed *stateNode
+	for i := len(accounts) - 1; i >= 0; i-- {
+		reversed = updateStateTree(reversed, 0, accounts[i], uint(i+1)*100, uint(i))
+	}
+	if stateNodeHash(reversed, 0) != root {
+		t.Fatal("the state root shouldn't depend on the update order")
+	}
+
+	// Emptied accounts leave the tree, down to the empty root
+	for _, account := range accounts {
+		tree = updateStateTree(tree, 0, account, 0, 0)
+	}
+	if tree != nil || stateNodeHash(tree, 0) != emptySubtrees[0] {
+		t.Fatal("a tree without accounts should be empty")
+	}
+}
@@ -10,6 +10,11 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
+// Signers of a proof of authority chain vote with TXs whose Data is one of
+// these, to add or remove the signer in To, see package consensus.
+const TxDataVoteAddSigner = "vote_add_signer"
+const TxDataVoteRemoveSigner = "vote_remove_signer"
+
 func NewAccount(value string) common.Address {
 	return common.HexToAddress(value)
 }
@@ -21,6 +26,10 @@ type Tx struct {
 	Nonce uint           `json:"nonce"`
 	Data  string         `json:"data"`
 	Time  uint64         `json:"time"`
+
+	// Fee goes to the miner of the block including the TX. Left out of the
+	// JSON when empty.
+	Fee uint `json:"fee,omitempty"`
 }
 
 type SignedTx struct {
@@ -36,9 +45,18 @@ func NewTx(from, to common.Address, value, nonce uint, data string) Tx {
 		nonce,
 		data,
 		uint64(time.Now().Unix()),
+		0,
 	}
 }
 
+// NewTxWithFee creates a TX paying fee to the miner of the block including it.
+func NewTxWithFee(from, to common.Address, value, nonce uint, data string, fee uint) Tx {
+	tx := NewTx(from, to, value, nonce, data)
+	tx.Fee = fee
+
+	return tx
+}
+
 func NewSignedTx(tx Tx, sig []byte) SignedTx {
 	return SignedTx{
 		tx,
@@ -50,10 +68,19 @@ func (t Tx) Encode() ([]byte, error) {
 	return json.Marshal(t)
 }
 
+// Cost is what the sender pays for the TX, its value and fee.
+func (t Tx) Cost() uint {
+	return t.Value + t.Fee
+}
+
 func (t Tx) IsReward() bool {
 	return t.Data == "reward"
 }
 
+func (t Tx) IsSignerVote() bool {
+	return t.Data == TxDataVoteAddSigner || t.Data == TxDataVoteRemoveSigner
+}
+
 func (t Tx) Hash() (Hash, error) {
 	txJson, err := t.Encode()
 	if err != nil {
@@ -81,12 +108,23 @@ func (t SignedTx) IsAuthentic() (bool, error) {
 		return false, err
 	}
 
-	// Verify if the signature is compatible with this msg (TX)
-	recoveredPubKey, err := crypto.SigToPub(txHash[:], t.Sig)
+	recoveredAccount, err := recoverSigner(txHash, t.Sig)
 	if err != nil {
 		return false, err
 	}
 
+	//Compare the signature owner with TX owner
+	return recoveredAccount.Hex() == t.From.Hex(), nil
+}
+
+// recoverSigner returns the account whose key signed the hash with sig.
+func recoverSigner(hash Hash, sig []byte) (common.Address, error) {
+	// Verify if the signature is compatible with this msg
+	recoveredPubKey, err := crypto.SigToPub(hash[:], sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+
 	// Convert the recovered Pub key to an account
 	recoveredPubKeyBytes := elliptic.Marshal(
 		crypto.S256(),
@@ -98,10 +136,5 @@ func (t SignedTx) IsAuthentic() (bool, error) {
 		recoveredPubKeyBytes[1:],
 	)
 
-	recoveredAccount := common.BytesToAddress(
-		recoveredPubKeyBytesHash[12:],
-	)
-
-	//Compare the signature owner with TX owner
-	return recoveredAccount.Hex() == t.From.Hex(), nil
+	return common.BytesToAddress(recoveredPubKeyBytesHash[12:]), nil
 }
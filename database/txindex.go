@@ -0,0 +1,143 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ErrNotFound is returned by the lookups when the block, TX or account is unknown.
+var ErrNotFound = errors.New("not found")
+
+// TxLocation points to a TX in a canonical block.
+type TxLocation struct {
+	BlockHash   Hash   `json:"block_hash"`
+	BlockNumber uint64 `json:"block_number"`
+	Index       int    `json:"index"`
+}
+
+// IndexedTx is a canonical TX along with where it was mined.
+type IndexedTx struct {
+	TxLocation
+	Hash Hash     `json:"tx_hash"`
+	TX   SignedTx `json:"tx"`
+}
+
+// indexBlockTXs adds the TXs of a block joining the canonical chain to the
+// TX and account indexes.
+func (s *State) indexBlockTXs(hash Hash, b Block) error {
+	for i, tx := range b.TXs {
+		txHash, err := tx.Hash()
+		if err != nil {
+			return err
+		}
+
+		s.txs[txHash] = TxLocation{hash, b.Header.Number, i}
+
+		s.accountTXs[tx.From] = append(s.accountTXs[tx.From], txHash)
+		if tx.To != tx.From {
+			s.accountTXs[tx.To] = append(s.accountTXs[tx.To], txHash)
+		}
+	}
+
+	return nil
+}
+
+// unindexBlockTXs removes the TXs of a block leaving the canonical chain.
+// Blocks leave from the head, so their TXs are the last ones of every account history.
+func (s *State) unindexBlockTXs(b Block) {
+	for i := len(b.TXs) - 1; i >= 0; i-- {
+		tx := b.TXs[i]
+
+		txHash, err := tx.Hash()
+		if err != nil {
+			continue
+		}
+
+		delete(s.txs, txHash)
+
+		for _, acc := range []common.Address{tx.From, tx.To} {
+			history := s.accountTXs[acc]
+			if len(history) > 0 && history[len(history)-1] == txHash {
+				s.accountTXs[acc] = history[:len(history)-1]
+			}
+
+			if len(s.accountTXs[acc]) == 0 {
+				delete(s.accountTXs, acc)
+			}
+		}
+	}
+}
+
+// GetTx returns a TX mined in the canonical chain.
+func (s *State) GetTx(txHash Hash) (IndexedTx, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	location, isKnown := s.txs[txHash]
+	if !isKnown {
+		return IndexedTx{}, fmt.Errorf("TX '%x' %w", txHash, ErrNotFound)
+	}
+
+	b, err := s.store.Get(location.BlockHash)
+	if err != nil {
+		return IndexedTx{}, err
+	}
+
+	return IndexedTx{location, txHash, b.TXs[location.Index]}, nil
+}
+
+// GetAccountTXs returns every canonical TX sent or received by the account, oldest first.
+func (s *State) GetAccountTXs(account common.Address) ([]IndexedTx, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	history := s.accountTXs[account]
+	txs := make([]IndexedTx, 0, len(history))
+
+	// An account often has several TXs in the same block, read it once
+	var b Block
+	var bHash Hash
+
+	for _, txHash := range history {
+		location := s.txs[txHash]
+
+		if location.BlockHash != bHash {
+			var err error
+			b, err = s.store.Get(location.BlockHash)
+			if err != nil {
+				return nil, err
+			}
+			bHash = location.BlockHash
+		}
+
+		txs = append(txs, IndexedTx{location, txHash, b.TXs[location.Index]})
+	}
+
+	return txs, nil
+}
+
+// GetTxProof returns the Merkle proof of a canonical TX, along with the header
+// of its block holding the TX root to verify it against.
+func (s *State) GetTxProof(txHash Hash) (TxProof, BlockHeader, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	location, isKnown := s.txs[txHash]
+	if !isKnown {
+		return TxProof{}, BlockHeader{}, fmt.Errorf("TX '%x' %w", txHash, ErrNotFound)
+	}
+
+	b, err := s.store.Get(location.BlockHash)
+	if err != nil {
+		return TxProof{}, BlockHeader{}, err
+	}
+
+	proof, err := NewTxProof(b.TXs, location.Index)
+	if err != nil {
+		return TxProof{}, BlockHeader{}, err
+	}
+
+	return proof, b.Header, nil
+}
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DBVerifyReport is the outcome of re-validating block.db, see VerifyBlocksDB.
+type DBVerifyReport struct {
+	// Blocks stored before the first bad one, taking ValidSize bytes of the DB
+	Blocks    int
+	ValidSize int64
+	Size      int64
+
+	// Err tells why the record at BadOffset is bad, it's nil when every block is valid
+	Err       error
+	BadOffset int64
+	BadHash   Hash
+	BadNumber uint64
+}
+
+// VerifyBlocksDB re-validates every block of block.db, in the order they were
+// stored, as if the node received them from a peer, side branches included.
+// It stops at the first bad one. Only the BlockStoreFile store keeps a
+// block.db, blockStore is the kind of store the node runs with.
+func VerifyBlocksDB(dataDir string, blockStore string, engine Engine) (DBVerifyReport, error) {
+	err := checkBlocksDBStore(blockStore)
+	if err != nil {
+		return DBVerifyReport{}, err
+	}
+
+	dataDirLock, err := lockDataDir(dataDir)
+	if err != nil {
+		return DBVerifyReport{}, err
+	}
+	defer dataDirLock.release()
+
+	return verifyBlocksDB(dataDir, engine)
+}
+
+func verifyBlocksDB(dataDir string, engine Engine) (DBVerifyReport, error) {
+	gen, err := loadGenesis(getGenesisJSONFilePath(dataDir))
+	if err != nil {
+		return DBVerifyReport{}, err
+	}
+
+	genHash, err := gen.Hash()
+	if err != nil {
+		return DBVerifyReport{}, err
+	}
+
+	f, err := os.Open(getBlocksDBFilePath(dataDir))
+	if err != nil {
+		return DBVerifyReport{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return DBVerifyReport{}, err
+	}
+
+	report := DBVerifyReport{Size: info.Size()}
+
+	// Replaying into a State kept in memory runs every check a new block goes through
+	state := newState("", gen, genHash, newMemoryBlockStore(), engine)
+	reader := bufio.NewReader(f)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return DBVerifyReport{}, err
+		}
+
+		report.BadOffset = report.ValidSize
+		report.BadHash = Hash{}
+		report.BadNumber = 0
+
+		if err == io.EOF {
+			report.Err = fmt.Errorf("torn record of %d bytes without its new line", len(line))
+			break
+		}
+
+		var blockFs BlockFS
+		err = json.Unmarshal(line, &blockFs)
+		if err != nil {
+			report.Err = fmt.Errorf("unable to decode block. %s", err.Error())
+			break
+		}
+
+		report.BadHash = blockFs.Key
+		report.BadNumber = blockFs.Value.Header.Number
+
+		hash, err := blockFs.Value.Hash()
+		if err != nil {
+			report.Err = err
+			break
+		}
+
+		if hash != blockFs.Key {
+			report.Err = fmt.Errorf("block hash is '%x'", hash)
+			break
+		}
+
+		_, reorg, err := state.ImportBlock(blockFs.Value)
+		if err != nil {
+			report.Err = err
+			break
+		}
+
+		// Blocks of side branches only got their header checked, their TXs are checked too
+		if reorg == nil && state.LatestBlockHash() != hash {
+			err = state.verifySideBlock(hash, blockFs.Value)
+			if err != nil {
+				report.Err = err
+				break
+			}
+		}
+
+		report.Blocks++
+		report.ValidSize += int64(len(line))
+	}
+
+	if report.Err == nil {
+		report.BadOffset = 0
+		report.BadHash = Hash{}
+		report.BadNumber = 0
+	}
+
+	return report, nil
+}
+
+// RepairBlocksDB truncates block.db right before its first bad block. The
+// blocks stored after it are dropped too, the node syncs them again from its
+// peers. The block index gets rebuilt on the next start.
+func RepairBlocksDB(dataDir string, blockStore string, engine Engine) (DBVerifyReport, error) {
+	err := checkBlocksDBStore(blockStore)
+	if err != nil {
+		return DBVerifyReport{}, err
+	}
+
+	dataDirLock, err := lockDataDir(dataDir)
+	if err != nil {
+		return DBVerifyReport{}, err
+	}
+	defer dataDirLock.release()
+
+	report, err := verifyBlocksDB(dataDir, engine)
+	if err != nil || report.Err == nil {
+		return report, err
+	}
+
+	f, err := os.OpenFile(getBlocksDBFilePath(dataDir), os.O_RDWR, 0600)
+	if err != nil {
+		return report, err
+	}
+	defer f.Close()
+
+	err = f.Truncate(report.ValidSize)
+	if err != nil {
+		return report, err
+	}
+
+	err = f.Sync()
+	if err != nil {
+		return report, err
+	}
+
+	err = os.Remove(getBlockIndexFilePath(dataDir))
+	if err != nil && !os.IsNotExist(err) {
+		return report, err
+	}
+
+	return report, nil
+}
+
+// checkBlocksDBStore refuses to verify or repair block.db for a node whose
+// blocks live in another kind of store.
+func checkBlocksDBStore(blockStore string) error {
+	if blockStore == BlockStoreFile || blockStore == "" {
+		return nil
+	}
+
+	return fmt.Errorf("only the '%s' block store keeps its blocks in block.db, the '%s' one can't be verified nor repaired", BlockStoreFile, blockStore)
+}
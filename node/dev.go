@@ -0,0 +1,46 @@
+package node
+
+import (
+	"encoding/json"
+
+	"github.com/paulcockrell/blockchain/consensus"
+	"github.com/paulcockrell/blockchain/database"
+	"github.com/paulcockrell/blockchain/wallet"
+)
+
+// EnableDevMode makes Run start a local development chain, see database.DevGenesis.
+// The data dir gets the dev genesis and the dev account, in its keystore with
+// wallet.DevAccountPwd, and every new pending TX is mined right away.
+func (n *Node) EnableDevMode() {
+	n.isDev = true
+}
+
+func initDevDataDir(dataDir string) error {
+	genesis := database.DevGenesis(database.NewAccount(wallet.DevAccount))
+	genesisJSON, err := json.Marshal(genesis)
+	if err != nil {
+		return err
+	}
+
+	err = database.InitDataDirIfNotExists(dataDir, genesisJSON)
+	if err != nil {
+		return err
+	}
+
+	return wallet.ImportDevAccount(dataDir)
+}
+
+// requestSeal makes a dev node mine its pending TXs without waiting for the
+// next mining interval, as does a proof of authority signer whose engine waits
+// for the block period itself. Requests while one is already queued are dropped.
+func (n *Node) requestSeal() {
+	_, isPoA := n.engine.(*consensus.PoA)
+	if !n.isDev && !(isPoA && n.signerKey != nil) {
+		return
+	}
+
+	select {
+	case n.sealNow <- struct{}{}:
+	default:
+	}
+}
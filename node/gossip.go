@@ -0,0 +1,149 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/paulcockrell/blockchain/database"
+)
+
+// A slow peer shouldn't pile up gossip goroutines
+const gossipTimeout = 5 * time.Second
+
+var gossipClient = &http.Client{Timeout: gossipTimeout}
+
+// gossip announces the TXs this node accepts to its known peers, as soon as
+// they enter the pending pool.
+func (n *Node) gossip(ctx context.Context) error {
+	for {
+		select {
+		case tx := <-n.newPendingTXs:
+			n.broadcastTX(tx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// addGossipedBlock imports a block pushed by fromPeer and relays it to the
+// other peers. Known blocks are ignored, so a block is only relayed once.
+func (n *Node) addGossipedBlock(b database.Block, fromPeer PeerNode) error {
+	hash, err := b.Hash()
+	if err != nil {
+		return err
+	}
+
+	if n.state.HasBlock(hash) {
+		return nil
+	}
+
+	// The peer is ahead of us by more than this block, catch up on the rest first
+	if !b.Header.Parent.IsEmpty() && !n.state.HasBlock(b.Header.Parent) {
+		status, err := queryPeerStatus(fromPeer)
+		if err != nil {
+			return err
+		}
+
+		err = n.syncBlocks(fromPeer, status)
+		if err != nil {
+			return err
+		}
+
+		if n.state.HasBlock(hash) {
+			n.broadcastBlock(b, fromPeer)
+			return nil
+		}
+	}
+
+	_, reorg, err := n.state.ImportBlock(b)
+	n.recordServedBlock(fromPeer, err)
+	if err != nil {
+		return err
+	}
+
+	if reorg != nil {
+		n.restoreReorgedTXs(reorg)
+	}
+
+	n.notifySyncedBlock(b)
+
+	n.broadcastBlock(b, fromPeer)
+
+	return nil
+}
+
+// notifySyncedBlock tells the miner about a block added by a peer without waiting
+// for it, the mining loop being gone once the node stops. When the buffer is full
+// the block is dropped: its TXs get filtered out of the next block to mine anyway.
+func (n *Node) notifySyncedBlock(b database.Block) {
+	select {
+	case n.newSyncedBlocks <- b:
+	default:
+	}
+}
+
+// broadcastBlock pushes b to every known peer but the one it came from.
+func (n *Node) broadcastBlock(b database.Block, fromPeer PeerNode) {
+	req := BlockGossipReq{
+		From:        n.info,
+		GenesisHash: n.state.GenesisHash(),
+		Block:       b,
+	}
+
+	n.broadcast(endpointBlockGossip, req, fromPeer)
+}
+
+func (n *Node) broadcastTX(tx database.SignedTx) {
+	req := TxGossipReq{
+		From:        n.info,
+		GenesisHash: n.state.GenesisHash(),
+		TX:          tx,
+	}
+
+	n.broadcast(endpointTXGossip, req, PeerNode{})
+}
+
+func (n *Node) broadcast(endpoint string, req interface{}, fromPeer PeerNode) {
+	reqJSON, err := json.Marshal(req)
+	if err != nil {
+		fmt.Printf("ERROR: unable to gossip to '%s'. %s\n", endpoint, err)
+		return
+	}
+
+	for _, peer := range n.getKnownPeers() {
+		if peer.TcpAddress() == n.info.TcpAddress() || peer.TcpAddress() == fromPeer.TcpAddress() {
+			continue
+		}
+
+		go func(peer PeerNode) {
+			err := pushToPeer(peer, endpoint, reqJSON)
+			if err != nil {
+				fmt.Printf("Unable to gossip to Peer '%s'. %s\n", peer.TcpAddress(), err)
+			}
+		}(peer)
+	}
+}
+
+func pushToPeer(peer PeerNode, endpoint string, reqJSON []byte) error {
+	url := fmt.Sprintf("http://%s%s", peer.TcpAddress(), endpoint)
+
+	res, err := gossipClient.Post(url, "application/json", bytes.NewReader(reqJSON))
+	if err != nil {
+		return err
+	}
+
+	gossipRes := GossipRes{}
+	err = readRes(res, &gossipRes)
+	if err != nil {
+		return err
+	}
+	if gossipRes.Error != "" {
+		return fmt.Errorf(gossipRes.Error)
+	}
+
+	return nil
+}
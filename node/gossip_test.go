@@ -0,0 +1,116 @@
+package node
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/paulcockrell/blockchain/database"
+	"github.com/paulcockrell/blockchain/fs"
+	"github.com/paulcockrell/blockchain/wallet"
+)
+
+func TestNode_GossipedTXsAreValidated(t *testing.T) {
+	dataDir, paulc, babaYaga, err := setupTestNodeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveDir(dataDir)
+
+	n := New(dataDir, "127.0.0.1", 8085, paulc, PeerNode{})
+	n.state, err = database.NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.state.Close()
+
+	peer := NewPeerNode("127.0.0.1", 8086, false, babaYaga, true)
+	ksDir := wallet.GetKeystoreDirPath(dataDir)
+
+	signedTx, err := wallet.SignTxWithKeystoreAccount(database.NewTx(paulc, babaYaga, 5, 1, ""), paulc, testKsAccountsPwd, ksDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Same TX with another value, the signature doesn't match anymore
+	forgedTx := database.NewSignedTx(database.NewTx(paulc, babaYaga, 500, 1, ""), signedTx.Sig)
+
+	// BabaYaga owns nothing in genesis
+	unfundedTx, err := wallet.SignTxWithKeystoreAccount(database.NewTx(babaYaga, paulc, 5, 1, ""), babaYaga, testKsAccountsPwd, ksDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nonce 3 doesn't follow the pending nonce 1
+	nonceGapTx, err := wallet.SignTxWithKeystoreAccount(database.NewTx(paulc, babaYaga, 5, 3, ""), paulc, testKsAccountsPwd, ksDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := sendTXGossipReq(t, n, peer, signedTx)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("a valid TX should be accepted, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(n.getPendingTXsAsArray()) != 1 || len(n.newPendingTXs) != 1 {
+		t.Fatal("a valid TX should be pooled and relayed")
+	}
+	<-n.newPendingTXs
+
+	for name, tx := range map[string]database.SignedTx{"forged": forgedTx, "unfunded": unfundedTx, "nonce gap": nonceGapTx} {
+		rec = sendTXGossipReq(t, n, peer, tx)
+		if rec.Code == http.StatusOK {
+			t.Fatalf("the %s TX should be refused", name)
+		}
+
+		if len(n.getPendingTXsAsArray()) != 1 || len(n.newPendingTXs) != 0 {
+			t.Fatalf("the %s TX should neither be pooled nor relayed", name)
+		}
+	}
+
+	// A TX the node already has is acknowledged but not relayed again
+	rec = sendTXGossipReq(t, n, peer, signedTx)
+	if rec.Code != http.StatusOK || len(n.newPendingTXs) != 0 {
+		t.Fatalf("a known TX should be ignored, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestNode_SyncedBlockNotificationDoesNotBlock(t *testing.T) {
+	n := New("", "127.0.0.1", 8085, common.Address{}, PeerNode{})
+
+	// Nobody mines, the notifications past the buffer are dropped
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < syncedBlocksBufferSize+1; i++ {
+			n.notifySyncedBlock(database.Block{})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("notifying the miner should not wait for it")
+	}
+
+	if len(n.newSyncedBlocks) != syncedBlocksBufferSize {
+		t.Fatalf("expected %d buffered blocks, got %d", syncedBlocksBufferSize, len(n.newSyncedBlocks))
+	}
+}
+
+func sendTXGossipReq(t *testing.T, n *Node, from PeerNode, tx database.SignedTx) *httptest.ResponseRecorder {
+	reqJSON, err := json.Marshal(TxGossipReq{From: from, GenesisHash: n.state.GenesisHash(), TX: tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, endpointTXGossip, strings.NewReader(string(reqJSON)))
+	req.RemoteAddr = from.IP + ":50000"
+	rec := httptest.NewRecorder()
+	txGossipHandler(rec, req, n)
+
+	return rec
+}
@@ -0,0 +1,129 @@
+package node
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// PeerRes is a peer of the peer book with its score.
+type PeerRes struct {
+	PeerRecord
+	Score    int     `json:"score"`
+	Uptime   float64 `json:"uptime"`
+	IsBanned bool    `json:"is_banned"`
+}
+
+type PeersRes struct {
+	Peers []PeerRes `json:"peers"`
+}
+
+// PeerAdminReq identifies the peer to add, remove or unban. Only adding uses its Account.
+type PeerAdminReq struct {
+	IP      string         `json:"ip"`
+	Port    uint64         `json:"port"`
+	Account common.Address `json:"account"`
+}
+
+type PeerAdminRes struct {
+	Success bool `json:"success"`
+}
+
+// adminHandler only lets requests from this machine through to handler, the
+// admin endpoints change what the node trusts.
+func adminHandler(handler func(w http.ResponseWriter, r *http.Request, node *Node), node *Node) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		ip := net.ParseIP(host)
+		if err != nil || ip == nil || !ip.IsLoopback() {
+			writeErrResWithStatus(w, fmt.Errorf("admin endpoints only answer local requests"), http.StatusForbidden)
+			return
+		}
+
+		handler(w, r, node)
+	}
+}
+
+func listPeersHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	records := node.listPeers()
+
+	res := PeersRes{Peers: make([]PeerRes, len(records))}
+	for i, record := range records {
+		res.Peers[i] = PeerRes{record, record.Score(), record.Uptime(), record.IsBanned()}
+	}
+
+	writeRes(w, res)
+}
+
+func adminAddPeerHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	req, ok := readPeerAdminReq(w, r)
+	if !ok {
+		return
+	}
+
+	// Not connected yet, the node joins it on the next sync
+	node.AddPeer(NewPeerNode(req.IP, req.Port, false, req.Account, false))
+	savePeersAndRespond(w, node, true)
+}
+
+func adminRemovePeerHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	req, ok := readPeerAdminReq(w, r)
+	if !ok {
+		return
+	}
+
+	peer := NewPeerNode(req.IP, req.Port, false, common.Address{}, false)
+	isKnown := node.IsKnownPeer(peer)
+	node.RemovePeer(peer)
+	savePeersAndRespond(w, node, isKnown)
+}
+
+func adminUnbanPeerHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	req, ok := readPeerAdminReq(w, r)
+	if !ok {
+		return
+	}
+
+	isKnown := node.UnbanPeer(NewPeerNode(req.IP, req.Port, false, common.Address{}, false))
+	savePeersAndRespond(w, node, isKnown)
+}
+
+func readPeerAdminReq(w http.ResponseWriter, r *http.Request) (PeerAdminReq, bool) {
+	if r.Method != http.MethodPost {
+		writeErrResWithStatus(w, fmt.Errorf("only POST requests are allowed"), http.StatusMethodNotAllowed)
+		return PeerAdminReq{}, false
+	}
+
+	req := PeerAdminReq{}
+	err := readReq(r, &req)
+	if err != nil {
+		writeErrResWithStatus(w, err, http.StatusBadRequest)
+		return PeerAdminReq{}, false
+	}
+
+	if req.IP == "" || req.Port == 0 {
+		writeErrResWithStatus(w, fmt.Errorf("the peer 'ip' and 'port' are required"), http.StatusBadRequest)
+		return PeerAdminReq{}, false
+	}
+
+	return req, true
+}
+
+// savePeersAndRespond persists an admin change to the peer book, success
+// reporting whether the peer was known.
+func savePeersAndRespond(w http.ResponseWriter, node *Node, success bool) {
+	err := node.savePeers()
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	if !success {
+		writeErrResWithStatus(w, fmt.Errorf("unknown peer"), http.StatusNotFound)
+		return
+	}
+
+	writeRes(w, PeerAdminRes{Success: true})
+}
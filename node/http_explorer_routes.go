@@ -0,0 +1,273 @@
+package node
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/paulcockrell/blockchain/database"
+)
+
+type BlockRes struct {
+	Hash  database.Hash  `json:"block_hash"`
+	Block database.Block `json:"block"`
+}
+
+type BlocksRes struct {
+	Blocks []BlockRes `json:"blocks"`
+}
+
+type TxRes struct {
+	database.IndexedTx
+	Confirmations uint64 `json:"confirmations"`
+	Pending       bool   `json:"pending"`
+}
+
+type TxProofRes struct {
+	BlockHash database.Hash        `json:"block_hash"`
+	Header    database.BlockHeader `json:"header"`
+	Proof     database.TxProof     `json:"proof"`
+}
+
+type AccountProofRes struct {
+	BlockHash database.Hash         `json:"block_hash"`
+	Header    database.BlockHeader  `json:"header"`
+	Proof     database.AccountProof `json:"proof"`
+}
+
+type AccountRes struct {
+	Account   common.Address       `json:"account"`
+	Balance   uint                 `json:"balance"`
+	NextNonce uint                 `json:"next_nonce"`
+	TXs       []database.IndexedTx `json:"txs"`
+}
+
+// explorerHandler only lets GET requests through to handler, the explorer
+// endpoints being read only.
+func explorerHandler(handler func(w http.ResponseWriter, r *http.Request, node *Node), node *Node) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeErrResWithStatus(w, fmt.Errorf("only GET requests are allowed"), http.StatusMethodNotAllowed)
+			return
+		}
+
+		handler(w, r, node)
+	}
+}
+
+func blockHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	hash := database.Hash{}
+	err := hash.UnmarshalText([]byte(strings.TrimPrefix(r.URL.Path, endpointBlock)))
+	if err != nil {
+		writeErrResWithStatus(w, err, http.StatusBadRequest)
+		return
+	}
+
+	b, err := node.state.GetBlockByHash(hash)
+	if err != nil {
+		writeLookupErrRes(w, err)
+		return
+	}
+
+	writeRes(w, BlockRes{hash, b})
+}
+
+func blockByNumberHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	number, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, endpointBlockByNumber), 10, 64)
+	if err != nil {
+		writeErrResWithStatus(w, err, http.StatusBadRequest)
+		return
+	}
+
+	b, err := node.state.GetBlockByNumber(number)
+	if err != nil {
+		writeLookupErrRes(w, err)
+		return
+	}
+
+	res, err := newBlockRes(b)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, res)
+}
+
+func newBlockRes(b database.Block) (BlockRes, error) {
+	hash, err := b.Hash()
+	if err != nil {
+		return BlockRes{}, err
+	}
+
+	return BlockRes{hash, b}, nil
+}
+
+func blocksHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	from := uint64(0)
+	limit := uint64(defaultBlocksLimit)
+
+	var err error
+
+	if fromRaw := r.URL.Query().Get(endpointBlocksQueryKeyFrom); fromRaw != "" {
+		from, err = strconv.ParseUint(fromRaw, 10, 64)
+		if err != nil {
+			writeErrResWithStatus(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if limitRaw := r.URL.Query().Get(endpointBlocksQueryKeyLimit); limitRaw != "" {
+		limit, err = strconv.ParseUint(limitRaw, 10, 64)
+		if err != nil {
+			writeErrResWithStatus(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if limit > maxBlocksLimit {
+		writeErrResWithStatus(w, fmt.Errorf("limit can't be more than %d blocks", maxBlocksLimit), http.StatusBadRequest)
+		return
+	}
+
+	blocks, err := node.state.GetBlocks(from, limit)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	res := BlocksRes{Blocks: make([]BlockRes, len(blocks))}
+	for i, b := range blocks {
+		res.Blocks[i], err = newBlockRes(b)
+		if err != nil {
+			writeErrRes(w, err)
+			return
+		}
+	}
+
+	writeRes(w, res)
+}
+
+func txHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	path := strings.TrimPrefix(r.URL.Path, endpointTx)
+	isProofReq := strings.HasSuffix(path, endpointProofSuffix)
+
+	txHash := database.Hash{}
+	err := txHash.UnmarshalText([]byte(strings.TrimSuffix(path, endpointProofSuffix)))
+	if err != nil {
+		writeErrResWithStatus(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if isProofReq {
+		txProofHandler(w, node, txHash)
+		return
+	}
+
+	res, err := lookupTx(node, txHash)
+	if err != nil {
+		writeLookupErrRes(w, err)
+		return
+	}
+
+	writeRes(w, res)
+}
+
+// txProofHandler serves the Merkle proof a light client needs to check the TX
+// is in the block, using the header alone.
+func txProofHandler(w http.ResponseWriter, node *Node, txHash database.Hash) {
+	proof, header, err := node.state.GetTxProof(txHash)
+	if err != nil {
+		writeLookupErrRes(w, err)
+		return
+	}
+
+	blockHash, err := header.Hash()
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, TxProofRes{blockHash, header, proof})
+}
+
+// lookupTx looks a TX up in the canonical chain first, then in the pending TXs.
+func lookupTx(node *Node, txHash database.Hash) (TxRes, error) {
+	tx, err := node.state.GetTx(txHash)
+	if err == nil {
+		// The head is read after the lookup, and may still have moved below the
+		// TX's block through a reorg since
+		latestNumber := node.state.LatestBlock().Header.Number
+		if latestNumber < tx.BlockNumber {
+			return TxRes{IndexedTx: tx}, nil
+		}
+
+		return TxRes{IndexedTx: tx, Confirmations: latestNumber - tx.BlockNumber + 1}, nil
+	}
+
+	pendingTX, isPending := node.getPendingTX(txHash)
+	if !isPending {
+		return TxRes{}, err
+	}
+
+	return TxRes{IndexedTx: database.IndexedTx{Hash: txHash, TX: pendingTX}, Pending: true}, nil
+}
+
+func accountHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	path := strings.TrimPrefix(r.URL.Path, endpointAccount)
+	isProofReq := strings.HasSuffix(path, endpointProofSuffix)
+
+	accRaw := strings.TrimSuffix(path, endpointProofSuffix)
+	if !common.IsHexAddress(accRaw) {
+		writeErrResWithStatus(w, fmt.Errorf("'%s' is an invalid account", accRaw), http.StatusBadRequest)
+		return
+	}
+	acc := database.NewAccount(accRaw)
+
+	if isProofReq {
+		accountProofHandler(w, r, node, acc)
+		return
+	}
+
+	txs, err := node.state.GetAccountTXs(acc)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	res := AccountRes{
+		Account:   acc,
+		Balance:   node.state.GetBalance(acc),
+		NextNonce: node.state.GetNextAccountNonce(acc),
+		TXs:       txs,
+	}
+
+	writeRes(w, res)
+}
+
+// accountProofHandler serves the proof of the account balance and nonce as of
+// a canonical block, the latest one unless the block query param is set.
+func accountProofHandler(w http.ResponseWriter, r *http.Request, node *Node, acc common.Address) {
+	blockHash := database.Hash{}
+	err := blockHash.UnmarshalText([]byte(r.URL.Query().Get(endpointAccountProofQueryKeyBlock)))
+	if err != nil {
+		writeErrResWithStatus(w, err, http.StatusBadRequest)
+		return
+	}
+
+	proof, header, err := node.state.GetAccountProof(acc, blockHash)
+	if err != nil {
+		writeLookupErrRes(w, err)
+		return
+	}
+
+	blockHash, err = header.Hash()
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, AccountProofRes{blockHash, header, proof})
+}
@@ -0,0 +1,157 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/paulcockrell/blockchain/consensus"
+	"github.com/paulcockrell/blockchain/database"
+	"github.com/paulcockrell/blockchain/fs"
+	"github.com/paulcockrell/blockchain/wallet"
+)
+
+func TestExplorer_Routes(t *testing.T) {
+	dataDir, paulc, babaYaga, err := setupTestNodeDirWithDifficulty(1 << 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveDir(dataDir)
+
+	n := New(dataDir, "127.0.0.1", 8085, paulc, PeerNode{})
+	n.state, err = database.NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.state.Close()
+	n.engine = consensus.NewPoW(2, nil)
+
+	ksDir := wallet.GetKeystoreDirPath(dataDir)
+	minedTx, err := wallet.SignTxWithKeystoreAccount(database.NewTx(paulc, babaYaga, 10, 1, ""), paulc, testKsAccountsPwd, ksDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	minedTxHash, _ := minedTx.Hash()
+
+	err = n.AddPendingTX(minedTx, n.info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-n.newPendingTXs
+
+	work := WorkRes{}
+	sendWorkReq(t, n, http.MethodGet, endpointWork, "", http.StatusOK, &work)
+
+	header, err := consensus.MineHeader(context.Background(), work.Header, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	submitReq, _ := json.Marshal(SubmitWorkReq{work.ID, header.Nonce, header.ExtraNonce})
+	sendWorkReq(t, n, http.MethodPost, endpointSubmitWork, string(submitReq), http.StatusOK, &SubmitWorkRes{})
+
+	pendingTx, err := wallet.SignTxWithKeystoreAccount(database.NewTx(paulc, babaYaga, 5, 2, ""), paulc, testKsAccountsPwd, ksDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pendingTxHash, _ := pendingTx.Hash()
+
+	err = n.AddPendingTX(pendingTx, n.info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	latestHash := n.state.LatestBlockHash()
+	latestNumber := n.state.LatestBlock().Header.Number
+
+	blockRes := BlockRes{}
+	sendExplorerReq(t, n, blockHandler, endpointBlock+latestHash.Hex(), http.StatusOK, &blockRes)
+	if blockRes.Hash != latestHash || len(blockRes.Block.TXs) != 1 {
+		t.Fatalf("the mined block should be served, got %+v", blockRes)
+	}
+
+	sendExplorerReq(t, n, blockHandler, endpointBlock+"nope", http.StatusBadRequest, &ErrRes{})
+	sendExplorerReq(t, n, blockHandler, endpointBlock+minedTxHash.Hex(), http.StatusNotFound, &ErrRes{})
+
+	blockRes = BlockRes{}
+	sendExplorerReq(t, n, blockByNumberHandler, fmt.Sprintf("%s%d", endpointBlockByNumber, latestNumber), http.StatusOK, &blockRes)
+	if blockRes.Hash != latestHash {
+		t.Fatalf("block %d should be the mined one, got '%x'", latestNumber, blockRes.Hash)
+	}
+
+	sendExplorerReq(t, n, blockByNumberHandler, endpointBlockByNumber+"-1", http.StatusBadRequest, &ErrRes{})
+	sendExplorerReq(t, n, blockByNumberHandler, fmt.Sprintf("%s%d", endpointBlockByNumber, latestNumber+1), http.StatusNotFound, &ErrRes{})
+
+	blocksRes := BlocksRes{}
+	sendExplorerReq(t, n, blocksHandler, endpointBlocks+"?from=0&limit=10", http.StatusOK, &blocksRes)
+	if len(blocksRes.Blocks) != int(latestNumber)+1 || blocksRes.Blocks[latestNumber].Hash != latestHash {
+		t.Fatalf("every canonical block should be listed, got %d", len(blocksRes.Blocks))
+	}
+
+	sendExplorerReq(t, n, blocksHandler, endpointBlocks+"?from=first", http.StatusBadRequest, &ErrRes{})
+	sendExplorerReq(t, n, blocksHandler, fmt.Sprintf("%s?limit=%d", endpointBlocks, maxBlocksLimit+1), http.StatusBadRequest, &ErrRes{})
+
+	txRes := TxRes{}
+	sendExplorerReq(t, n, txHandler, endpointTx+minedTxHash.Hex(), http.StatusOK, &txRes)
+	if txRes.Pending || txRes.Confirmations != 1 || txRes.BlockHash != latestHash {
+		t.Fatalf("the mined TX should have 1 confirmation, got %+v", txRes)
+	}
+
+	txRes = TxRes{}
+	sendExplorerReq(t, n, txHandler, endpointTx+pendingTxHash.Hex(), http.StatusOK, &txRes)
+	if !txRes.Pending || txRes.Confirmations != 0 {
+		t.Fatalf("the pending TX should be served as pending, got %+v", txRes)
+	}
+
+	proofRes := TxProofRes{}
+	sendExplorerReq(t, n, txHandler, endpointTx+minedTxHash.Hex()+endpointProofSuffix, http.StatusOK, &proofRes)
+	if proofRes.BlockHash != latestHash {
+		t.Fatalf("the TX proof should be of the mined block, got '%x'", proofRes.BlockHash)
+	}
+
+	sendExplorerReq(t, n, txHandler, endpointTx+"nope", http.StatusBadRequest, &ErrRes{})
+	sendExplorerReq(t, n, txHandler, endpointTx+latestHash.Hex(), http.StatusNotFound, &ErrRes{})
+	sendExplorerReq(t, n, txHandler, endpointTx+pendingTxHash.Hex()+endpointProofSuffix, http.StatusNotFound, &ErrRes{})
+
+	accountRes := AccountRes{}
+	sendExplorerReq(t, n, accountHandler, endpointAccount+babaYaga.Hex(), http.StatusOK, &accountRes)
+	if accountRes.Balance != 10 || len(accountRes.TXs) != 1 || accountRes.TXs[0].Hash != minedTxHash {
+		t.Fatalf("babaYaga should own the 10 tokens of the mined TX, got %+v", accountRes)
+	}
+
+	accountProofRes := AccountProofRes{}
+	sendExplorerReq(t, n, accountHandler, endpointAccount+babaYaga.Hex()+endpointProofSuffix, http.StatusOK, &accountProofRes)
+	if accountProofRes.BlockHash != latestHash {
+		t.Fatalf("the account proof should be as of the latest block, got '%x'", accountProofRes.BlockHash)
+	}
+
+	sendExplorerReq(t, n, accountHandler, endpointAccount+"babayaga", http.StatusBadRequest, &ErrRes{})
+	sendExplorerReq(t, n, accountHandler, endpointAccount+babaYaga.Hex()+endpointProofSuffix+"?block=nope", http.StatusBadRequest, &ErrRes{})
+	sendExplorerReq(t, n, accountHandler, endpointAccount+babaYaga.Hex()+endpointProofSuffix+"?block="+minedTxHash.Hex(), http.StatusNotFound, &ErrRes{})
+
+	// The explorer is read only
+	req := httptest.NewRequest(http.MethodPost, endpointBlock+latestHash.Hex(), nil)
+	rec := httptest.NewRecorder()
+	explorerHandler(blockHandler, n)(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST %s: expected HTTP status %d, got %d", req.URL.Path, http.StatusMethodNotAllowed, rec.Code)
+	}
+}
+
+func sendExplorerReq(t *testing.T, n *Node, handler func(http.ResponseWriter, *http.Request, *Node), path string, expectedStatus int, res interface{}) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	rec := httptest.NewRecorder()
+	handler(rec, req, n)
+
+	if rec.Code != expectedStatus {
+		t.Fatalf("GET %s: expected HTTP status %d, got %d: %s", path, expectedStatus, rec.Code, rec.Body.String())
+	}
+
+	err := json.Unmarshal(rec.Body.Bytes(), res)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
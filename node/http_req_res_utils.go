@@ -2,15 +2,32 @@ package node
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+
+	"github.com/paulcockrell/blockchain/database"
 )
 
 func writeErrRes(w http.ResponseWriter, err error) {
+	writeErrResWithStatus(w, err, http.StatusInternalServerError)
+}
+
+// writeLookupErrRes answers 404 when the looked up block, TX or account is unknown.
+func writeLookupErrRes(w http.ResponseWriter, err error) {
+	if errors.Is(err, database.ErrNotFound) {
+		writeErrResWithStatus(w, err, http.StatusNotFound)
+		return
+	}
+
+	writeErrRes(w, err)
+}
+
+func writeErrResWithStatus(w http.ResponseWriter, err error, status int) {
 	jsonErrRes, _ := json.Marshal(ErrRes{err.Error()})
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusInternalServerError)
+	w.WriteHeader(status)
 	w.Write(jsonErrRes)
 }
 
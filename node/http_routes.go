@@ -2,8 +2,10 @@ package node
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/paulcockrell/blockchain/database"
@@ -24,6 +26,7 @@ type TxAddReq struct {
 	FromPwd string `json:"from_pwd"`
 	To      string `json:"to"`
 	Value   uint   `json:"value"`
+	Fee     uint   `json:"fee"`
 	Data    string `json:"data"`
 }
 
@@ -31,11 +34,24 @@ type TxAddRes struct {
 	Success bool `json:"success"`
 }
 
+type TxSubmitRes struct {
+	Success bool          `json:"success"`
+	Hash    database.Hash `json:"tx_hash"`
+}
+
+type TxFeeEstimateRes struct {
+	Fee        uint `json:"fee"`
+	PendingTXs int  `json:"pending_txs"`
+}
+
 type StatusRes struct {
-	Hash       database.Hash       `json:"block_hash"`
-	Number     uint64              `json:"block_number"`
-	KnownPeers map[string]PeerNode `json:"peers_known"`
-	PendingTXs []database.SignedTx `json:"pending_txs"`
+	GenesisHash database.Hash       `json:"genesis_hash"`
+	Hash        database.Hash       `json:"block_hash"`
+	Number      uint64              `json:"block_number"`
+	KnownPeers  map[string]PeerNode `json:"peers_known"`
+	PendingTXs  []database.SignedTx `json:"pending_txs"`
+	IsMining    bool                `json:"is_mining"`
+	Hashrate    float64             `json:"hashrate"`
 }
 
 type SyncRes struct {
@@ -47,8 +63,44 @@ type AddPeerRes struct {
 	Error   string `json:"error"`
 }
 
+type BlockGossipReq struct {
+	From        PeerNode       `json:"from"`
+	GenesisHash database.Hash  `json:"genesis_hash"`
+	Block       database.Block `json:"block"`
+}
+
+type TxGossipReq struct {
+	From        PeerNode          `json:"from"`
+	GenesisHash database.Hash     `json:"genesis_hash"`
+	TX          database.SignedTx `json:"tx"`
+}
+
+type GossipRes struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error"`
+}
+
+// WorkRes is the header of the block an external miner should find a nonce for.
+type WorkRes struct {
+	ID     database.Hash        `json:"id"`
+	Header database.BlockHeader `json:"header"`
+}
+
+type SubmitWorkReq struct {
+	ID         database.Hash `json:"id"`
+	Nonce      uint32        `json:"nonce"`
+	ExtraNonce uint64        `json:"extra_nonce"`
+}
+
+type SubmitWorkRes struct {
+	Success bool          `json:"success"`
+	Hash    database.Hash `json:"block_hash"`
+}
+
 func listBalancesHandler(w http.ResponseWriter, r *http.Request, state *database.State) {
-	writeRes(w, BalancesRes{state.LatestBlockHash(), state.Balances})
+	hash, balances := state.LatestBalances()
+
+	writeRes(w, BalancesRes{hash, balances})
 }
 
 func txAddHandler(w http.ResponseWriter, r *http.Request, node *Node) {
@@ -71,14 +123,15 @@ func txAddHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 		return
 	}
 
-	nonce := node.state.GetNextAccountNonce(from)
+	nonce := node.getNextPendingNonce(from)
 
-	tx := database.NewTx(
+	tx := database.NewTxWithFee(
 		from,
 		database.NewAccount(req.To),
 		req.Value,
 		nonce,
 		req.Data,
+		req.Fee,
 	)
 
 	signedTx, err := wallet.SignTxWithKeystoreAccount(
@@ -101,28 +154,68 @@ func txAddHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 	writeRes(w, TxAddRes{Success: true})
 }
 
-func statusHandler(w http.ResponseWriter, r *http.Request, node *Node) {
-	res := StatusRes{
-		Hash:       node.state.LatestBlockHash(),
-		Number:     node.state.LatestBlock().Header.Number,
-		KnownPeers: node.knownPeers,
-		PendingTXs: node.getPendingTXsAsArray(),
+// txSubmitHandler admits a TX signed by the client, the node never sees its keys.
+func txSubmitHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	tx := database.SignedTx{}
+	err := readReq(r, &tx)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	txHash, err := node.SubmitTX(tx)
+	if err != nil {
+		writeErrRes(w, err)
+		return
 	}
 
-	writeRes(w, res)
+	writeRes(w, TxSubmitRes{Success: true, Hash: txHash})
+}
+
+func txFeeEstimateHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	fee, err := node.estimateFee()
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, TxFeeEstimateRes{fee, len(node.getPendingTXsAsArray())})
+}
+
+func statusHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	writeRes(w, newStatusRes(node))
+}
+
+func newStatusRes(node *Node) StatusRes {
+	return StatusRes{
+		GenesisHash: node.state.GenesisHash(),
+		Hash:        node.state.LatestBlockHash(),
+		Number:      node.state.LatestBlock().Header.Number,
+		KnownPeers:  node.getKnownPeers(),
+		PendingTXs:  node.getPendingTXsAsArray(),
+		IsMining:    node.IsMining(),
+		Hashrate:    node.Hashrate(),
+	}
 }
 
 func syncHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 	reqHash := r.URL.Query().Get(endpointSyncQueryKeyFromBlock)
+	reqGenesis := r.URL.Query().Get(endpointSyncQueryKeyGenesis)
+
+	err := checkGenesisHash(reqGenesis, node.state.GenesisHash())
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
 
 	hash := database.Hash{}
-	err := hash.UnmarshalText([]byte(reqHash))
+	err = hash.UnmarshalText([]byte(reqHash))
 	if err != nil {
 		writeErrRes(w, err)
 		return
 	}
 
-	blocks, err := database.GetBlocksAfter(hash, node.dataDir)
+	blocks, err := node.state.GetBlocksAfter(hash, maxSyncBlocks)
 	if err != nil {
 		writeErrRes(w, err)
 		return
@@ -135,6 +228,7 @@ func addPeerHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 	peerIP := r.URL.Query().Get(endpointAddPeerQueryKeyIP)
 	peerPortRaw := r.URL.Query().Get(endpointAddPeerQueryKeyPort)
 	minerRaw := r.URL.Query().Get(endpointAddPeerQueryKeyMiner)
+	genesisRaw := r.URL.Query().Get(endpointAddPeerQueryKeyGenesis)
 
 	peerPort, err := strconv.ParseUint(peerPortRaw, 10, 32)
 	if err != nil {
@@ -142,6 +236,12 @@ func addPeerHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 		return
 	}
 
+	err = checkGenesisHash(genesisRaw, node.state.GenesisHash())
+	if err != nil {
+		writeRes(w, AddPeerRes{false, err.Error()})
+		return
+	}
+
 	peer := NewPeerNode(
 		peerIP,
 		peerPort,
@@ -149,8 +249,160 @@ func addPeerHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 		database.NewAccount(minerRaw),
 		true,
 	)
+
+	if node.isBannedPeer(peer) {
+		writeRes(w, AddPeerRes{false, fmt.Sprintf("peer '%s' is banned", peer.TcpAddress())})
+		return
+	}
+
+	// Only peers answering at the address they claim, on the same chain, are added
+	start := time.Now()
+	status, err := queryPeerStatus(peer)
+	if err != nil {
+		writeRes(w, AddPeerRes{false, fmt.Sprintf("peer '%s' is unreachable. %s", peer.TcpAddress(), err.Error())})
+		return
+	}
+
+	if status.GenesisHash != node.state.GenesisHash() {
+		writeRes(w, AddPeerRes{false, fmt.Sprintf("peer '%s' runs a chain with a different genesis '%s'", peer.TcpAddress(), status.GenesisHash.Hex())})
+		return
+	}
+
 	node.AddPeer(peer)
+	node.recordPeerQuery(peer, time.Since(start), nil)
 	fmt.Printf("Peer '%s' was added to known peers\n", peer.TcpAddress())
 
 	writeRes(w, AddPeerRes{true, ""})
 }
+
+// checkGenesisHash refuses peers running a different chain. Peers that don't
+// send their genesis hash are let through.
+func checkGenesisHash(peerGenesisRaw string, genesisHash database.Hash) error {
+	if peerGenesisRaw == "" {
+		return nil
+	}
+
+	peerGenesis := database.Hash{}
+	err := peerGenesis.UnmarshalText([]byte(peerGenesisRaw))
+	if err != nil {
+		return err
+	}
+
+	if peerGenesis != genesisHash {
+		return fmt.Errorf("genesis hash '%s' doesn't match this node's '%s'", peerGenesis.Hex(), genesisHash.Hex())
+	}
+
+	return nil
+}
+
+func blockGossipHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	req := BlockGossipReq{}
+	err := readReq(r, &req)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	err = checkGenesisHash(req.GenesisHash.Hex(), node.state.GenesisHash())
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	from, err := gossipSender(r, req.From, node)
+	if err != nil {
+		writeErrResWithStatus(w, err, http.StatusForbidden)
+		return
+	}
+
+	err = node.addGossipedBlock(req.Block, from)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, GossipRes{Success: true})
+}
+
+// gossipSender returns the peer a gossip request comes from. The peer it
+// claims to be must run on the host the request comes from, so a peer can't be
+// scored nor banned for what another one sent, and banned hosts are refused.
+func gossipSender(r *http.Request, from PeerNode, node *Node) (PeerNode, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return PeerNode{}, err
+	}
+
+	if !isSameHost(from.IP, host) {
+		return PeerNode{}, fmt.Errorf("peer '%s' doesn't run on '%s' the gossip comes from", from.TcpAddress(), host)
+	}
+
+	// Local nodes all share the loopback host, only the banned one is refused
+	isBanned := node.isBannedHost(host)
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		isBanned = node.isBannedPeer(from)
+	}
+
+	if isBanned {
+		return PeerNode{}, fmt.Errorf("peer '%s' is banned", from.TcpAddress())
+	}
+
+	return from, nil
+}
+
+func txGossipHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	req := TxGossipReq{}
+	err := readReq(r, &req)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	err = checkGenesisHash(req.GenesisHash.Hex(), node.state.GenesisHash())
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	from, err := gossipSender(r, req.From, node)
+	if err != nil {
+		writeErrResWithStatus(w, err, http.StatusForbidden)
+		return
+	}
+
+	// AddPendingTX ignores known TXs, only new ones get relayed
+	err = node.AddPendingTX(req.TX, from)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, GossipRes{Success: true})
+}
+
+func getWorkHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	work, err := node.getWork()
+	if err != nil {
+		writeErrResWithStatus(w, err, http.StatusServiceUnavailable)
+		return
+	}
+
+	writeRes(w, work)
+}
+
+func submitWorkHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	req := SubmitWorkReq{}
+	err := readReq(r, &req)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	hash, err := node.submitWork(req)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, SubmitWorkRes{Success: true, Hash: hash})
+}
@@ -0,0 +1,175 @@
+package node
+
+import (
+	"bytes"
+	"container/heap"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/paulcockrell/blockchain/database"
+)
+
+// How many recent blocks the fee estimate looks at
+const feeEstimateBlocks = 10
+
+// orderTXsByFee sorts TXs for mining, highest fee first, while keeping the
+// TXs of every sender in nonce order. TXs that can't be mined next, because
+// their nonce was already used or a previous one is missing, are left out.
+func orderTXsByFee(txs []database.SignedTx, nextNonce func(common.Address) uint) []database.SignedTx {
+	bySender := make(map[common.Address][]database.SignedTx)
+	for _, tx := range txs {
+		bySender[tx.From] = append(bySender[tx.From], tx)
+	}
+
+	queues := make(txQueues, 0, len(bySender))
+	for sender, senderTXs := range bySender {
+		sort.Slice(senderTXs, func(i, j int) bool {
+			return senderTXs[i].Nonce < senderTXs[j].Nonce
+		})
+
+		queue := make([]database.SignedTx, 0, len(senderTXs))
+		expectedNonce := nextNonce(sender)
+		for _, tx := range senderTXs {
+			if tx.Nonce != expectedNonce {
+				continue
+			}
+
+			queue = append(queue, tx)
+			expectedNonce++
+		}
+
+		if len(queue) > 0 {
+			queues = append(queues, queue)
+		}
+	}
+
+	heap.Init(&queues)
+
+	ordered := make([]database.SignedTx, 0, len(txs))
+	for queues.Len() > 0 {
+		queue := queues[0]
+		ordered = append(ordered, queue[0])
+
+		if len(queue) == 1 {
+			heap.Pop(&queues)
+		} else {
+			queues[0] = queue[1:]
+			heap.Fix(&queues, 0)
+		}
+	}
+
+	return ordered
+}
+
+// txQueues is a heap of per sender TX queues, ordered by the fee of their next TX.
+type txQueues [][]database.SignedTx
+
+func (q txQueues) Len() int {
+	return len(q)
+}
+
+func (q txQueues) Less(i, j int) bool {
+	a, b := q[i][0], q[j][0]
+
+	if a.Fee != b.Fee {
+		return a.Fee > b.Fee
+	}
+
+	if a.Time != b.Time {
+		return a.Time < b.Time
+	}
+
+	return bytes.Compare(a.From[:], b.From[:]) < 0
+}
+
+func (q txQueues) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *txQueues) Push(x interface{}) {
+	*q = append(*q, x.([]database.SignedTx))
+}
+
+func (q *txQueues) Pop() interface{} {
+	old := *q
+	queue := old[len(old)-1]
+	*q = old[:len(old)-1]
+
+	return queue
+}
+
+// getPendingTXsByFee returns the pending TXs in the order they should be mined.
+func (n *Node) getPendingTXsByFee() []database.SignedTx {
+	return orderTXsByFee(n.getPendingTXsAsArray(), n.state.GetNextAccountNonce)
+}
+
+// getNextPendingNonce is the account's next nonce, counting its pending TXs.
+func (n *Node) getNextPendingNonce(account common.Address) uint {
+	nonces := make([]uint, 0)
+	for _, tx := range n.getPendingTXsAsArray() {
+		if tx.From == account {
+			nonces = append(nonces, tx.Nonce)
+		}
+	}
+
+	sort.Slice(nonces, func(i, j int) bool {
+		return nonces[i] < nonces[j]
+	})
+
+	next := n.state.GetNextAccountNonce(account)
+	for _, nonce := range nonces {
+		if nonce == next {
+			next++
+		}
+	}
+
+	return next
+}
+
+// estimateFee suggests a fee for a TX to be mined in the next block: the
+// median fee of the recent blocks, or more if the pending TXs don't all fit in
+// a block and the TX has to outbid the cheapest one still making it.
+func (n *Node) estimateFee() (uint, error) {
+	from := uint64(0)
+	if next := n.state.NextBlockNumber(); next > feeEstimateBlocks {
+		from = next - feeEstimateBlocks
+	}
+
+	blocks, err := n.state.GetBlocks(from, feeEstimateBlocks)
+	if err != nil {
+		return 0, err
+	}
+
+	fees := make([]uint, 0)
+	for _, b := range blocks {
+		for _, tx := range b.TXs {
+			fees = append(fees, tx.Fee)
+		}
+	}
+
+	estimate := uint(0)
+	if len(fees) > 0 {
+		sort.Slice(fees, func(i, j int) bool {
+			return fees[i] < fees[j]
+		})
+		estimate = fees[len(fees)/2]
+	}
+
+	pendingTXs := n.getPendingTXsByFee()
+	nextBlock := NewPendingBlock(
+		n.state.LatestBlockHash(),
+		n.state.NextBlockNumber(),
+		n.info.Account,
+		n.state.NextBlockDifficulty(),
+		pendingTXs,
+	)
+
+	fitting := fitTXsInBlock(nextBlock, n.state.Genesis().MaxBlockSize)
+	if len(fitting) > 0 && len(fitting) < len(pendingTXs) {
+		if outbid := fitting[len(fitting)-1].Fee + 1; outbid > estimate {
+			estimate = outbid
+		}
+	}
+
+	return estimate, nil
+}
@@ -0,0 +1,54 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/paulcockrell/blockchain/database"
+)
+
+func TestOrderTXsByFee(t *testing.T) {
+	alice := database.NewAccount("0x00000000000000000000000000000000000000a1")
+	bob := database.NewAccount("0x00000000000000000000000000000000000000b0")
+	carol := database.NewAccount("0x00000000000000000000000000000000000000c0")
+
+	newTx := func(from common.Address, fee, nonce uint) database.SignedTx {
+		return database.NewSignedTx(database.NewTxWithFee(from, carol, 1, nonce, "", fee), nil)
+	}
+
+	txs := []database.SignedTx{
+		newTx(alice, 50, 3),
+		newTx(alice, 1, 2),
+		newTx(bob, 100, 5),
+		newTx(bob, 10, 3),
+		newTx(bob, 5, 4),
+		newTx(carol, 20, 1),
+	}
+
+	nextNonce := map[common.Address]uint{alice: 2, bob: 3, carol: 2}
+	ordered := orderTXsByFee(txs, func(acc common.Address) uint {
+		return nextNonce[acc]
+	})
+
+	// Carol's TX reuses a mined nonce. Alice's fee 50 TX waits for her fee 1 one.
+	expected := []struct {
+		from  common.Address
+		nonce uint
+	}{
+		{bob, 3},
+		{bob, 4},
+		{bob, 5},
+		{alice, 2},
+		{alice, 3},
+	}
+
+	if len(ordered) != len(expected) {
+		t.Fatalf("expected %d TXs, got %d", len(expected), len(ordered))
+	}
+
+	for i, tx := range ordered {
+		if tx.From != expected[i].from || tx.Nonce != expected[i].nonce {
+			t.Fatalf("TX %d should be %s nonce %d, not %s nonce %d", i, expected[i].from.Hex(), expected[i].nonce, tx.From.Hex(), tx.Nonce)
+		}
+	}
+}
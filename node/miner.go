@@ -2,82 +2,99 @@ package node
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"math/rand"
+	"math"
+	"runtime"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/paulcockrell/blockchain/consensus"
 	"github.com/paulcockrell/blockchain/database"
-	"github.com/paulcockrell/blockchain/fs"
 )
 
 type PendingBlock struct {
-	parent database.Hash
-	number uint64
-	time   uint64
-	miner  common.Address
-	txs    []database.SignedTx
+	parent     database.Hash
+	number     uint64
+	time       uint64
+	miner      common.Address
+	difficulty uint64
+	txs        []database.SignedTx
+
+	// stateRoot is the state once txs are applied, see database.State.NextStateRoot
+	stateRoot database.Hash
 }
 
-func NewPendingBlock(hash database.Hash, number uint64, miner common.Address, txs []database.SignedTx) PendingBlock {
+func NewPendingBlock(hash database.Hash, number uint64, miner common.Address, difficulty uint64, txs []database.SignedTx) PendingBlock {
 	return PendingBlock{
 		hash,
 		number,
 		uint64(time.Now().Unix()),
 		miner,
+		difficulty,
 		txs,
+		database.Hash{},
 	}
 }
 
+// newBlock returns the block to mine, its nonce still to be found.
+func (pb PendingBlock) newBlock() database.Block {
+	block := database.NewBlock(pb.parent, pb.number, 0, pb.time, pb.miner, pb.difficulty, pb.txs)
+	block.Header.StateRoot = pb.stateRoot
+
+	return block
+}
+
+// Mine searches a valid nonce for the pending block with one worker per CPU.
 func Mine(ctx context.Context, pb PendingBlock) (database.Block, error) {
+	return MineWithWorkers(ctx, pb, runtime.NumCPU(), nil)
+}
+
+// MineWithWorkers mines the pending block by proof of work, see consensus.MineHeader.
+func MineWithWorkers(ctx context.Context, pb PendingBlock, workers int, meter *consensus.HashMeter) (database.Block, error) {
 	if len(pb.txs) == 0 {
 		return database.Block{}, fmt.Errorf("mining empty blocks is not allowed")
 	}
 
-	start := time.Now()
-	attempt := 0
-	var block database.Block
-	var hash database.Hash
-	var nonce uint32
-
-	for !database.IsBlockHashValid(hash) {
-		select {
-		case <-ctx.Done():
-			fmt.Println("Mining cancelled")
-			return database.Block{}, fmt.Errorf("mining cancelled. %s", ctx.Err())
-		default:
-		}
+	fmt.Printf("Mining %d pending txs\n", len(pb.txs))
 
-		attempt++
-		nonce = generateNonce()
+	// Only the nonce changes between attempts, the TX root is computed once
+	block := pb.newBlock()
 
-		if attempt%1000000 == 0 || attempt == 1 {
-			fmt.Printf("Mining %d pending txs. Attempt: %d\n", len(pb.txs), attempt)
-		}
+	header, err := consensus.MineHeader(ctx, block.Header, workers, meter)
+	if err != nil {
+		return database.Block{}, err
+	}
+	block.Header = header
 
-		block = database.NewBlock(pb.parent, pb.number, nonce, pb.time, pb.miner, pb.txs)
-		blockHash, err := block.Hash()
-		if err != nil {
-			return database.Block{}, fmt.Errorf("couldn't mine block. %s", err.Error())
-		}
+	return block, nil
+}
 
-		hash = blockHash
+// fitTXsInBlock returns the leading pb.txs that fit in a block of maxSize bytes.
+func fitTXsInBlock(pb PendingBlock, maxSize uint64) []database.SignedTx {
+	// The widest nonces and a signature give an upper bound of the header size
+	emptyBlock := database.NewBlock(pb.parent, pb.number, math.MaxUint32, pb.time, pb.miner, pb.difficulty, nil)
+	emptyBlock.Header.ExtraNonce = math.MaxUint64
+	emptyBlock.Header.Signature = make([]byte, crypto.SignatureLength)
+	emptyBlockJSON, err := json.Marshal(emptyBlock)
+	if err != nil {
+		return nil
 	}
 
-	fmt.Printf("\nMined new Block '%x' using PoW 🎉🎉🎉 %s:\n", hash, fs.Unicode("\\U1F389"))
-	fmt.Printf("\tHeight: '%v'\n", block.Header.Number)
-	fmt.Printf("\tNonce: '%v'\n", block.Header.Nonce)
-	fmt.Printf("\tCreated: '%v'\n", block.Header.Time)
-	fmt.Printf("\tMiner: '%v'\n", block.Header.Miner.String())
-	fmt.Printf("\tParent: '%v'\n", block.Header.Parent.Hex())
-
-	fmt.Printf("\tAttempt: '%v'\n", attempt)
-	fmt.Printf("\tTime: '%v'\n", time.Since(start))
+	size := uint64(len(emptyBlockJSON))
+	for i, tx := range pb.txs {
+		txJSON, err := json.Marshal(tx)
+		if err != nil {
+			return pb.txs[:i]
+		}
 
-	return block, nil
-}
+		// Each TX adds its JSON and a separating comma
+		size += uint64(len(txJSON)) + 1
+		if size > maxSize {
+			return pb.txs[:i]
+		}
+	}
 
-func generateNonce() uint32 {
-	rand.Seed(time.Now().UTC().UnixNano())
-	return rand.Uint32()
+	return pb.txs
 }
@@ -11,6 +11,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/paulcockrell/blockchain/consensus"
 	"github.com/paulcockrell/blockchain/database"
 	"github.com/paulcockrell/blockchain/wallet"
 )
@@ -23,19 +24,19 @@ func TestValidBlockHash(t *testing.T) {
 	hex.Decode(hash[:], []byte(hexHash))
 
 	// validate hash
-	isValid := database.IsBlockHashValid(hash)
+	isValid := database.IsBlockHashValid(hash, database.DefaultDifficulty)
 	if !isValid {
-		t.Fatalf("hash %q with 6 zeroes should be valid", hexHash)
+		t.Fatalf("hash %q with 6 zeroes should be valid at the default difficulty", hexHash)
 	}
 }
 
-func TextInvalidBlockHash(t *testing.T) {
+func TestInvalidBlockHash(t *testing.T) {
 	hexHash := "000001fa04f8160395c387277f8b2f14837603383d33809a4db586086168edfa"
 	var hash = database.Hash{}
 
 	hex.Decode(hash[:], []byte(hexHash))
 
-	isValid := database.IsBlockHashValid(hash)
+	isValid := database.IsBlockHashValid(hash, database.DefaultDifficulty)
 	if isValid {
 		t.Fatal("hash is not supposed to be valid")
 	}
@@ -64,7 +65,7 @@ func TestMine(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if !database.IsBlockHashValid(minedBlockHash) {
+	if !database.IsBlockHashValid(minedBlockHash, minedBlock.Header.Difficulty) {
 		t.Fatal()
 	}
 
@@ -73,6 +74,38 @@ func TestMine(t *testing.T) {
 	}
 }
 
+func TestMineWithWorkers(t *testing.T) {
+	minerPrivKey, _, miner, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pendingBlock, err := createRandomPendingBlock(minerPrivKey, miner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pendingBlock.difficulty = 1 << 12
+
+	meter := &consensus.HashMeter{}
+	minedBlock, err := MineWithWorkers(context.Background(), pendingBlock, 4, meter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	minedBlockHash, err := minedBlock.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !database.IsBlockHashValid(minedBlockHash, minedBlock.Header.Difficulty) {
+		t.Fatalf("mined block hash %x isn't valid", minedBlockHash)
+	}
+
+	if meter.Hashes() == 0 || meter.Rate() == 0 {
+		t.Fatalf("expected the meter to count hashes, got %d at %f H/s", meter.Hashes(), meter.Rate())
+	}
+}
+
 func TestMineWithTimeout(t *testing.T) {
 	minerPrivKey, _, miner, err := generateKey()
 	if err != nil {
@@ -84,7 +117,8 @@ func TestMineWithTimeout(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	ctx, _ := context.WithTimeout(context.Background(), time.Microsecond*100)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Microsecond*100)
+	defer cancel()
 
 	_, err = Mine(ctx, pendingBlock)
 	if err == nil {
@@ -117,6 +151,7 @@ func createRandomPendingBlock(privKey *ecdsa.PrivateKey, acc common.Address) (Pe
 		database.Hash{},
 		0,
 		acc,
+		database.DefaultDifficulty,
 		[]database.SignedTx{signedTx},
 	), nil
 }
@@ -2,12 +2,16 @@ package node
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"runtime"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/paulcockrell/blockchain/consensus"
 	"github.com/paulcockrell/blockchain/database"
 	"github.com/paulcockrell/blockchain/wallet"
 )
@@ -22,14 +26,49 @@ const endpointStatus = "/node/status"
 
 const endpointSync = "/node/sync"
 const endpointSyncQueryKeyFromBlock = "fromBlock"
+const endpointSyncQueryKeyGenesis = "genesis"
 
 const endpointAddPeer = "/node/peer"
 const endpointAddPeerQueryKeyIP = "ip"
 const endpointAddPeerQueryKeyPort = "port"
 const endpointAddPeerQueryKeyMiner = "miner"
+const endpointAddPeerQueryKeyGenesis = "genesis"
+
+const endpointAdminPeers = "/admin/peers"
+const endpointAdminAddPeer = "/admin/peers/add"
+const endpointAdminRemovePeer = "/admin/peers/remove"
+const endpointAdminUnbanPeer = "/admin/peers/unban"
+
+const endpointWork = "/miner/work"
+const endpointSubmitWork = "/miner/submit"
+
+const endpointBlockGossip = "/node/block"
+const endpointTXGossip = "/node/tx"
+
+const endpointBlock = "/block/"
+const endpointBlockByNumber = "/block/number/"
+const endpointBlocks = "/blocks"
+const endpointBlocksQueryKeyFrom = "from"
+const endpointBlocksQueryKeyLimit = "limit"
+const endpointTx = "/tx/"
+const endpointTxFeeEstimate = "/tx/fee-estimate"
+const endpointAccount = "/account/"
+const endpointAccountProofQueryKeyBlock = "block"
+const endpointProofSuffix = "/proof"
+
+const endpointRPC = "/rpc"
+
+const defaultBlocksLimit = 10
+const maxBlocksLimit = 100
+
+// Blocks served per sync request, the syncing node asks again for the rest
+const maxSyncBlocks = 500
 
 const miningIntervalSeconds = 10
 
+// Synced blocks waiting for the miner, see notifySyncedBlock
+const syncedBlocksBufferSize = 100
+
 type PeerNode struct {
 	IP          string         `json:"ip"`
 	Port        uint64         `json:"port"`
@@ -45,31 +84,60 @@ func (pn PeerNode) TcpAddress() string {
 }
 
 type Node struct {
-	dataDir string
-	info    PeerNode
+	dataDir      string
+	blockStore   string
+	minerWorkers int
+	isDev        bool
+	info         PeerNode
 
 	state           *database.State
-	knownPeers      map[string]PeerNode
+	peers           map[string]*PeerRecord
 	pendingTXs      map[string]database.SignedTx
 	archivedTXs     map[string]database.SignedTx
 	newSyncedBlocks chan database.Block
 	newPendingTXs   chan database.SignedTx
 	isMining        bool
+	hashMeter       *consensus.HashMeter
+	engine          consensus.Engine
+	signerKey       *ecdsa.PrivateKey
+	// sealNow starts mining right away, without waiting for the ticker, see EnableDevMode
+	sealNow chan struct{}
+
+	// lock guards peers, pendingTXs, archivedTXs and isMining, which the
+	// HTTP handlers, the sync and the mine goroutines all share.
+	// The state is safe for concurrent use on its own.
+	lock sync.RWMutex
+
+	// work holds the blocks handed to external miners by their ID, oldest first in workOrder
+	work           map[database.Hash]database.Block
+	workOrder      []database.Hash
+	latestWork     database.Hash
+	latestWorkTime time.Time
+	workLock       sync.Mutex
+
+	// stateLoaded is closed once Run loaded the state from disk
+	stateLoaded chan struct{}
 }
 
 func New(dataDir string, ip string, port uint64, acc common.Address, bootstrap PeerNode) *Node {
-	knownPeers := make(map[string]PeerNode)
-	knownPeers[bootstrap.TcpAddress()] = bootstrap
+	peers := make(map[string]*PeerRecord)
+	peers[bootstrap.TcpAddress()] = &PeerRecord{Peer: bootstrap}
 
 	return &Node{
 		dataDir:         dataDir,
+		blockStore:      database.BlockStoreFile,
+		minerWorkers:    runtime.NumCPU(),
 		info:            NewPeerNode(ip, port, false, acc, true),
-		knownPeers:      knownPeers,
+		peers:           peers,
 		pendingTXs:      make(map[string]database.SignedTx),
 		archivedTXs:     make(map[string]database.SignedTx),
-		newSyncedBlocks: make(chan database.Block),
+		newSyncedBlocks: make(chan database.Block, syncedBlocksBufferSize),
 		newPendingTXs:   make(chan database.SignedTx, 10000),
 		isMining:        false,
+		hashMeter:       &consensus.HashMeter{},
+		sealNow:         make(chan struct{}, 1),
+		work:            make(map[database.Hash]database.Block),
+		stateLoaded:     make(chan struct{}),
 	}
 }
 
@@ -77,45 +145,132 @@ func NewPeerNode(ip string, port uint64, isBootstrap bool, acc common.Address, c
 	return PeerNode{ip, port, isBootstrap, acc, connected}
 }
 
+// UseBlockStore sets the kind of block store Run loads the state from, see database.OpenBlockStore.
+func (n *Node) UseBlockStore(kind string) {
+	n.blockStore = kind
+}
+
+// SetMinerWorkers sets how many goroutines mine a block in parallel, one per CPU by default.
+func (n *Node) SetMinerWorkers(workers int) {
+	n.minerWorkers = workers
+}
+
+// SetSignerKey sets the key signing blocks when the chain uses proof of authority.
+func (n *Node) SetSignerKey(key *ecdsa.PrivateKey) {
+	n.signerKey = key
+}
+
+// Hashrate returns the hashes per second of the current, or last, mining run.
+func (n *Node) Hashrate() float64 {
+	return n.hashMeter.Rate()
+}
+
 func (n *Node) Run(ctx context.Context) error {
 	fmt.Println(fmt.Sprintf("Listening on: %s:%d", n.info.IP, n.info.Port))
 
-	state, err := database.NewStateFromDisk(n.dataDir)
+	if n.isDev {
+		err := initDevDataDir(n.dataDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	sealConfig := consensus.SealConfig{Workers: n.minerWorkers, Meter: n.hashMeter, SignerKey: n.signerKey}
+	state, engine, err := consensus.NewState(n.dataDir, n.blockStore, sealConfig)
 	if err != nil {
 		return err
 	}
 	defer state.Close()
 
+	if n.isDev && state.Genesis().ChainID != database.DevChainID {
+		return fmt.Errorf("--dev needs a data dir of its own, '%s' holds the '%s' chain", n.dataDir, state.Genesis().ChainID)
+	}
+
 	n.state = state
+	n.engine = engine
+
+	// The peers can be found again from the bootstrap one, a bad peer book isn't fatal
+	err = n.loadPeers()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+	}
+	close(n.stateLoaded)
 
 	fmt.Println("Blockchain state:")
 	fmt.Printf("	- height: %d\n", n.state.LatestBlock().Header.Number)
 	fmt.Printf("	- hash: %s\n", n.state.LatestBlockHash().Hex())
 
+	if n.state.Genesis().Consensus == database.ConsensusPoA && n.signerKey == nil {
+		fmt.Println("Proof of authority chain without a signer key, this node only verifies blocks.")
+	}
+
 	go n.sync(ctx)
 	go n.mine(ctx)
+	go n.gossip(ctx)
+
+	// Each node gets its own mux, so several nodes can run in one process
+	mux := http.NewServeMux()
 
-	http.HandleFunc("/balances/list", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/balances/list", func(w http.ResponseWriter, r *http.Request) {
 		listBalancesHandler(w, r, state)
 	})
 
-	http.HandleFunc("/tx/add", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/tx/add", func(w http.ResponseWriter, r *http.Request) {
 		txAddHandler(w, r, n)
 	})
 
-	http.HandleFunc(endpointStatus, func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/tx/submit", func(w http.ResponseWriter, r *http.Request) {
+		txSubmitHandler(w, r, n)
+	})
+
+	mux.HandleFunc(endpointTxFeeEstimate, func(w http.ResponseWriter, r *http.Request) {
+		txFeeEstimateHandler(w, r, n)
+	})
+
+	mux.HandleFunc(endpointStatus, func(w http.ResponseWriter, r *http.Request) {
 		statusHandler(w, r, n)
 	})
 
-	http.HandleFunc(endpointSync, func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc(endpointSync, func(w http.ResponseWriter, r *http.Request) {
 		syncHandler(w, r, n)
 	})
 
-	http.HandleFunc(endpointAddPeer, func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc(endpointAddPeer, func(w http.ResponseWriter, r *http.Request) {
 		addPeerHandler(w, r, n)
 	})
 
-	server := &http.Server{Addr: fmt.Sprintf(":%d", n.info.Port)}
+	mux.HandleFunc(endpointBlock, explorerHandler(blockHandler, n))
+	mux.HandleFunc(endpointBlockByNumber, explorerHandler(blockByNumberHandler, n))
+	mux.HandleFunc(endpointBlocks, explorerHandler(blocksHandler, n))
+	mux.HandleFunc(endpointTx, explorerHandler(txHandler, n))
+	mux.HandleFunc(endpointAccount, explorerHandler(accountHandler, n))
+
+	mux.HandleFunc(endpointRPC, func(w http.ResponseWriter, r *http.Request) {
+		rpcHandler(w, r, n)
+	})
+
+	mux.HandleFunc(endpointAdminPeers, adminHandler(listPeersHandler, n))
+	mux.HandleFunc(endpointAdminAddPeer, adminHandler(adminAddPeerHandler, n))
+	mux.HandleFunc(endpointAdminRemovePeer, adminHandler(adminRemovePeerHandler, n))
+	mux.HandleFunc(endpointAdminUnbanPeer, adminHandler(adminUnbanPeerHandler, n))
+
+	mux.HandleFunc(endpointWork, func(w http.ResponseWriter, r *http.Request) {
+		getWorkHandler(w, r, n)
+	})
+
+	mux.HandleFunc(endpointSubmitWork, func(w http.ResponseWriter, r *http.Request) {
+		submitWorkHandler(w, r, n)
+	})
+
+	mux.HandleFunc(endpointBlockGossip, func(w http.ResponseWriter, r *http.Request) {
+		blockGossipHandler(w, r, n)
+	})
+
+	mux.HandleFunc(endpointTXGossip, func(w http.ResponseWriter, r *http.Request) {
+		txGossipHandler(w, r, n)
+	})
+
+	server := &http.Server{Addr: fmt.Sprintf(":%d", n.info.Port), Handler: mux}
 
 	go func() {
 		<-ctx.Done()
@@ -136,68 +291,184 @@ func (n *Node) LatestBlockHash() database.Hash {
 }
 
 func (n *Node) mine(ctx context.Context) error {
-	var miningCtx context.Context
-	var stopCurrentMining context.CancelFunc
+	// Only ever touched from this goroutine, the miner itself just gets the context
+	stopCurrentMining := func() {}
 
 	ticker := time.NewTicker(time.Second * miningIntervalSeconds)
 
+	startMiningPendingTXs := func() {
+		if !n.startMining() {
+			return
+		}
+
+		var miningCtx context.Context
+		miningCtx, stopCurrentMining = context.WithCancel(ctx)
+
+		go func(stop context.CancelFunc) {
+			defer stop()
+
+			err := n.minePendingTXs(miningCtx)
+			if err != nil {
+				fmt.Printf("ERROR: %s\n", err)
+			}
+
+			n.stopMining()
+
+			// TXs which arrived meanwhile, or didn't fit in the block, don't wait for the ticker
+			if err == nil {
+				n.requestSeal()
+			}
+		}(stopCurrentMining)
+	}
+
 	for {
 		select {
 		case <-ticker.C:
-			go func() {
-				if len(n.pendingTXs) > 0 && !n.isMining {
-					n.isMining = true
+			startMiningPendingTXs()
 
-					miningCtx, stopCurrentMining = context.WithCancel(ctx)
-					err := n.minePendingTXs(miningCtx)
-					if err != nil {
-						fmt.Printf("ERROR: %s\n", err)
-					}
-
-					n.isMining = false
-				}
-			}()
+		case <-n.sealNow:
+			startMiningPendingTXs()
 
 		case block, _ := <-n.newSyncedBlocks:
-			if n.isMining {
+			if n.IsMining() {
 				blockHash, _ := block.Hash()
 				fmt.Printf("\nPeer mined next Block '%s' faster :(\n", blockHash.Hex())
 
-				n.removeMinedPendingTXs(block)
 				stopCurrentMining()
 			}
 
+			n.removeMinedPendingTXs(block)
+
 		case <-ctx.Done():
 			ticker.Stop()
+			stopCurrentMining()
 			return nil
 		}
 	}
 }
 
+// startMining flags the node as mining, unless it already is or has nothing to mine.
+func (n *Node) startMining() bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.isMining || len(n.pendingTXs) == 0 {
+		return false
+	}
+
+	n.isMining = true
+
+	return true
+}
+
+func (n *Node) stopMining() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.isMining = false
+}
+
+func (n *Node) IsMining() bool {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	return n.isMining
+}
+
 func (n *Node) minePendingTXs(ctx context.Context) error {
+	blockToMine, err := n.newPendingBlock()
+	if err != nil {
+		return err
+	}
+
+	if len(blockToMine.txs) == 0 {
+		return fmt.Errorf("mining empty blocks is not allowed")
+	}
+
+	fmt.Printf("Sealing %d pending txs\n", len(blockToMine.txs))
+
+	sealedBlock := blockToMine.newBlock()
+	sealedBlock.Header, err = n.engine.Seal(ctx, sealedBlock.Header)
+	if err != nil {
+		return err
+	}
+
+	return n.addMinedBlock(sealedBlock)
+}
+
+// newPendingBlock returns the block of the pending TXs paying the highest fees
+// to mine on top of the head, for the node's own miner or an external one.
+func (n *Node) newPendingBlock() (PendingBlock, error) {
+	header := database.BlockHeader{
+		Parent: n.state.LatestBlockHash(),
+		Number: n.state.NextBlockNumber(),
+		Time:   uint64(time.Now().Unix()),
+		Miner:  n.info.Account,
+	}
+
+	err := n.engine.Prepare(n.state, &header)
+	if err != nil {
+		return PendingBlock{}, err
+	}
+
 	blockToMine := NewPendingBlock(
-		n.state.LatestBlockHash(),
-		n.state.NextBlockNumber(),
-		n.info.Account,
-		n.getPendingTXsAsArray(),
+		header.Parent,
+		header.Number,
+		header.Miner,
+		header.Difficulty,
+		n.getPendingTXsByFee(),
 	)
+	blockToMine.time = header.Time
+	blockToMine.txs = fitTXsInBlock(blockToMine, n.state.Genesis().MaxBlockSize)
 
-	minedBlock, err := Mine(ctx, blockToMine)
+	// A single TX the state refuses would fail every block it's mined in
+	validTXs, invalidTXs, err := n.state.FilterBlockTXs(blockToMine.newBlock())
 	if err != nil {
-		return err
+		return PendingBlock{}, err
 	}
+	n.evictPendingTXs(invalidTXs)
+	blockToMine.txs = validTXs
 
-	n.removeMinedPendingTXs(minedBlock)
+	stateRoot, err := n.state.NextStateRoot(blockToMine.newBlock())
+	if err != nil {
+		return PendingBlock{}, err
+	}
+	blockToMine.stateRoot = stateRoot
+
+	return blockToMine, nil
+}
 
-	_, err = n.state.AddBlock(minedBlock)
+// addMinedBlock imports a block mined by this node, or one of its external
+// miners, and broadcasts it to the peers.
+func (n *Node) addMinedBlock(minedBlock database.Block) error {
+	minedBlockHash, reorg, err := n.state.ImportBlock(minedBlock)
 	if err != nil {
 		return err
 	}
 
+	if reorg != nil {
+		n.restoreReorgedTXs(reorg)
+	}
+
+	n.broadcastBlock(minedBlock, n.info)
+
+	// A block mined on top of a stale head ends up on a side branch,
+	// its TXs are still pending on the canonical chain
+	if minedBlockHash == n.state.LatestBlockHash() {
+		n.removeMinedPendingTXs(minedBlock)
+	}
+
 	return nil
 }
 
 func (n *Node) removeMinedPendingTXs(block database.Block) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.removeMinedPendingTXsLocked(block)
+}
+
+func (n *Node) removeMinedPendingTXsLocked(block database.Block) {
 	if len(block.TXs) > 0 && len(n.pendingTXs) > 0 {
 		fmt.Println("Updating in-memory Pending TXs Pool:")
 	}
@@ -213,12 +484,75 @@ func (n *Node) removeMinedPendingTXs(block database.Block) {
 	}
 }
 
+// evictPendingTXs drops pending TXs which can't be mined anymore.
+func (n *Node) evictPendingTXs(txs []database.SignedTx) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for _, tx := range txs {
+		txHash, _ := tx.Hash()
+		if _, exists := n.pendingTXs[txHash.Hex()]; exists {
+			fmt.Printf("\t-evicting invalid TX: %s\n", txHash.Hex())
+
+			delete(n.pendingTXs, txHash.Hex())
+		}
+	}
+}
+
+// restoreReorgedTXs puts TXs from blocks that left the canonical chain back
+// into the pending pool, unless the new branch already mined them.
+func (n *Node) restoreReorgedTXs(reorg *database.Reorg) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	fmt.Printf("Chain reorganised %d blocks deep, new head '%s'\n", reorg.Depth, reorg.NewHead.Hex())
+
+	for _, block := range reorg.Connected {
+		n.removeMinedPendingTXsLocked(block)
+	}
+
+	minedTXs := make(map[string]struct{})
+	for _, block := range reorg.Connected {
+		for _, tx := range block.TXs {
+			txHash, _ := tx.Hash()
+			minedTXs[txHash.Hex()] = struct{}{}
+		}
+	}
+
+	for _, block := range reorg.Disconnected {
+		for _, tx := range block.TXs {
+			txHash, _ := tx.Hash()
+			if _, isMined := minedTXs[txHash.Hex()]; isMined {
+				continue
+			}
+
+			fmt.Printf("\t-restoring reorged TX: %s\n", txHash.Hex())
+
+			delete(n.archivedTXs, txHash.Hex())
+			n.pendingTXs[txHash.Hex()] = tx
+		}
+	}
+}
+
+// AddPeer adds peer to the known peers, or updates it, keeping its score and ban.
 func (n *Node) AddPeer(peer PeerNode) {
-	n.knownPeers[peer.TcpAddress()] = peer
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if record, isKnown := n.peers[peer.TcpAddress()]; isKnown {
+		record.Peer = peer
+		return
+	}
+
+	n.peers[peer.TcpAddress()] = &PeerRecord{Peer: peer}
 }
 
+// RemovePeer forgets peer, its score and ban included.
 func (n *Node) RemovePeer(peer PeerNode) {
-	delete(n.knownPeers, peer.TcpAddress())
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	delete(n.peers, peer.TcpAddress())
 }
 
 func (n *Node) IsKnownPeer(peer PeerNode) bool {
@@ -226,11 +560,30 @@ func (n *Node) IsKnownPeer(peer PeerNode) bool {
 		return true
 	}
 
-	_, isKnownPeer := n.knownPeers[peer.TcpAddress()]
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	_, isKnownPeer := n.peers[peer.TcpAddress()]
 
 	return isKnownPeer
 }
 
+// getKnownPeers returns a copy of the known peers which aren't banned, safe to
+// iterate while peers come and go.
+func (n *Node) getKnownPeers() map[string]PeerNode {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	peers := make(map[string]PeerNode, len(n.peers))
+	for addr, record := range n.peers {
+		if !record.IsBanned() {
+			peers[addr] = record.Peer
+		}
+	}
+
+	return peers
+}
+
 func (n *Node) AddPendingTX(tx database.SignedTx, fromPeer PeerNode) error {
 	txHash, err := tx.Hash()
 	if err != nil {
@@ -242,19 +595,73 @@ func (n *Node) AddPendingTX(tx database.SignedTx, fromPeer PeerNode) error {
 		return err
 	}
 
+	n.lock.Lock()
 	_, isAlreadyPending := n.pendingTXs[txHash.Hex()]
 	_, isArchived := n.archivedTXs[txHash.Hex()]
 
-	if !isAlreadyPending && !isArchived {
-		fmt.Printf("Added Pending TX %s from Peer %s\n", txJson, fromPeer.TcpAddress())
-		n.pendingTXs[txHash.Hex()] = tx
-		n.newPendingTXs <- tx
+	if isAlreadyPending || isArchived {
+		n.lock.Unlock()
+		return nil
+	}
+
+	// Only TXs the state accepts, after the sender's other pending TXs, are
+	// pooled and relayed, a forged or unfunded TX goes no further than this node
+	err = n.state.ValidatePendingTX(tx, n.getPendingTXsFromLocked(tx.From))
+	if err != nil {
+		n.lock.Unlock()
+		return err
 	}
 
+	fmt.Printf("Added Pending TX %s from Peer %s\n", txJson, fromPeer.TcpAddress())
+	n.pendingTXs[txHash.Hex()] = tx
+	n.lock.Unlock()
+
+	n.newPendingTXs <- tx
+	n.requestSeal()
+
 	return nil
 }
 
+// SubmitTX adds a TX signed by a client to the pending TXs, see AddPendingTX.
+func (n *Node) SubmitTX(tx database.SignedTx) (database.Hash, error) {
+	txHash, err := tx.Hash()
+	if err != nil {
+		return database.Hash{}, err
+	}
+
+	err = n.AddPendingTX(tx, n.info)
+	if err != nil {
+		return database.Hash{}, err
+	}
+
+	return txHash, nil
+}
+
+func (n *Node) getPendingTX(txHash database.Hash) (database.SignedTx, bool) {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	tx, isPending := n.pendingTXs[txHash.Hex()]
+
+	return tx, isPending
+}
+
+// getPendingTXsFromLocked returns the pending TXs of account, n.lock must be held.
+func (n *Node) getPendingTXsFromLocked(account common.Address) []database.SignedTx {
+	txs := make([]database.SignedTx, 0)
+	for _, tx := range n.pendingTXs {
+		if tx.From == account {
+			txs = append(txs, tx)
+		}
+	}
+
+	return txs
+}
+
 func (n *Node) getPendingTXsAsArray() []database.SignedTx {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
 	txs := make([]database.SignedTx, len(n.pendingTXs))
 
 	i := 0
@@ -3,10 +3,13 @@ package node
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -46,10 +49,12 @@ func TestNode_Run(t *testing.T) {
 
 	n := New(datadir, "127.0.0.1", 8085, database.NewAccount(DefaultMiner), PeerNode{})
 
-	ctx, _ := context.WithTimeout(context.Background(), time.Second*5)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
 	err = n.Run(ctx)
-	if err.Error() != "http: Server closed" {
-		t.Fatal("node server was suppose to close after 5s")
+	if err != nil {
+		t.Fatalf("node server was suppose to close cleanly after 5s. %s", err)
 	}
 }
 
@@ -79,6 +84,7 @@ func TestNode_Mining(t *testing.T) {
 		context.Background(),
 		time.Minute*30,
 	)
+	defer closeNode()
 
 	// Schedule a new TX in 3 seconds from now, in a separate thread
 	// because the n.Run() few lines below is a blocking call
@@ -111,6 +117,8 @@ func TestNode_Mining(t *testing.T) {
 	}()
 
 	go func() {
+		<-n.stateLoaded
+
 		// Periodically check if we mined the 2 blocks
 		ticker := time.NewTicker(10 * time.Second)
 
@@ -133,6 +141,148 @@ func TestNode_Mining(t *testing.T) {
 	}
 }
 
+func TestNode_DevMode(t *testing.T) {
+	dataDir, err := getTestDataDirPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.RemoveDir(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveDir(dataDir)
+
+	devAccount := database.NewAccount(wallet.DevAccount)
+	babaYaga := database.NewAccount(testKsDavecAccount)
+
+	n := New(dataDir, "127.0.0.1", 8085, devAccount, PeerNode{})
+	n.EnableDevMode()
+
+	// Well before the first mining interval, the TX must be sealed right away
+	ctx, closeNode := context.WithTimeout(context.Background(), time.Second*miningIntervalSeconds/2)
+	defer closeNode()
+
+	go func() {
+		<-n.stateLoaded
+
+		tx := database.NewTx(devAccount, babaYaga, 5, 1, "")
+		signedTx, err := wallet.SignTx(tx, wallet.DevKey())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		_, err = n.SubmitTX(signedTx)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		for ctx.Err() == nil {
+			if !n.state.LatestBlockHash().IsEmpty() {
+				closeNode()
+				return
+			}
+
+			time.Sleep(time.Millisecond * 100)
+		}
+	}()
+
+	_ = n.Run(ctx)
+
+	_, balances := n.state.LatestBalances()
+	if balances[babaYaga] != 5 {
+		t.Fatalf("expected the dev TX to be mined under %ds, babaYaga owns %d tokens", miningIntervalSeconds/2, balances[babaYaga])
+	}
+
+	if n.state.Genesis().ChainID != database.DevChainID {
+		t.Fatalf("expected the dev genesis, got chain '%s'", n.state.Genesis().ChainID)
+	}
+}
+
+func TestNode_PoASigner(t *testing.T) {
+	dataDir, err := getTestDataDirPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.RemoveDir(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveDir(dataDir)
+
+	signer := database.NewAccount(wallet.DevAccount)
+	babaYaga := database.NewAccount(testKsDavecAccount)
+
+	genesis := database.Genesis{
+		Balances:  map[common.Address]uint{signer: 1000000},
+		Consensus: database.ConsensusPoA,
+		PoA:       &database.PoAConfig{Signers: []common.Address{signer}, Period: 1},
+	}
+	genesisJson, err := json.Marshal(genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = database.InitDataDirIfNotExists(dataDir, genesisJson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := New(dataDir, "127.0.0.1", 8085, signer, PeerNode{})
+	n.SetSignerKey(wallet.DevKey())
+
+	// The signer seals pending TXs every period, well before the first mining interval
+	ctx, closeNode := context.WithTimeout(context.Background(), time.Second*miningIntervalSeconds/2)
+	defer closeNode()
+
+	go func() {
+		<-n.stateLoaded
+
+		for nonce := uint(1); nonce <= 2; nonce++ {
+			tx := database.NewTx(signer, babaYaga, 5, nonce, "")
+			signedTx, err := wallet.SignTx(tx, wallet.DevKey())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			_, err = n.SubmitTX(signedTx)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			for ctx.Err() == nil && n.state.NextBlockNumber() < uint64(nonce) {
+				time.Sleep(time.Millisecond * 100)
+			}
+		}
+
+		closeNode()
+	}()
+
+	_ = n.Run(ctx)
+
+	_, balances := n.state.LatestBalances()
+	if balances[babaYaga] != 10 {
+		t.Fatalf("expected both TXs to be sealed under %ds, babaYaga owns %d tokens", miningIntervalSeconds/2, balances[babaYaga])
+	}
+
+	latest := n.state.LatestBlock()
+	parent, _ := n.state.GetBlockInfo(latest.Header.Parent)
+	if latest.Header.Time < parent.Time+1 {
+		t.Fatalf("blocks must be sealed at least a period apart, got %d and %d", parent.Time, latest.Header.Time)
+	}
+
+	blockSigner, err := latest.Header.Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blockSigner != signer {
+		t.Fatalf("expected the block to be signed by '%s', got '%s'", signer.Hex(), blockSigner.Hex())
+	}
+}
+
 func TestNode_ForgedTx(t *testing.T) {
 	dataDir, paulc, babaYaga, err := setupTestNodeDir()
 	if err != nil {
@@ -141,7 +291,8 @@ func TestNode_ForgedTx(t *testing.T) {
 	defer fs.RemoveDir(dataDir)
 
 	n := New(dataDir, "127.0.0.1", 8085, paulc, PeerNode{})
-	ctx, _ := context.WithTimeout(context.Background(), time.Minute*15)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*15)
+	defer cancel()
 	paulcPeerNode := NewPeerNode("127.0.0.1", 8085, false, paulc, true)
 
 	txValue := uint(5)
@@ -187,6 +338,7 @@ func TestNode_ReplayedTx(t *testing.T) {
 
 	n := New(dataDir, "127.0.0.1", 8085, paulc, PeerNode{})
 	ctx, closeNode := context.WithCancel(context.Background())
+	defer closeNode()
 	paulcPeerNode := NewPeerNode("127.0.0.1", 8085, false, paulc, true)
 	babaYagaPeerNode := NewPeerNode("127.0.0.1", 8086, false, babaYaga, true)
 
@@ -200,9 +352,12 @@ func TestNode_ReplayedTx(t *testing.T) {
 		return
 	}
 
-	_ = n.AddPendingTX(signedTx, paulcPeerNode)
-
 	go func() {
+		<-n.stateLoaded
+
+		// Pending TXs are validated against the state, it has to be loaded first
+		_ = n.AddPendingTX(signedTx, paulcPeerNode)
+
 		ticker := time.NewTicker(time.Second * (miningIntervalSeconds - 3))
 		wasReplayedTxAdded := false
 
@@ -212,13 +367,15 @@ func TestNode_ReplayedTx(t *testing.T) {
 				// The Paulc's original TX got mined.
 				// Execute the attack by replaying the TX again!
 				if n.state.LatestBlock().Header.Number == 0 {
-					if wasReplayedTxAdded && !n.isMining {
+					if wasReplayedTxAdded && !n.IsMining() {
 						closeNode()
 						return
 					}
 
 					// Simulate the TX was submitted to different node
+					n.lock.Lock()
 					n.archivedTXs = make(map[string]database.SignedTx)
+					n.lock.Unlock()
 					// Execute the attack
 					_ = n.AddPendingTX(signedTx, babaYagaPeerNode)
 					wasReplayedTxAdded = true
@@ -234,7 +391,7 @@ func TestNode_ReplayedTx(t *testing.T) {
 
 	_ = n.Run(ctx)
 
-	if n.state.Balances[babaYaga] == txValue*2 {
+	if n.state.GetBalance(babaYaga) == txValue*2 {
 		t.Errorf("replayed attack was successful :( Damn digital signatures!")
 		return
 	}
@@ -292,6 +449,7 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 
 	// Allow the test to run for 30 mins, in the worst case
 	ctx, closeNode := context.WithTimeout(context.Background(), time.Minute*30)
+	defer closeNode()
 
 	tx1 := database.NewTx(paulc, babaYaga, 1, 1, "")
 	tx2 := database.NewTx(paulc, babaYaga, 2, 2, "")
@@ -316,7 +474,19 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 	// Pre-mine a valid block without running the `n.Run()`
 	// with Paulc as a miner who will receive the block reward,
 	// to simulate the block came on the fly from another peer
-	validPreMinedPb := NewPendingBlock(database.Hash{}, 0, paulc, []database.SignedTx{signedTx1})
+	validPreMinedPb := NewPendingBlock(database.Hash{}, 0, paulc, database.DefaultDifficulty, []database.SignedTx{signedTx1})
+
+	// The node isn't running yet, the state it will load is the genesis one
+	genesisState, err := database.NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validPreMinedPb.stateRoot, err = genesisState.NextStateRoot(validPreMinedPb.newBlock())
+	genesisState.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	validSyncedBlock, err := Mine(ctx, validPreMinedPb)
 	if err != nil {
 		t.Fatal(err)
@@ -328,12 +498,14 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 
 		err := n.AddPendingTX(signedTx1, nInfo)
 		if err != nil {
-			t.Fatal(err)
+			t.Error(err)
+			return
 		}
 
 		err = n.AddPendingTX(signedTx2, nInfo)
 		if err != nil {
-			t.Fatal(err)
+			t.Error(err)
+			return
 		}
 	}()
 
@@ -343,36 +515,47 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 	// the synced block
 	go func() {
 		time.Sleep(time.Second * (miningIntervalSeconds + 2))
-		if !n.isMining {
-			t.Fatal("should be mining")
+		if !n.IsMining() {
+			t.Error("should be mining")
+			return
 		}
 
 		_, err := n.state.AddBlock(validSyncedBlock)
 		if err != nil {
-			t.Fatal(err)
+			t.Error(err)
+			return
 		}
 		// Mock the Paulc's block came from a network
 		n.newSyncedBlocks <- validSyncedBlock
 
 		time.Sleep(time.Second * 2)
-		if n.isMining {
-			t.Fatal("synced block should have canceled mining")
+		if n.IsMining() {
+			t.Error("synced block should have canceled mining")
+			return
 		}
 
 		// Mined TX1 by Paulc should be removed from the Mempool
-		_, onlyTX2IsPending := n.pendingTXs[tx2Hash.Hex()]
+		pendingTXs := n.getPendingTXsAsArray()
+		onlyTX2IsPending := len(pendingTXs) == 1
+		if onlyTX2IsPending {
+			pendingTXHash, _ := pendingTXs[0].Hash()
+			onlyTX2IsPending = pendingTXHash == tx2Hash
+		}
 
-		if len(n.pendingTXs) != 1 && !onlyTX2IsPending {
-			t.Fatal("synced block should have canceled mining of already mined TX")
+		if !onlyTX2IsPending {
+			t.Error("synced block should have canceled mining of already mined TX")
+			return
 		}
 
 		time.Sleep(time.Second * (miningIntervalSeconds + 2))
-		if !n.isMining {
-			t.Fatal("should be mining again the 1 TX not included in synced block")
+		if !n.IsMining() {
+			t.Error("should be mining again the 1 TX not included in synced block")
 		}
 	}()
 
 	go func() {
+		<-n.stateLoaded
+
 		// Regularly check whenever both TXs are now mined
 		ticker := time.NewTicker(time.Second * 10)
 
@@ -388,32 +571,33 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 	}()
 
 	go func() {
+		<-n.stateLoaded
 		time.Sleep(time.Second * 2)
 
 		// Take a snapshot of the DB balances
 		// before the mining is finished and the 2 blocks
 		// are created.
-		startingPaulcBalance := n.state.Balances[paulc]
-		startingBabaYagaBalance := n.state.Balances[babaYaga]
+		startingPaulcBalance := n.state.GetBalance(paulc)
+		startingBabaYagaBalance := n.state.GetBalance(babaYaga)
 
 		// Wait until the 30 mins timeout is reached or
 		// the 2 blocks got already mined and the closeNode() was triggered
 		<-ctx.Done()
 
-		endPaulcBalance := n.state.Balances[paulc]
-		endBabaYagaBalance := n.state.Balances[babaYaga]
+		endPaulcBalance := n.state.GetBalance(paulc)
+		endBabaYagaBalance := n.state.GetBalance(babaYaga)
 
 		// In TX1 Paulc transferred 1 TBB token to BabaYaga
 		// In TX2 Paulc transferred 2 TBB tokens to BabaYaga
-		expectedEndPaulcBalance := startingPaulcBalance - tx1.Value - tx2.Value + database.BlockReward
-		expectedEndBabaYagaBalance := startingBabaYagaBalance + tx1.Value + tx2.Value + database.BlockReward
+		expectedEndPaulcBalance := startingPaulcBalance - tx1.Value - tx2.Value + database.DefaultBlockReward
+		expectedEndBabaYagaBalance := startingBabaYagaBalance + tx1.Value + tx2.Value + database.DefaultBlockReward
 
 		if endPaulcBalance != expectedEndPaulcBalance {
-			t.Fatalf("Paulc expected end balance is %d not %d", expectedEndPaulcBalance, endPaulcBalance)
+			t.Errorf("Paulc expected end balance is %d not %d", expectedEndPaulcBalance, endPaulcBalance)
 		}
 
 		if endBabaYagaBalance != expectedEndBabaYagaBalance {
-			t.Fatalf("BabaYaga expected end balance is %d not %d", expectedEndBabaYagaBalance, endBabaYagaBalance)
+			t.Errorf("BabaYaga expected end balance is %d not %d", expectedEndBabaYagaBalance, endBabaYagaBalance)
 		}
 
 		t.Logf("Starting Paulc balance: %d", startingPaulcBalance)
@@ -428,11 +612,229 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 		t.Fatal("was suppose to mine 2 pending TX into 2 valid blocks under 30m")
 	}
 
-	if len(n.pendingTXs) != 0 {
+	if len(n.getPendingTXsAsArray()) != 0 {
 		t.Fatal("no pending TXs should be left to mine")
 	}
 }
 
+// The test mines at this difficulty to keep the blocks coming quickly
+const testDifficulty = 16
+
+// TestNode_ConcurrentAccess runs two nodes syncing from each other, while
+// their HTTP API is queried and mining is in progress. Run it with -race.
+func TestNode_ConcurrentAccess(t *testing.T) {
+	dataDirA, paulc, babaYaga, err := setupTestNodeDirWithDifficulty(testDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveDir(dataDirA)
+
+	dataDirB, _, _, err := setupTestNodeDirWithDifficulty(testDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveDir(dataDirB)
+
+	peerA := NewPeerNode("127.0.0.1", 8086, true, paulc, false)
+	nA := New(dataDirA, "127.0.0.1", 8086, paulc, PeerNode{})
+	nB := New(dataDirB, "127.0.0.1", 8087, babaYaga, peerA)
+
+	signedTXs := make([]database.SignedTx, 3)
+	for i := range signedTXs {
+		tx := database.NewTx(paulc, babaYaga, 1, uint(i+1), "")
+
+		signedTXs[i], err = wallet.SignTxWithKeystoreAccount(tx, paulc, testKsAccountsPwd, wallet.GetKeystoreDirPath(dataDirA))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, closeNodes := context.WithTimeout(context.Background(), time.Minute*5)
+	defer closeNodes()
+
+	go func() {
+		_ = nA.Run(ctx)
+	}()
+	go func() {
+		_ = nB.Run(ctx)
+	}()
+
+	<-nA.stateLoaded
+	<-nB.stateLoaded
+
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+
+	// repeat calls f in a loop until the test is over
+	repeat := func(interval time.Duration, f func()) {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-stop:
+					return
+				case <-time.After(interval):
+					f()
+				}
+			}
+		}()
+	}
+
+	for _, n := range []*Node{nA, nB} {
+		n := n
+		genesisHash := n.state.GenesisHash().Hex()
+
+		repeat(time.Millisecond*50, func() {
+			for _, endpoint := range []string{
+				endpointStatus,
+				"/balances/list",
+				fmt.Sprintf("%s?%s=%s", endpointSync, endpointSyncQueryKeyGenesis, genesisHash),
+			} {
+				res, err := http.Get(fmt.Sprintf("http://%s%s", n.info.TcpAddress(), endpoint))
+				if err != nil {
+					continue
+				}
+				_, _ = io.Copy(ioutil.Discard, res.Body)
+				res.Body.Close()
+			}
+		})
+
+		repeat(time.Millisecond*500, n.doSync)
+
+		repeat(time.Millisecond*10, func() {
+			unreachablePeer := NewPeerNode("127.0.0.1", 1, false, common.Address{}, false)
+			n.AddPeer(unreachablePeer)
+			n.RemovePeer(unreachablePeer)
+
+			_ = n.IsMining()
+			_ = n.getPendingTXsAsArray()
+			_ = n.state.GetBalance(babaYaga)
+			_, _ = n.state.LatestBalances()
+		})
+	}
+
+	paulcPeerNode := NewPeerNode("127.0.0.1", 8086, false, paulc, true)
+	err = nA.AddPendingTX(signedTXs[0], paulcPeerNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Queue the remaining TXs while the first one is being mined
+	time.AfterFunc(time.Second*(miningIntervalSeconds+1), func() {
+		for _, tx := range signedTXs[1:] {
+			_ = nA.AddPendingTX(tx, paulcPeerNode)
+		}
+	})
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			close(stop)
+			wg.Wait()
+			t.Fatal("the nodes didn't mine and sync all TXs in time")
+		}
+
+		isSynced := nA.state.LatestBlock().Header.Number >= 1 && nB.state.LatestBlock().Header.Number >= 1
+		if isSynced && len(nA.getPendingTXsAsArray()) == 0 && !nA.IsMining() {
+			break
+		}
+	}
+
+	// Stop hammering the nodes before closing them, so no sync is left waiting on a stopped miner
+	close(stop)
+	wg.Wait()
+	closeNodes()
+}
+
+// TestNode_GossipsNewTXsAndBlocks checks a TX and the block mining it reach
+// a peer long before the periodic sync would have pulled them.
+func TestNode_GossipsNewTXsAndBlocks(t *testing.T) {
+	dataDirA, paulc, babaYaga, err := setupTestNodeDirWithDifficulty(testDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveDir(dataDirA)
+
+	dataDirB, _, _, err := setupTestNodeDirWithDifficulty(testDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveDir(dataDirB)
+
+	peerA := NewPeerNode("127.0.0.1", 8088, true, paulc, true)
+	peerB := NewPeerNode("127.0.0.1", 8089, true, babaYaga, true)
+	nA := New(dataDirA, "127.0.0.1", 8088, paulc, peerB)
+	nB := New(dataDirB, "127.0.0.1", 8089, babaYaga, peerA)
+
+	tx := database.NewTx(paulc, babaYaga, 1, 1, "")
+	signedTx, err := wallet.SignTxWithKeystoreAccount(tx, paulc, testKsAccountsPwd, wallet.GetKeystoreDirPath(dataDirA))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Well below the 45s sync interval, only gossip can make it in time
+	ctx, closeNodes := context.WithTimeout(context.Background(), time.Second*30)
+	defer closeNodes()
+
+	go func() {
+		_ = nA.Run(ctx)
+	}()
+	<-nA.stateLoaded
+
+	// Start B half a mining interval later, so it never gets to mine the TX before A
+	time.Sleep(time.Second * miningIntervalSeconds / 2)
+
+	go func() {
+		_ = nB.Run(ctx)
+	}()
+	<-nB.stateLoaded
+	time.Sleep(time.Second)
+
+	err = nA.AddPendingTX(signedTx, peerA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txHash, _ := signedTx.Hash()
+	isTXGossiped := false
+
+	ticker := time.NewTicker(time.Millisecond * 100)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			t.Fatal("the TX and its block weren't gossiped to the peer in time")
+		}
+
+		for _, pendingTX := range nB.getPendingTXsAsArray() {
+			pendingTXHash, _ := pendingTX.Hash()
+			isTXGossiped = isTXGossiped || pendingTXHash == txHash
+		}
+
+		isBlockGossiped := !nA.LatestBlockHash().IsEmpty() && nB.LatestBlockHash() == nA.LatestBlockHash()
+		if isBlockGossiped {
+			break
+		}
+	}
+
+	if !isTXGossiped {
+		t.Fatal("the TX should have reached the peer's pending TXs before being mined")
+	}
+
+	if len(nB.getPendingTXsAsArray()) != 0 {
+		t.Fatal("the gossiped block should have removed the mined TX from the peer's pending TXs")
+	}
+}
+
 // Creates dir like: "/tmp/tbb_test945924586"
 func getTestDataDirPath() (string, error) {
 	return ioutil.TempDir(os.TempDir(), "tbb_test")
@@ -492,6 +894,12 @@ func copyKeystoreFilesIntoTestDataDirPath(dataDir string) error {
 //
 // Remember to remove the dir once test finishes: defer fs.RemoveDir(dataDir)
 func setupTestNodeDir() (dataDir string, paulc, babaYaga common.Address, err error) {
+	return setupTestNodeDirWithDifficulty(0)
+}
+
+// setupTestNodeDirWithDifficulty is setupTestNodeDir with a custom genesis
+// difficulty, 0 keeps the default one.
+func setupTestNodeDirWithDifficulty(difficulty uint64) (dataDir string, paulc, babaYaga common.Address, err error) {
 	babaYaga = database.NewAccount(testKsDavecAccount)
 	paulc = database.NewAccount(testKsPaulcAccount)
 
@@ -502,7 +910,7 @@ func setupTestNodeDir() (dataDir string, paulc, babaYaga common.Address, err err
 
 	genesisBalances := make(map[common.Address]uint)
 	genesisBalances[paulc] = 1000000
-	genesis := database.Genesis{Balances: genesisBalances}
+	genesis := database.Genesis{Balances: genesisBalances, Difficulty: difficulty}
 	genesisJson, err := json.Marshal(genesis)
 	if err != nil {
 		return "", common.Address{}, common.Address{}, err
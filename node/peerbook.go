@@ -0,0 +1,309 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/paulcockrell/blockchain/database"
+)
+
+// peersFileName is the peer book, in the data dir, see savePeers.
+const peersFileName = "peers.json"
+
+// A peer whose status can't be queried maxPeerFailures times in a row is
+// dropped, unless it's a bootstrap peer.
+const maxPeerFailures = 5
+
+// Peers serving invalid blocks aren't synced with, gossiped to nor listened
+// to for peerBanDuration.
+const peerBanDuration = time.Hour
+
+// An unresponsive peer shouldn't hold the sync of the others
+const peerQueryTimeout = 5 * time.Second
+
+var peerClient = &http.Client{Timeout: peerQueryTimeout}
+
+// Each status query weighs this much in the latency moving average
+const peerLatencyWeight = 0.2
+
+// PeerRecord is what the node knows about a peer and how well it served it.
+// The peer book of the data dir keeps them across restarts.
+type PeerRecord struct {
+	Peer PeerNode `json:"peer"`
+
+	// Latency is the moving average of the status queries round trip, in milliseconds
+	Latency float64 `json:"latency_ms"`
+	// Queries counts the status queries, FailedQueries the unanswered ones, see Uptime
+	Queries       uint64 `json:"queries"`
+	FailedQueries uint64 `json:"failed_queries"`
+	// Failures counts the failed status queries since the last answered one
+	Failures uint64    `json:"failures"`
+	LastSeen time.Time `json:"last_seen"`
+
+	ValidBlocks   uint64    `json:"valid_blocks"`
+	InvalidBlocks uint64    `json:"invalid_blocks"`
+	BannedUntil   time.Time `json:"banned_until"`
+	BanReason     string    `json:"ban_reason,omitempty"`
+}
+
+// Uptime is the share of the status queries the peer answered, new peers get
+// the benefit of the doubt.
+func (r PeerRecord) Uptime() float64 {
+	if r.Queries == 0 {
+		return 1
+	}
+
+	return float64(r.Queries-r.FailedQueries) / float64(r.Queries)
+}
+
+// Score ranks the peers, the node syncs with the best ones first. A peer earns
+// up to 100 points of uptime and a point per valid block served, up to 100, and
+// loses a point per 100ms of latency and 50 per invalid block served.
+func (r PeerRecord) Score() int {
+	validBlocks := r.ValidBlocks
+	if validBlocks > 100 {
+		validBlocks = 100
+	}
+
+	score := int(r.Uptime()*100) + int(validBlocks) - int(r.Latency/100)
+
+	return score - 50*int(r.InvalidBlocks)
+}
+
+func (r PeerRecord) IsBanned() bool {
+	return time.Now().Before(r.BannedUntil)
+}
+
+func getPeersFilePath(dataDir string) string {
+	return filepath.Join(dataDir, peersFileName)
+}
+
+// loadPeers adds the peers of the data dir peer book to the known peers. They
+// join again on the next sync.
+func (n *Node) loadPeers() error {
+	content, err := ioutil.ReadFile(getPeersFilePath(n.dataDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var records []PeerRecord
+	err = json.Unmarshal(content, &records)
+	if err != nil {
+		return fmt.Errorf("unable to read the peer book. %s", err.Error())
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for i := range records {
+		record := records[i]
+		addr := record.Peer.TcpAddress()
+		if addr == n.info.TcpAddress() {
+			continue
+		}
+
+		// The bootstrap peer given on the command line wins over the remembered one
+		if known, isKnown := n.peers[addr]; isKnown {
+			record.Peer = known.Peer
+		}
+		n.peers[addr] = &record
+	}
+
+	return nil
+}
+
+// savePeers writes the peer book to the data dir.
+func (n *Node) savePeers() error {
+	n.lock.RLock()
+	records := make([]PeerRecord, 0, len(n.peers))
+	for _, record := range n.peers {
+		records = append(records, *record)
+	}
+	n.lock.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Peer.TcpAddress() < records[j].Peer.TcpAddress()
+	})
+
+	content, err := json.MarshalIndent(records, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	// Writing aside first, a crash never leaves a truncated peer book
+	path := getPeersFilePath(n.dataDir)
+	err = ioutil.WriteFile(path+".tmp", content, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// getPeersByScore returns the peers which aren't banned, the best scored first.
+func (n *Node) getPeersByScore() []PeerNode {
+	records := n.listPeers()
+
+	peers := make([]PeerNode, 0, len(records))
+	for _, record := range records {
+		if !record.IsBanned() {
+			peers = append(peers, record.Peer)
+		}
+	}
+
+	return peers
+}
+
+// listPeers returns a copy of every peer record, banned ones included, the best scored first.
+func (n *Node) listPeers() []PeerRecord {
+	n.lock.RLock()
+	records := make([]PeerRecord, 0, len(n.peers))
+	for _, record := range n.peers {
+		records = append(records, *record)
+	}
+	n.lock.RUnlock()
+
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Score() != records[j].Score() {
+			return records[i].Score() > records[j].Score()
+		}
+
+		return records[i].Peer.TcpAddress() < records[j].Peer.TcpAddress()
+	})
+
+	return records
+}
+
+// recordPeerQuery scores a status query of peer. A peer failing too many in a
+// row is dropped, the returned bool reports whether it was.
+func (n *Node) recordPeerQuery(peer PeerNode, latency time.Duration, err error) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	record, isKnown := n.peers[peer.TcpAddress()]
+	if !isKnown {
+		return false
+	}
+
+	record.Queries++
+
+	if err != nil {
+		record.FailedQueries++
+		record.Failures++
+
+		if record.Failures >= maxPeerFailures && !record.Peer.IsBootstrap {
+			delete(n.peers, peer.TcpAddress())
+			return true
+		}
+
+		// It has to join again once back
+		record.Peer.connected = false
+
+		return false
+	}
+
+	ms := float64(latency) / float64(time.Millisecond)
+	if record.Queries-record.FailedQueries == 1 {
+		record.Latency = ms
+	} else {
+		record.Latency += peerLatencyWeight * (ms - record.Latency)
+	}
+	record.Failures = 0
+	record.LastSeen = time.Now()
+
+	return false
+}
+
+// recordServedBlock scores peer on a block it served, err being the result of
+// importing it. Peers serving blocks breaking the consensus rules are banned,
+// other errors, such as the block's parent not being known yet, an earlier block
+// of its branch, maybe served by someone else, being invalid or the node failing
+// to store it, don't count either way. Only peers of the book are scored.
+func (n *Node) recordServedBlock(peer PeerNode, err error) {
+	if err != nil && !errors.Is(err, database.ErrInvalidBlock) {
+		return
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	record, isKnown := n.peers[peer.TcpAddress()]
+	if !isKnown {
+		return
+	}
+
+	if err == nil {
+		record.ValidBlocks++
+		return
+	}
+
+	record.InvalidBlocks++
+	record.BannedUntil = time.Now().Add(peerBanDuration)
+	record.BanReason = err.Error()
+	record.Peer.connected = false
+
+	fmt.Printf("Peer '%s' served an invalid block and is banned until %s. %s\n", peer.TcpAddress(), record.BannedUntil.Format(time.RFC3339), err)
+}
+
+func (n *Node) isBannedPeer(peer PeerNode) bool {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	record, isKnown := n.peers[peer.TcpAddress()]
+
+	return isKnown && record.IsBanned()
+}
+
+// isBannedHost reports whether a peer of the book running on host is banned.
+// Gossip is refused from the whole host, a banned peer can't come back under
+// another port.
+func (n *Node) isBannedHost(host string) bool {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	for _, record := range n.peers {
+		if record.IsBanned() && isSameHost(record.Peer.IP, host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSameHost compares two IPs.
+func isSameHost(a string, b string) bool {
+	ipA := net.ParseIP(a)
+	ipB := net.ParseIP(b)
+	if ipA == nil || ipB == nil {
+		return a == b
+	}
+
+	return ipA.Equal(ipB)
+}
+
+// UnbanPeer lets a banned peer sync and gossip again, it reports whether the peer was known.
+func (n *Node) UnbanPeer(peer PeerNode) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	record, isKnown := n.peers[peer.TcpAddress()]
+	if !isKnown {
+		return false
+	}
+
+	record.BannedUntil = time.Time{}
+	record.BanReason = ""
+
+	return true
+}
@@ -0,0 +1,276 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paulcockrell/blockchain/database"
+	"github.com/paulcockrell/blockchain/fs"
+)
+
+func TestPeerBook_ScoresAndPersists(t *testing.T) {
+	dataDir, err := getTestDataDirPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveDir(dataDir)
+
+	bootstrap := NewPeerNode("127.0.0.1", 9001, true, database.NewAccount(DefaultMiner), false)
+	fast := NewPeerNode("127.0.0.1", 9002, false, database.NewAccount(DefaultMiner), true)
+	slow := NewPeerNode("127.0.0.1", 9003, false, database.NewAccount(DefaultMiner), true)
+	flaky := NewPeerNode("127.0.0.1", 9004, false, database.NewAccount(DefaultMiner), true)
+
+	n := New(dataDir, "127.0.0.1", 9000, database.NewAccount(DefaultMiner), bootstrap)
+	n.AddPeer(fast)
+	n.AddPeer(slow)
+	n.AddPeer(flaky)
+
+	n.recordPeerQuery(fast, 10*time.Millisecond, nil)
+	n.recordPeerQuery(slow, 2*time.Second, nil)
+	n.recordPeerQuery(flaky, 10*time.Millisecond, nil)
+	n.recordPeerQuery(flaky, 0, fmt.Errorf("connection refused"))
+	n.recordServedBlock(fast, nil)
+
+	peers := n.getPeersByScore()
+	if len(peers) != 4 || peers[0].TcpAddress() != fast.TcpAddress() {
+		t.Fatalf("the fast peer which served a block should be synced with first, got %v", peers)
+	}
+	if peers[3].TcpAddress() != flaky.TcpAddress() {
+		t.Fatalf("the peer which failed to answer should be synced with last, got %v", peers)
+	}
+
+	// A failed query isn't enough to drop a peer, failing maxPeerFailures times in a row is
+	for i := 1; i < maxPeerFailures-1; i++ {
+		if n.recordPeerQuery(flaky, 0, fmt.Errorf("connection refused")) {
+			t.Fatalf("the peer was dropped after %d failures", i+1)
+		}
+	}
+	if !n.recordPeerQuery(flaky, 0, fmt.Errorf("connection refused")) || n.IsKnownPeer(flaky) {
+		t.Fatalf("the peer should be dropped after %d failures", maxPeerFailures)
+	}
+
+	// Bootstrap peers are kept whatever happens
+	for i := 0; i < maxPeerFailures; i++ {
+		n.recordPeerQuery(bootstrap, 0, fmt.Errorf("connection refused"))
+	}
+	if !n.IsKnownPeer(bootstrap) {
+		t.Fatal("the bootstrap peer should never be dropped")
+	}
+
+	err = n.savePeers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := New(dataDir, "127.0.0.1", 9000, database.NewAccount(DefaultMiner), PeerNode{})
+	err = restarted.loadPeers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, record := range n.listPeers() {
+		addr := record.Peer.TcpAddress()
+		loaded, isKnown := restarted.peers[addr]
+		if !isKnown {
+			t.Fatalf("peer '%s' should be loaded from the peer book", addr)
+		}
+
+		if loaded.Score() != record.Score() || loaded.Queries != record.Queries {
+			t.Fatalf("peer '%s' score should be kept, got %+v instead of %+v", addr, *loaded, record)
+		}
+
+		if loaded.Peer.connected {
+			t.Fatalf("peer '%s' should join again after a restart", addr)
+		}
+	}
+}
+
+func TestPeerBook_BansPeersServingInvalidBlocks(t *testing.T) {
+	dataDir, err := getTestDataDirPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveDir(dataDir)
+
+	peer := NewPeerNode("127.0.0.1", 9001, false, database.NewAccount(DefaultMiner), true)
+	n := New(dataDir, "127.0.0.1", 9000, database.NewAccount(DefaultMiner), peer)
+
+	// A block arriving before its parent isn't the peer's fault
+	n.recordServedBlock(peer, fmt.Errorf("%w. Block '00' parent is '01'", database.ErrUnknownParent))
+	if n.isBannedPeer(peer) {
+		t.Fatal("a block with an unknown parent should not ban its peer")
+	}
+
+	// Nor is an invalid block it built on, which someone else may have served
+	n.recordServedBlock(peer, fmt.Errorf("%w. Block '01': insufficient balance", database.ErrBadBranch))
+	if n.isBannedPeer(peer) {
+		t.Fatal("an invalid earlier block of the branch should not ban the peer")
+	}
+
+	// Nor is the node failing to store the block
+	n.recordServedBlock(peer, fmt.Errorf("write block.db: no space left on device"))
+	if n.isBannedPeer(peer) {
+		t.Fatal("a local error should not ban the peer")
+	}
+
+	n.recordServedBlock(peer, fmt.Errorf("%w. invalid block signature", database.ErrInvalidBlock))
+	if !n.isBannedPeer(peer) {
+		t.Fatal("a peer serving an invalid block should be banned")
+	}
+
+	// Peers which aren't in the book aren't scored
+	stranger := NewPeerNode("192.0.2.1", 9001, false, database.NewAccount(DefaultMiner), true)
+	n.recordServedBlock(stranger, fmt.Errorf("%w. invalid block signature", database.ErrInvalidBlock))
+	if n.IsKnownPeer(stranger) {
+		t.Fatal("a peer outside of the book should not get a record")
+	}
+
+	if _, isKnown := n.getKnownPeers()[peer.TcpAddress()]; isKnown {
+		t.Fatal("banned peers should not be synced with nor gossiped to")
+	}
+
+	// Rejoining or being announced by other peers doesn't lift the ban
+	n.AddPeer(peer)
+	if !n.isBannedPeer(peer) {
+		t.Fatal("adding a banned peer again should not lift its ban")
+	}
+
+	if !n.UnbanPeer(peer) || n.isBannedPeer(peer) {
+		t.Fatal("the peer should be unbanned")
+	}
+
+	if _, isKnown := n.getKnownPeers()[peer.TcpAddress()]; !isKnown {
+		t.Fatal("an unbanned peer should be synced with again")
+	}
+}
+
+func TestPeerBook_AdminEndpoints(t *testing.T) {
+	dataDir, err := getTestDataDirPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveDir(dataDir)
+
+	n := New(dataDir, "127.0.0.1", 9000, database.NewAccount(DefaultMiner), PeerNode{})
+	n.RemovePeer(PeerNode{})
+
+	sendAdminReq := func(remoteAddr string, handler func(http.ResponseWriter, *http.Request, *Node), body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, endpointAdminPeers, strings.NewReader(body))
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		adminHandler(handler, n).ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	peerJSON := `{"ip": "127.0.0.1", "port": 9001}`
+
+	rec := sendAdminReq("192.0.2.1:1234", adminAddPeerHandler, peerJSON)
+	if rec.Code != http.StatusForbidden || n.IsKnownPeer(NewPeerNode("127.0.0.1", 9001, false, database.NewAccount(DefaultMiner), false)) {
+		t.Fatalf("remote callers should not manage the peers, got %d", rec.Code)
+	}
+
+	rec = sendAdminReq("127.0.0.1:1234", adminAddPeerHandler, peerJSON)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("adding a peer failed with %d: %s", rec.Code, rec.Body.String())
+	}
+
+	peer := NewPeerNode("127.0.0.1", 9001, false, database.NewAccount(DefaultMiner), false)
+	n.recordServedBlock(peer, fmt.Errorf("%w. invalid block hash", database.ErrInvalidBlock))
+
+	rec = sendAdminReq("[::1]:1234", listPeersHandler, "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"is_banned":true`) {
+		t.Fatalf("the banned peer should be listed, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = sendAdminReq("127.0.0.1:1234", adminUnbanPeerHandler, peerJSON)
+	if rec.Code != http.StatusOK || n.isBannedPeer(peer) {
+		t.Fatalf("unbanning the peer failed with %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = sendAdminReq("127.0.0.1:1234", adminRemovePeerHandler, peerJSON)
+	if rec.Code != http.StatusOK || n.IsKnownPeer(peer) {
+		t.Fatalf("removing the peer failed with %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = sendAdminReq("127.0.0.1:1234", adminRemovePeerHandler, peerJSON)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("removing an unknown peer should answer 404, got %d", rec.Code)
+	}
+}
+
+func TestPeerBook_ScoresTheGossipSender(t *testing.T) {
+	dataDir, _, _, err := setupTestNodeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveDir(dataDir)
+
+	honest := NewPeerNode("192.0.2.10", 9001, false, database.NewAccount(DefaultMiner), true)
+	n := New(dataDir, "127.0.0.1", 9000, database.NewAccount(DefaultMiner), honest)
+	n.state, err = database.NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.state.Close()
+
+	// Never mined, its hash doesn't meet the difficulty
+	invalidBlock := database.NewBlock(database.Hash{}, 0, 0, 0, honest.Account, database.DefaultDifficulty, []database.SignedTx{})
+
+	sendBlockGossipReq := func(remoteAddr string, from PeerNode) *httptest.ResponseRecorder {
+		reqJSON, err := json.Marshal(BlockGossipReq{From: from, GenesisHash: n.state.GenesisHash(), Block: invalidBlock})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, endpointBlockGossip, strings.NewReader(string(reqJSON)))
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		blockGossipHandler(rec, req, n)
+
+		return rec
+	}
+
+	// Another host gossiping in the name of the honest peer is refused, the honest peer isn't banned
+	rec := sendBlockGossipReq("192.0.2.1:50000", honest)
+	if rec.Code != http.StatusForbidden || n.isBannedPeer(honest) {
+		t.Fatalf("a gossip spoofing another peer should be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = sendBlockGossipReq("192.0.2.10:50000", honest)
+	if rec.Code == http.StatusOK || !n.isBannedPeer(honest) {
+		t.Fatalf("the peer gossiping an invalid block should be banned, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The banned peer can't come back under another port
+	rec = sendBlockGossipReq("192.0.2.10:50001", NewPeerNode("192.0.2.10", 9002, false, database.NewAccount(DefaultMiner), true))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("a banned host should not gossip again, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Local nodes share the loopback host, banning one leaves the others be
+	local := NewPeerNode("127.0.0.1", 9003, false, database.NewAccount(DefaultMiner), true)
+	otherLocal := NewPeerNode("127.0.0.1", 9004, false, database.NewAccount(DefaultMiner), true)
+	n.AddPeer(local)
+	n.AddPeer(otherLocal)
+
+	rec = sendBlockGossipReq("127.0.0.1:50002", local)
+	if rec.Code == http.StatusOK || !n.isBannedPeer(local) {
+		t.Fatalf("the local peer gossiping an invalid block should be banned, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = sendBlockGossipReq("127.0.0.1:50003", local)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("a banned local peer should not gossip again, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = sendBlockGossipReq("127.0.0.1:50004", otherLocal)
+	if rec.Code == http.StatusForbidden || !n.isBannedPeer(otherLocal) {
+		t.Fatalf("another local peer should still be heard, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
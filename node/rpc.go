@@ -0,0 +1,304 @@
+package node
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/paulcockrell/blockchain/database"
+)
+
+// JSON-RPC 2.0 error codes, see https://www.jsonrpc.org/specification#error_object
+const rpcErrParse = -32700
+const rpcErrInvalidRequest = -32600
+const rpcErrMethodNotFound = -32601
+const rpcErrInvalidParams = -32602
+const rpcErrInternal = -32603
+
+// Server defined errors, from the -32000 to -32099 range reserved for them
+const rpcErrNotFound = -32001
+const rpcErrTxRejected = -32002
+
+const rpcVersion = "2.0"
+const maxRPCBatchSize = 100
+
+type RPCReq struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+type RPCRes struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcMethod func(node *Node, params json.RawMessage) (interface{}, *RPCError)
+
+var rpcMethods = map[string]rpcMethod{
+	"tbb_getBalance":         rpcGetBalance,
+	"tbb_getNonce":           rpcGetNonce,
+	"tbb_getBlockByNumber":   rpcGetBlockByNumber,
+	"tbb_getTransaction":     rpcGetTransaction,
+	"tbb_sendRawTransaction": rpcSendRawTransaction,
+	"tbb_status":             rpcStatus,
+}
+
+// rpcHandler serves single and batch JSON-RPC 2.0 requests. Notifications,
+// requests without an id, are executed but get no response.
+func rpcHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeRes(w, newRPCErrRes(nil, rpcErrParse, err.Error()))
+		return
+	}
+	defer r.Body.Close()
+
+	body = bytes.TrimSpace(body)
+
+	if len(body) == 0 || body[0] != '[' {
+		res, isNotification := handleRPCReq(node, body)
+		if isNotification {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		writeRes(w, res)
+		return
+	}
+
+	batch := make([]json.RawMessage, 0)
+	err = json.Unmarshal(body, &batch)
+	if err != nil {
+		writeRes(w, newRPCErrRes(nil, rpcErrParse, err.Error()))
+		return
+	}
+
+	if len(batch) == 0 || len(batch) > maxRPCBatchSize {
+		writeRes(w, newRPCErrRes(nil, rpcErrInvalidRequest, fmt.Sprintf("a batch must hold between 1 and %d requests", maxRPCBatchSize)))
+		return
+	}
+
+	responses := make([]RPCRes, 0, len(batch))
+	for _, reqJSON := range batch {
+		res, isNotification := handleRPCReq(node, reqJSON)
+		if !isNotification {
+			responses = append(responses, res)
+		}
+	}
+
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeRes(w, responses)
+}
+
+func handleRPCReq(node *Node, reqJSON []byte) (RPCRes, bool) {
+	req := RPCReq{}
+	err := json.Unmarshal(reqJSON, &req)
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return newRPCErrRes(nil, rpcErrParse, err.Error()), false
+		}
+
+		return newRPCErrRes(nil, rpcErrInvalidRequest, err.Error()), false
+	}
+
+	if req.JSONRPC != rpcVersion || req.Method == "" {
+		return newRPCErrRes(req.ID, rpcErrInvalidRequest, "expected a 2.0 JSON-RPC request with a method"), false
+	}
+
+	isNotification := len(req.ID) == 0
+
+	method, isKnown := rpcMethods[req.Method]
+	if !isKnown {
+		return newRPCErrRes(req.ID, rpcErrMethodNotFound, fmt.Sprintf("method '%s' not found", req.Method)), isNotification
+	}
+
+	result, rpcErr := method(node, req.Params)
+	if rpcErr != nil {
+		return RPCRes{JSONRPC: rpcVersion, Error: rpcErr, ID: req.ID}, isNotification
+	}
+
+	return RPCRes{JSONRPC: rpcVersion, Result: result, ID: req.ID}, isNotification
+}
+
+func newRPCErrRes(id json.RawMessage, code int, msg string) RPCRes {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+
+	return RPCRes{JSONRPC: rpcVersion, Error: &RPCError{code, msg}, ID: id}
+}
+
+// readRPCParams decodes positional params into the given pointers, all of them required.
+func readRPCParams(params json.RawMessage, values ...interface{}) *RPCError {
+	raw := make([]json.RawMessage, 0)
+	if len(params) > 0 {
+		err := json.Unmarshal(params, &raw)
+		if err != nil {
+			return &RPCError{rpcErrInvalidParams, "params must be an array"}
+		}
+	}
+
+	if len(raw) != len(values) {
+		return &RPCError{rpcErrInvalidParams, fmt.Sprintf("expected %d params, got %d", len(values), len(raw))}
+	}
+
+	for i, value := range values {
+		err := json.Unmarshal(raw[i], value)
+		if err != nil {
+			return &RPCError{rpcErrInvalidParams, fmt.Sprintf("invalid param %d. %s", i, err.Error())}
+		}
+	}
+
+	return nil
+}
+
+func readRPCAccountParam(params json.RawMessage) (common.Address, *RPCError) {
+	accRaw := ""
+	rpcErr := readRPCParams(params, &accRaw)
+	if rpcErr != nil {
+		return common.Address{}, rpcErr
+	}
+
+	if !common.IsHexAddress(accRaw) {
+		return common.Address{}, &RPCError{rpcErrInvalidParams, fmt.Sprintf("'%s' is an invalid account", accRaw)}
+	}
+
+	return database.NewAccount(accRaw), nil
+}
+
+func rpcLookupErr(err error) *RPCError {
+	if errors.Is(err, database.ErrNotFound) {
+		return &RPCError{rpcErrNotFound, err.Error()}
+	}
+
+	return &RPCError{rpcErrInternal, err.Error()}
+}
+
+func rpcGetBalance(node *Node, params json.RawMessage) (interface{}, *RPCError) {
+	acc, rpcErr := readRPCAccountParam(params)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	return node.state.GetBalance(acc), nil
+}
+
+func rpcGetNonce(node *Node, params json.RawMessage) (interface{}, *RPCError) {
+	acc, rpcErr := readRPCAccountParam(params)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	return node.state.GetNextAccountNonce(acc), nil
+}
+
+// rpcGetBlockByNumber takes a block number or "latest".
+func rpcGetBlockByNumber(node *Node, params json.RawMessage) (interface{}, *RPCError) {
+	var numberRaw json.RawMessage
+	rpcErr := readRPCParams(params, &numberRaw)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	var b database.Block
+	var err error
+
+	if string(numberRaw) == `"latest"` {
+		if node.state.LatestBlockHash().IsEmpty() {
+			return nil, rpcLookupErr(fmt.Errorf("latest block %w", database.ErrNotFound))
+		}
+		b = node.state.LatestBlock()
+	} else {
+		var number uint64
+		err = json.Unmarshal(numberRaw, &number)
+		if err != nil {
+			return nil, &RPCError{rpcErrInvalidParams, "block number must be a positive integer or 'latest'"}
+		}
+
+		b, err = node.state.GetBlockByNumber(number)
+		if err != nil {
+			return nil, rpcLookupErr(err)
+		}
+	}
+
+	res, err := newBlockRes(b)
+	if err != nil {
+		return nil, &RPCError{rpcErrInternal, err.Error()}
+	}
+
+	return res, nil
+}
+
+func rpcGetTransaction(node *Node, params json.RawMessage) (interface{}, *RPCError) {
+	txHash := database.Hash{}
+	rpcErr := readRPCParams(params, &txHash)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	res, err := lookupTx(node, txHash)
+	if err != nil {
+		return nil, rpcLookupErr(err)
+	}
+
+	return res, nil
+}
+
+// rpcSendRawTransaction takes a signed TX, either as a JSON object or hex encoded JSON.
+func rpcSendRawTransaction(node *Node, params json.RawMessage) (interface{}, *RPCError) {
+	var txRaw json.RawMessage
+	rpcErr := readRPCParams(params, &txRaw)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	txHex := ""
+	if json.Unmarshal(txRaw, &txHex) == nil {
+		var err error
+		txRaw, err = hex.DecodeString(txHex)
+		if err != nil {
+			return nil, &RPCError{rpcErrInvalidParams, fmt.Sprintf("invalid hex encoded TX. %s", err.Error())}
+		}
+	}
+
+	tx := database.SignedTx{}
+	err := json.Unmarshal(txRaw, &tx)
+	if err != nil {
+		return nil, &RPCError{rpcErrInvalidParams, fmt.Sprintf("invalid TX. %s", err.Error())}
+	}
+
+	txHash, err := node.SubmitTX(tx)
+	if err != nil {
+		return nil, &RPCError{rpcErrTxRejected, err.Error()}
+	}
+
+	return txHash, nil
+}
+
+func rpcStatus(node *Node, params json.RawMessage) (interface{}, *RPCError) {
+	rpcErr := readRPCParams(params)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	return newStatusRes(node), nil
+}
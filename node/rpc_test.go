@@ -0,0 +1,114 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/paulcockrell/blockchain/database"
+	"github.com/paulcockrell/blockchain/fs"
+	"github.com/paulcockrell/blockchain/wallet"
+)
+
+func TestRPC_Batch(t *testing.T) {
+	dataDir, paulc, babaYaga, err := setupTestNodeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveDir(dataDir)
+
+	n := New(dataDir, "127.0.0.1", 8085, paulc, PeerNode{})
+	n.state, err = database.NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.state.Close()
+
+	tx := database.NewTx(paulc, babaYaga, 10, 1, "")
+	signedTx, err := wallet.SignTxWithKeystoreAccount(tx, paulc, testKsAccountsPwd, wallet.GetKeystoreDirPath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedTxJSON, err := json.Marshal(signedTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txHash, _ := signedTx.Hash()
+
+	batch := fmt.Sprintf(`[
+		{"jsonrpc": "2.0", "id": 1, "method": "tbb_getBalance", "params": ["%[1]s"]},
+		{"jsonrpc": "2.0", "id": 2, "method": "tbb_sendRawTransaction", "params": [%[2]s]},
+		{"jsonrpc": "2.0", "id": 3, "method": "tbb_getTransaction", "params": ["%[3]s"]},
+		{"jsonrpc": "2.0", "id": 4, "method": "tbb_getBlockByNumber", "params": [0]},
+		{"jsonrpc": "2.0", "id": 5, "method": "tbb_getNonce", "params": []},
+		{"jsonrpc": "2.0", "id": 6, "method": "tbb_mine"},
+		{"jsonrpc": "2.0", "method": "tbb_status"},
+		{"id": 7, "method": "tbb_status"},
+		42
+	]`, paulc.Hex(), signedTxJSON, txHash.Hex())
+
+	responses := make([]struct {
+		ID     json.RawMessage `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  *RPCError       `json:"error"`
+	}, 0)
+	sendRPCReq(t, n, batch, &responses)
+
+	// The notification gets no response
+	if len(responses) != 8 {
+		t.Fatalf("expected 8 responses, got %d", len(responses))
+	}
+
+	if string(responses[0].Result) != "1000000" {
+		t.Fatalf("unexpected balance %s", responses[0].Result)
+	}
+
+	if string(responses[1].Result) != fmt.Sprintf(`"%s"`, txHash.Hex()) {
+		t.Fatalf("expected the sent TX hash, got %s %+v", responses[1].Result, responses[1].Error)
+	}
+
+	txRes := TxRes{}
+	err = json.Unmarshal(responses[2].Result, &txRes)
+	if err != nil || !txRes.Pending || txRes.TX.Value != 10 {
+		t.Fatalf("the sent TX should be pending, got %s", responses[2].Result)
+	}
+
+	expectedErrCodes := []int{rpcErrNotFound, rpcErrInvalidParams, rpcErrMethodNotFound, rpcErrInvalidRequest, rpcErrInvalidRequest}
+	for i, code := range expectedErrCodes {
+		res := responses[3+i]
+		if res.Error == nil || res.Error.Code != code {
+			t.Errorf("response %d should have failed with code %d, got %+v", 3+i, code, res.Error)
+		}
+	}
+
+	if string(responses[7].ID) != "null" {
+		t.Errorf("an invalid request should get a null id, not %s", responses[7].ID)
+	}
+
+	parseErrRes := struct {
+		Error *RPCError `json:"error"`
+	}{}
+	sendRPCReq(t, n, `{"jsonrpc": "2.0", "method"`, &parseErrRes)
+	if parseErrRes.Error == nil || parseErrRes.Error.Code != rpcErrParse {
+		t.Errorf("malformed JSON should fail with a parse error, got %+v", parseErrRes.Error)
+	}
+}
+
+func sendRPCReq(t *testing.T, n *Node, body string, res interface{}) {
+	req := httptest.NewRequest(http.MethodPost, endpointRPC, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	rpcHandler(rec, req, n)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected HTTP status %d", rec.Code)
+	}
+
+	err := json.Unmarshal(rec.Body.Bytes(), res)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
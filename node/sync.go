@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/paulcockrell/blockchain/database"
@@ -18,21 +19,48 @@ func (n *Node) sync(ctx context.Context) error {
 			n.doSync()
 		case <-ctx.Done():
 			ticker.Stop()
+
+			err := n.savePeers()
+			if err != nil {
+				fmt.Printf("ERROR: unable to save the peer book. %s\n", err)
+			}
+
+			return nil
 		}
 	}
 }
 
+// doSync syncs with every known peer, the best scored first, and saves their
+// scores to the peer book.
 func (n *Node) doSync() {
-	for _, peer := range n.knownPeers {
+	defer func() {
+		err := n.savePeers()
+		if err != nil {
+			fmt.Printf("ERROR: unable to save the peer book. %s\n", err)
+		}
+	}()
+
+	for _, peer := range n.getPeersByScore() {
 		if n.info.IP == peer.IP && n.info.Port == peer.Port {
 			continue
 		}
 
 		fmt.Printf("Searching for new peers and their blocks and peers: '%s'\n", peer.TcpAddress())
 
+		start := time.Now()
 		status, err := queryPeerStatus(peer)
+		isDropped := n.recordPeerQuery(peer, time.Since(start), err)
 		if err != nil {
 			fmt.Printf("Error: %s\n", err)
+			if isDropped {
+				fmt.Printf("Peer '%s' was removed from known peers after %d failed attempts\n", peer.TcpAddress(), maxPeerFailures)
+			}
+
+			continue
+		}
+
+		if status.GenesisHash != n.state.GenesisHash() {
+			fmt.Printf("Peer '%s' runs a chain with a different genesis '%s'\n", peer.TcpAddress(), status.GenesisHash.Hex())
 			fmt.Printf("Peer '%s' was removed from known peers\n", peer.TcpAddress())
 
 			n.RemovePeer(peer)
@@ -79,6 +107,11 @@ func (n *Node) syncBlocks(peer PeerNode, status StatusRes) error {
 		return nil
 	}
 
+	// If the peer is on the same head as us, there is nothing to sync
+	if status.Hash == n.state.LatestBlockHash() {
+		return nil
+	}
+
 	// If it's the genesis block and we already synced it, ignore it
 	if status.Number == 0 && !n.state.LatestBlockHash().IsEmpty() {
 		return nil
@@ -91,20 +124,34 @@ func (n *Node) syncBlocks(peer PeerNode, status StatusRes) error {
 	}
 	fmt.Printf("Found %d new blocks from Peer %s\n", newBlocksCount, peer.TcpAddress())
 
-	blocks, err := fetchBlocksFromPeer(peer, n.state.LatestBlockHash())
-	if err != nil {
-		return err
-	}
-
-	for _, block := range blocks {
-		_, err := n.state.AddBlock(block)
+	// The peer serves maxSyncBlocks at a time, the last block of a page being
+	// where the next one starts
+	fromBlock := n.state.LatestBlockHash()
+	for {
+		blocks, err := fetchBlocksFromPeer(peer, fromBlock, n.state.GenesisHash())
 		if err != nil {
 			return err
 		}
-		n.newSyncedBlocks <- block
-	}
 
-	return nil
+		for _, block := range blocks {
+			hash, reorg, err := n.state.ImportBlock(block)
+			n.recordServedBlock(peer, err)
+			if err != nil {
+				return err
+			}
+
+			if reorg != nil {
+				n.restoreReorgedTXs(reorg)
+			}
+
+			n.notifySyncedBlock(block)
+			fromBlock = hash
+		}
+
+		if len(blocks) < maxSyncBlocks || fromBlock == status.Hash {
+			return nil
+		}
+	}
 }
 
 func (n *Node) syncKnownPeers(status StatusRes) error {
@@ -118,11 +165,17 @@ func (n *Node) syncKnownPeers(status StatusRes) error {
 	return nil
 }
 
+// syncPendingTXs adds the peer's pending TXs which are valid on top of ours,
+// each sender's in nonce order, and skips the others.
 func (n *Node) syncPendingTXs(peer PeerNode, txs []database.SignedTx) error {
+	sort.SliceStable(txs, func(i, j int) bool {
+		return txs[i].Nonce < txs[j].Nonce
+	})
+
 	for _, tx := range txs {
 		err := n.AddPendingTX(tx, peer)
 		if err != nil {
-			return err
+			fmt.Printf("Skipping pending TX from Peer %s. %s\n", peer.TcpAddress(), err)
 		}
 	}
 
@@ -135,13 +188,17 @@ func (n *Node) joinKnownPeers(peer PeerNode) error {
 	}
 
 	url := fmt.Sprintf(
-		"http://%s%s?%s=%s&%s=%d",
+		"http://%s%s?%s=%s&%s=%d&%s=%s&%s=%s",
 		peer.TcpAddress(),
 		endpointAddPeer,
 		endpointAddPeerQueryKeyIP,
 		n.info.IP,
 		endpointAddPeerQueryKeyPort,
 		n.info.Port,
+		endpointAddPeerQueryKeyMiner,
+		n.info.Account.Hex(),
+		endpointAddPeerQueryKeyGenesis,
+		n.state.GenesisHash().Hex(),
 	)
 
 	res, err := http.Get(url)
@@ -158,10 +215,9 @@ func (n *Node) joinKnownPeers(peer PeerNode) error {
 		return fmt.Errorf(addPeerRes.Error)
 	}
 
-	knownPeer := n.knownPeers[peer.TcpAddress()]
-	knownPeer.connected = addPeerRes.Success
+	peer.connected = addPeerRes.Success
 
-	n.AddPeer(knownPeer)
+	n.AddPeer(peer)
 
 	if !addPeerRes.Success {
 		return fmt.Errorf("unable to join known peers of '%s'", peer.TcpAddress())
@@ -171,9 +227,9 @@ func (n *Node) joinKnownPeers(peer PeerNode) error {
 }
 func queryPeerStatus(peer PeerNode) (StatusRes, error) {
 	url := fmt.Sprintf("http://%s%s", peer.TcpAddress(), endpointStatus)
-	res, err := http.Get(url)
+	res, err := peerClient.Get(url)
 	if err != nil {
-		return StatusRes{}, nil
+		return StatusRes{}, err
 	}
 
 	statusRes := StatusRes{}
@@ -185,15 +241,17 @@ func queryPeerStatus(peer PeerNode) (StatusRes, error) {
 	return statusRes, nil
 }
 
-func fetchBlocksFromPeer(peer PeerNode, fromBlock database.Hash) ([]database.Block, error) {
+func fetchBlocksFromPeer(peer PeerNode, fromBlock database.Hash, genesis database.Hash) ([]database.Block, error) {
 	fmt.Printf("Importing blocks from peer %s...\n", peer.TcpAddress())
 
 	url := fmt.Sprintf(
-		"http://%s%s?%s=%s",
+		"http://%s%s?%s=%s&%s=%s",
 		peer.TcpAddress(),
 		endpointSync,
 		endpointSyncQueryKeyFromBlock,
 		fromBlock.Hex(),
+		endpointSyncQueryKeyGenesis,
+		genesis.Hex(),
 	)
 
 	res, err := http.Get(url)
@@ -0,0 +1,106 @@
+package node
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/paulcockrell/blockchain/consensus"
+	"github.com/paulcockrell/blockchain/database"
+)
+
+// External miners get a new work template at most every workRefreshSeconds,
+// unless the head changed, so newly pending TXs make it into the next ones.
+const workRefreshSeconds = 10
+
+// maxWorkTemplates is how many work templates the node remembers, older ones
+// can't be submitted anymore.
+const maxWorkTemplates = 16
+
+// getWork returns the header of the block external miners should mine, and
+// the ID to submit its nonce with, see submitWork.
+func (n *Node) getWork() (WorkRes, error) {
+	if _, isPoW := n.engine.(*consensus.PoW); !isPoW {
+		return WorkRes{}, fmt.Errorf("external miners need a proof of work chain")
+	}
+
+	n.workLock.Lock()
+	defer n.workLock.Unlock()
+
+	head := n.state.LatestBlockHash()
+
+	latest, isKnown := n.work[n.latestWork]
+	if isKnown && latest.Header.Parent == head && time.Since(n.latestWorkTime) < time.Second*workRefreshSeconds {
+		return WorkRes{n.latestWork, latest.Header}, nil
+	}
+
+	pb, err := n.newPendingBlock()
+	if err != nil {
+		return WorkRes{}, err
+	}
+
+	if len(pb.txs) == 0 {
+		return WorkRes{}, fmt.Errorf("no pending TXs to mine")
+	}
+
+	block := pb.newBlock()
+	id, err := block.Hash()
+	if err != nil {
+		return WorkRes{}, err
+	}
+
+	// Blocks on top of an old head would only end up on a side branch
+	for workID, b := range n.work {
+		if b.Header.Parent != head {
+			delete(n.work, workID)
+		}
+	}
+
+	n.work[id] = block
+	n.workOrder = append(n.workOrder, id)
+	for len(n.workOrder) > maxWorkTemplates {
+		delete(n.work, n.workOrder[0])
+		n.workOrder = n.workOrder[1:]
+	}
+
+	n.latestWork = id
+	n.latestWorkTime = time.Now()
+
+	return WorkRes{id, block.Header}, nil
+}
+
+// submitWork completes the work template with the nonces found by an external
+// miner. The block is validated and added like a block mined by the node.
+func (n *Node) submitWork(req SubmitWorkReq) (database.Hash, error) {
+	n.workLock.Lock()
+	block, isKnown := n.work[req.ID]
+	n.workLock.Unlock()
+
+	if !isKnown {
+		return database.Hash{}, fmt.Errorf("unknown or stale work '%s'", req.ID.Hex())
+	}
+
+	block.Header.Nonce = req.Nonce
+	block.Header.ExtraNonce = req.ExtraNonce
+
+	hash, err := block.Hash()
+	if err != nil {
+		return database.Hash{}, err
+	}
+
+	if !database.IsBlockHashValid(hash, block.Header.Difficulty) {
+		return database.Hash{}, fmt.Errorf("block hash '%s' doesn't meet the difficulty %d", hash.Hex(), block.Header.Difficulty)
+	}
+
+	if n.state.HasBlock(hash) {
+		return hash, nil
+	}
+
+	fmt.Printf("External miner mined Block '%s'\n", hash.Hex())
+
+	err = n.addMinedBlock(block)
+	if err != nil {
+		return database.Hash{}, err
+	}
+
+	return hash, nil
+}
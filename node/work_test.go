@@ -0,0 +1,175 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/paulcockrell/blockchain/consensus"
+	"github.com/paulcockrell/blockchain/database"
+	"github.com/paulcockrell/blockchain/fs"
+	"github.com/paulcockrell/blockchain/wallet"
+)
+
+func TestNode_ExternalMinerWork(t *testing.T) {
+	dataDir, paulc, babaYaga, err := setupTestNodeDirWithDifficulty(1 << 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveDir(dataDir)
+
+	n := New(dataDir, "127.0.0.1", 8085, paulc, PeerNode{})
+	n.state, err = database.NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.state.Close()
+	n.engine = consensus.NewPoW(2, nil)
+
+	// Nothing to mine yet
+	rec := httptest.NewRecorder()
+	getWorkHandler(rec, httptest.NewRequest(http.MethodGet, endpointWork, nil), n)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected no work without pending TXs, got HTTP status %d", rec.Code)
+	}
+
+	tx := database.NewTx(paulc, babaYaga, 10, 1, "")
+	signedTx, err := wallet.SignTxWithKeystoreAccount(tx, paulc, testKsAccountsPwd, wallet.GetKeystoreDirPath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = n.SubmitTX(signedTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	work := WorkRes{}
+	sendWorkReq(t, n, http.MethodGet, endpointWork, "", http.StatusOK, &work)
+
+	if work.Header.Parent != n.state.LatestBlockHash() || work.Header.Miner != paulc {
+		t.Fatalf("unexpected work header %+v", work.Header)
+	}
+
+	header, err := consensus.MineHeader(context.Background(), work.Header, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A nonce the block hash isn't valid with is refused
+	invalidNonce := header.Nonce
+	for {
+		invalidNonce++
+		invalidHeader := header
+		invalidHeader.Nonce = invalidNonce
+		invalidHash, _ := invalidHeader.Hash()
+		if !database.IsBlockHashValid(invalidHash, header.Difficulty) {
+			break
+		}
+	}
+	invalidReq, _ := json.Marshal(SubmitWorkReq{work.ID, invalidNonce, header.ExtraNonce})
+	sendWorkReq(t, n, http.MethodPost, endpointSubmitWork, string(invalidReq), http.StatusInternalServerError, &ErrRes{})
+
+	unknownReq, _ := json.Marshal(SubmitWorkReq{database.Hash{}, header.Nonce, header.ExtraNonce})
+	sendWorkReq(t, n, http.MethodPost, endpointSubmitWork, string(unknownReq), http.StatusInternalServerError, &ErrRes{})
+
+	submitReq, _ := json.Marshal(SubmitWorkReq{work.ID, header.Nonce, header.ExtraNonce})
+	submitRes := SubmitWorkRes{}
+	sendWorkReq(t, n, http.MethodPost, endpointSubmitWork, string(submitReq), http.StatusOK, &submitRes)
+
+	if submitRes.Hash != n.state.LatestBlockHash() {
+		t.Fatalf("expected the submitted block %s to be the head, got %s", submitRes.Hash.Hex(), n.state.LatestBlockHash().Hex())
+	}
+
+	if len(n.getPendingTXsAsArray()) != 0 {
+		t.Fatal("the mined TX should no longer be pending")
+	}
+
+	_, balances := n.state.LatestBalances()
+	if balances[babaYaga] != 10 {
+		t.Fatalf("expected babaYaga to own 10 tokens, got %d", balances[babaYaga])
+	}
+}
+
+func TestNode_WorkEvictsInvalidTXs(t *testing.T) {
+	dataDir, paulc, babaYaga, err := setupTestNodeDirWithDifficulty(1 << 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveDir(dataDir)
+
+	n := New(dataDir, "127.0.0.1", 8085, paulc, PeerNode{})
+	n.state, err = database.NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.state.Close()
+	n.engine = consensus.NewPoW(2, nil)
+
+	ksDir := wallet.GetKeystoreDirPath(dataDir)
+	validTx, err := wallet.SignTxWithKeystoreAccount(database.NewTx(paulc, babaYaga, 10, 1, ""), paulc, testKsAccountsPwd, ksDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// BabaYaga owns nothing in genesis, and only 10 tokens once the valid TX applied
+	unfundedTx, err := wallet.SignTxWithKeystoreAccount(database.NewTx(babaYaga, paulc, 50, 1, ""), babaYaga, testKsAccountsPwd, ksDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forgedTx := database.NewSignedTx(database.NewTx(paulc, babaYaga, 500, 2, ""), validTx.Sig)
+
+	// TXs the state refuses can still end up pending, restored by a reorg
+	// on top of a head where they no longer apply
+	n.lock.Lock()
+	for _, tx := range []database.SignedTx{validTx, unfundedTx, forgedTx} {
+		txHash, _ := tx.Hash()
+		n.pendingTXs[txHash.Hex()] = tx
+	}
+	n.lock.Unlock()
+
+	work := WorkRes{}
+	sendWorkReq(t, n, http.MethodGet, endpointWork, "", http.StatusOK, &work)
+
+	pendingTXs := n.getPendingTXsAsArray()
+	validTxHash, _ := validTx.Hash()
+	if pendingTXHash, _ := pendingTXs[0].Hash(); len(pendingTXs) != 1 || pendingTXHash != validTxHash {
+		t.Fatalf("the invalid TXs should be evicted, %d TXs are pending", len(pendingTXs))
+	}
+
+	header, err := consensus.MineHeader(context.Background(), work.Header, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	submitReq, _ := json.Marshal(SubmitWorkReq{work.ID, header.Nonce, header.ExtraNonce})
+	sendWorkReq(t, n, http.MethodPost, endpointSubmitWork, string(submitReq), http.StatusOK, &SubmitWorkRes{})
+
+	if balance := n.state.GetBalance(babaYaga); balance != 10 {
+		t.Fatalf("the block of the valid TX should be mined, babaYaga owns %d tokens", balance)
+	}
+}
+
+func sendWorkReq(t *testing.T, n *Node, method string, endpoint string, body string, expectedStatus int, res interface{}) {
+	req := httptest.NewRequest(method, endpoint, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	if endpoint == endpointWork {
+		getWorkHandler(rec, req, n)
+	} else {
+		submitWorkHandler(rec, req, n)
+	}
+
+	if rec.Code != expectedStatus {
+		t.Fatalf("expected HTTP status %d, got %d: %s", expectedStatus, rec.Code, rec.Body.String())
+	}
+
+	err := json.Unmarshal(rec.Body.Bytes(), res)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
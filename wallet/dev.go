@@ -0,0 +1,47 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// DevPrivateKey is the well known key of the account the --dev chain prefunds,
+// the SHA-256 of "the-blockchain-bar-dev". Anyone can spend its tokens, never
+// fund it on a real chain.
+const DevPrivateKey = "aebd01fb682a38b23eff88cc5502ec97bca38952ba1f4dc9496d8ccb997f68ed"
+
+// DevAccount is the address of DevPrivateKey
+const DevAccount = "0x3cb641e26603dd5215c5Ad039DB0b54538Ab0F6d"
+
+// DevAccountPwd encrypts the dev account in the keystore of a --dev node
+const DevAccountPwd = "dev"
+
+// DevKey returns the private key of the dev account.
+func DevKey() *ecdsa.PrivateKey {
+	// The constant is a valid secp256k1 key, it can't fail
+	key, _ := crypto.HexToECDSA(DevPrivateKey)
+
+	return key
+}
+
+// ImportDevAccount adds the dev account to the data dir keystore, encrypted
+// with DevAccountPwd, unless it's already there.
+func ImportDevAccount(dataDir string) error {
+	// The key is public anyway, the light scrypt parameters keep dev signing fast
+	ks := keystore.NewKeyStore(
+		GetKeystoreDirPath(dataDir),
+		keystore.LightScryptN,
+		keystore.LightScryptP,
+	)
+
+	key := DevKey()
+	if ks.HasAddress(crypto.PubkeyToAddress(key.PublicKey)) {
+		return nil
+	}
+
+	_, err := ks.ImportECDSA(key, DevAccountPwd)
+
+	return err
+}
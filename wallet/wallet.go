@@ -59,6 +59,21 @@ func SignTx(tx database.Tx, privKey *ecdsa.PrivateKey) (database.SignedTx, error
 }
 
 func SignTxWithKeystoreAccount(tx database.Tx, acc common.Address, pwd, keystoreDir string) (database.SignedTx, error) {
+	key, err := GetKeystoreKey(acc, pwd, keystoreDir)
+	if err != nil {
+		return database.SignedTx{}, err
+	}
+
+	signedTx, err := SignTx(tx, key)
+	if err != nil {
+		return database.SignedTx{}, err
+	}
+
+	return signedTx, nil
+}
+
+// GetKeystoreKey decrypts the private key of a keystore account.
+func GetKeystoreKey(acc common.Address, pwd, keystoreDir string) (*ecdsa.PrivateKey, error) {
 	ks := keystore.NewKeyStore(
 		keystoreDir,
 		keystore.StandardScryptN,
@@ -69,25 +84,20 @@ func SignTxWithKeystoreAccount(tx database.Tx, acc common.Address, pwd, keystore
 		Address: acc,
 	})
 	if err != nil {
-		return database.SignedTx{}, err
+		return nil, err
 	}
 
 	ksAccountJson, err := ioutil.ReadFile(ksAccount.URL.Path)
 	if err != nil {
-		return database.SignedTx{}, err
+		return nil, err
 	}
 
 	key, err := keystore.DecryptKey(ksAccountJson, pwd)
 	if err != nil {
-		return database.SignedTx{}, err
+		return nil, err
 	}
 
-	signedTx, err := SignTx(tx, key.PrivateKey)
-	if err != nil {
-		return database.SignedTx{}, err
-	}
-
-	return signedTx, nil
+	return key.PrivateKey, nil
 }
 
 func Sign(msg []byte, privKey *ecdsa.PrivateKey) (sig []byte, err error) {